@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/eval"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+)
+
+// GenerateDataset samples n movies with a plot from the knowledge graph, has
+// the synthesizer write queries for them and saves the queries that pass its
+// filters to path, for eval retrieval. Movies the LLM fails on are logged and
+// skipped.
+func GenerateDataset(ctx context.Context, kg knowledgegraph.KnowledgeGraph, synthesizer *eval.Synthesizer, n int, rng *rand.Rand, path string, w io.Writer) error {
+	movies, err := kg.GetMovies(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get movies: %w", err)
+	}
+
+	var queries []eval.Query
+	dropped, failed := 0, 0
+	for i, sampled := range eval.Sample(movies, n, rng) {
+		movie, err := kg.GetMovie(ctx, sampled.MovieID)
+		if err != nil {
+			return fmt.Errorf("failed to get movie %s: %w", sampled.MovieID, err)
+		}
+		kept, rejected, err := synthesizer.Queries(ctx, movie)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("movie %s: %v", movie.MovieID, err)
+			failed++
+			continue
+		}
+		for _, reason := range rejected {
+			log.Printf("dropped query for %s: %s", movie.Title, reason)
+		}
+		queries = append(queries, kept...)
+		dropped += len(rejected)
+		if (i+1)%10 == 0 {
+			log.Printf("wrote queries for %d of %d movies", i+1, min(n, len(movies)))
+		}
+	}
+	if len(queries) == 0 {
+		return errors.New("no queries were generated")
+	}
+
+	err = eval.SaveQueries(path, queries)
+	if err != nil {
+		return fmt.Errorf("failed to save dataset: %w", err)
+	}
+	fmt.Fprintf(w, "saved %d queries to %s, dropped %d, %d movies failed\n", len(queries), path, dropped, failed)
+	return nil
+}
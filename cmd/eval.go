@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/eval"
+)
+
+type RetrievalEvalOptions struct {
+	K int
+	// Baseline is a report of an earlier run to compare to; empty skips the
+	// comparison.
+	Baseline string
+	// Tolerance is how much a mean metric may drop below the baseline.
+	Tolerance float64
+	// Save is the file the report is written to, to serve as a later
+	// baseline. It is written after the comparison, and not when the run
+	// regressed, so it can be the baseline file itself.
+	Save string
+}
+
+// EvalRetrieval scores the retriever on the golden set and prints the metrics
+// of every query and their means. With a baseline it also prints the queries
+// that changed, and returns an error wrapping eval.ErrRegression when a mean
+// metric dropped by more than the tolerance.
+func EvalRetrieval(ctx context.Context, retrieve eval.Retriever, queries []eval.Query, opts RetrievalEvalOptions, w io.Writer) error {
+	// The baseline is read before the report can be saved over it.
+	var baseline eval.Report
+	if opts.Baseline != "" {
+		var err error
+		baseline, err = eval.LoadReport(opts.Baseline)
+		if err != nil {
+			return fmt.Errorf("failed to load baseline: %w", err)
+		}
+	}
+	report, err := eval.EvaluateRetrieval(ctx, queries, retrieve, opts.K)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "QUERY\tRECALL@%d\tPRECISION@%d\tMRR\tNDCG@%d\n", opts.K, opts.K, opts.K)
+	for _, q := range report.Queries {
+		printMetrics(tw, q.ID, q.Metrics)
+	}
+	printMetrics(tw, "mean", report.Mean)
+	err = tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	if opts.Baseline != "" {
+		err = compareToBaseline(baseline, report, opts, w)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.Save != "" {
+		err = report.Save(opts.Save)
+		if err != nil {
+			return fmt.Errorf("failed to save report: %w", err)
+		}
+	}
+	return nil
+}
+
+// compareToBaseline prints the changes against the baseline, and returns an
+// error wrapping eval.ErrRegression when a mean metric regressed.
+func compareToBaseline(baseline, report eval.Report, opts RetrievalEvalOptions, w io.Writer) error {
+	comparison, err := eval.Compare(baseline, report, opts.Tolerance)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\ncompared to %s:\n", opts.Baseline)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "QUERY\tRECALL\tPRECISION\tMRR\tNDCG")
+	for _, diff := range append(comparison.Changed, comparison.Mean) {
+		delta := diff.Delta()
+		fmt.Fprintf(tw, "%s\t%+.3f\t%+.3f\t%+.3f\t%+.3f\n", diff.ID, delta.Recall, delta.Precision, delta.MRR, delta.NDCG)
+	}
+	err = tw.Flush()
+	if err != nil {
+		return err
+	}
+	if len(comparison.Added) > 0 {
+		fmt.Fprintf(w, "new queries: %s\n", strings.Join(comparison.Added, ", "))
+	}
+	if len(comparison.Removed) > 0 {
+		fmt.Fprintf(w, "queries not in the golden set anymore: %s\n", strings.Join(comparison.Removed, ", "))
+	}
+	if len(comparison.Regressions) > 0 {
+		return fmt.Errorf("%w: %s", eval.ErrRegression, strings.Join(comparison.Regressions, ", "))
+	}
+	return nil
+}
+
+func printMetrics(w io.Writer, id string, m eval.Metrics) {
+	fmt.Fprintf(w, "%s\t%.3f\t%.3f\t%.3f\t%.3f\n", id, m.Recall, m.Precision, m.MRR, m.NDCG)
+}
+
+// AnswerVariant answers questions in one of the ways being compared, like
+// with a prompt template or a model.
+type AnswerVariant struct {
+	Name   string
+	Answer func(ctx context.Context, query eval.Query) (eval.Answer, error)
+}
+
+type AnswerEvalOptions struct {
+	// JSON and Markdown are the files the report is written to; empty skips
+	// them.
+	JSON     string
+	Markdown string
+}
+
+// EvalAnswers answers every question with every variant, has the judge grade
+// the answers and prints the mean grades per variant. The variants answer a
+// question one after the other, so they can share its retrieval. A failed
+// answer or judgement is recorded in the report rather than stopping the run.
+func EvalAnswers(ctx context.Context, queries []eval.Query, variants []AnswerVariant, judge *eval.Judge, opts AnswerEvalOptions, w io.Writer) error {
+	var names []string
+	for _, variant := range variants {
+		names = append(names, variant.Name)
+	}
+
+	var results []eval.AnswerResult
+	for i, query := range queries {
+		for _, variant := range variants {
+			result := eval.AnswerResult{Variant: variant.Name}
+			answer, err := variant.Answer(ctx, query)
+			if err == nil {
+				result.Answer = answer
+				result.Judgement, err = judge.Judge(ctx, answer)
+			} else {
+				result.Answer = eval.Answer{QuestionID: query.ID, Question: query.Query}
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				log.Printf("question %s, %s: %v", query.ID, variant.Name, err)
+				result.Error = err.Error()
+			}
+			results = append(results, result)
+		}
+		log.Printf("judged %d of %d questions", i+1, len(queries))
+	}
+	report := eval.NewAnswerReport(names, results)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VARIANT\tANSWERS\tFAILED\tFAITHFULNESS\tRELEVANCE\tOUT OF LIST")
+	for _, v := range report.Variants {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f\t%.2f\t%.0f%%\n", v.Variant, v.Answers, v.Failed, v.Faithfulness, v.Relevance, 100*v.OutOfList)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	if opts.JSON != "" {
+		err = report.Save(opts.JSON)
+		if err != nil {
+			return fmt.Errorf("failed to save report: %w", err)
+		}
+	}
+	if opts.Markdown != "" {
+		err = os.WriteFile(opts.Markdown, []byte(report.Markdown()), 0644)
+		if err != nil {
+			return fmt.Errorf("failed to save markdown report: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+// Command fakeollama serves a fake Ollama with deterministic answers and
+// embeddings, to run the app without models, as in
+//
+//	go run ./cmd/fakeollama -addr localhost:11434
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/fakeollama"
+)
+
+func main() {
+	addrFlag := flag.String("addr", "localhost:11434", "address to listen on")
+	modelsFlag := flag.String("models", "", "comma-separated models to serve; empty serves any model")
+	dimensionsFlag := flag.Int("dimensions", 384, "length of the embeddings")
+	numCtxFlag := flag.Int("num-ctx", 2048, "context window reported for the models")
+	answerFlag := flag.String("answer", fakeollama.DefaultAnswer, "text/template of the answers, with .Model and .Prompt")
+	flag.Parse()
+	if *dimensionsFlag < 1 {
+		log.Fatal("-dimensions must be at least 1")
+	}
+
+	var models []string
+	if *modelsFlag != "" {
+		models = strings.Split(*modelsFlag, ",")
+	}
+	server := fakeollama.New(models...)
+	server.Dimensions = *dimensionsFlag
+	server.NumCtx = *numCtxFlag
+	answer, err := template.New("answer").Parse(*answerFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.Answer = answer
+
+	log.Printf("fake Ollama listening on %s", *addrFlag)
+	log.Fatal(http.ListenAndServe(*addrFlag, server))
+}
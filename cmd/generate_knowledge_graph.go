@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/chunking"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/embeddings"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/extraction"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/resolution"
+)
+
+type GraphOptions struct {
+	// Chunker splits the documents into chunks of at most ChunkSize bytes,
+	// overlapping by ChunkOverlap. Nil uses chunking.FixedSize.
+	Chunker      chunking.Func
+	ChunkSize    int
+	ChunkOverlap int
+	// Embedder embeds every chunk, so chunks can be searched by similarity.
+	// Nil stores the chunks without embedding.
+	Embedder embeddings.Embeddings
+	// SkipFacts only stores the chunks of the documents, without asking the
+	// LLM for their entities and relationships.
+	SkipFacts bool
+	// Resolver merges entities that refer to the same thing before they are
+	// written. Nil disables entity resolution.
+	Resolver *resolution.Resolver
+	// AuditLog is the file the merge decisions of the resolver are appended
+	// to. Empty disables the audit log.
+	AuditLog string
+	// DryRun extracts and resolves the facts and reports the merges, but
+	// doesn't write to the graph, which may then be nil.
+	DryRun bool
+}
+
+// extractedChunk holds the facts extracted from one chunk.
+type extractedChunk struct {
+	chunk knowledgegraph.TextChunk
+	facts extraction.Extraction
+}
+
+// GenerateKnowledgeGraph builds a knowledge graph from text files. Every file
+// is split into chunks, which are embedded, and the LLM extracts the entities
+// and relationships of each chunk. The entities of all chunks are then
+// resolved, so different names for the same entity end up in one node, and the
+// chunks and their validated facts are merged into the graph with a link back
+// to the chunk they came from. A chunk that fails doesn't stop the others; all
+// failures are returned together.
+func GenerateKnowledgeGraph(ctx context.Context, kg knowledgegraph.KnowledgeGraph, extractor *extraction.Extractor, files []string, opts GraphOptions) error {
+	var errs []error
+
+	chunker := opts.Chunker
+	if chunker == nil {
+		chunker = chunking.FixedSize
+	}
+
+	var extracted []extractedChunk
+	dimensions := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		chunks := chunker(string(data), opts.ChunkSize, opts.ChunkOverlap)
+		log.Printf("processing %s in %d chunks", file, len(chunks))
+		for _, chunk := range chunks {
+			textChunk := knowledgegraph.TextChunk{
+				ChunkID:    fmt.Sprintf("%s#%d", file, chunk.Index),
+				DocumentID: file,
+				Index:      chunk.Index,
+				Text:       chunk.Text,
+				Heading:    chunk.Heading,
+			}
+
+			if opts.Embedder != nil {
+				embedding, err := opts.Embedder.Embedding(ctx, chunk.WithHeading())
+				if err != nil {
+					errs = append(errs, fmt.Errorf("chunk %s: %w", textChunk.ChunkID, err))
+					continue
+				}
+				textChunk.Embedding = embedding.Embedding
+				dimensions = len(embedding.Embedding)
+			}
+
+			var facts extraction.Extraction
+			if !opts.SkipFacts {
+				facts, err = extractor.Extract(ctx, chunk.WithHeading())
+				if err != nil {
+					errs = append(errs, fmt.Errorf("chunk %s: %w", textChunk.ChunkID, err))
+					continue
+				}
+				for _, reason := range facts.Dropped {
+					log.Printf("chunk %s: dropped %s", textChunk.ChunkID, reason)
+				}
+			}
+			extracted = append(extracted, extractedChunk{chunk: textChunk, facts: facts})
+		}
+	}
+
+	if opts.Resolver != nil {
+		err := resolve(ctx, extracted, opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.DryRun {
+		return joinErrors(errs)
+	}
+
+	err := kg.EnsureExtractionSchema(ctx)
+	if err != nil {
+		return err
+	}
+	if dimensions > 0 {
+		err = kg.EnsureChunkIndex(ctx, dimensions)
+		if err != nil {
+			return err
+		}
+	}
+	for _, e := range extracted {
+		err := kg.MergeChunkFacts(ctx, e.chunk, e.facts.Entities, e.facts.Relations)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("chunk %s: %w", e.chunk.ChunkID, err))
+			continue
+		}
+		log.Printf("chunk %s: merged %d entities and %d relationships", e.chunk.ChunkID, len(e.facts.Entities), len(e.facts.Relations))
+	}
+
+	return joinErrors(errs)
+}
+
+// resolve resolves the entities of all chunks together and rewrites the facts
+// of every chunk to the canonical entities.
+func resolve(ctx context.Context, extracted []extractedChunk, opts GraphOptions) error {
+	var entities []knowledgegraph.Entity
+	for _, e := range extracted {
+		entities = append(entities, e.facts.Entities...)
+	}
+
+	result, err := opts.Resolver.Resolve(ctx, entities)
+	if err != nil {
+		return fmt.Errorf("failed to resolve entities: %w", err)
+	}
+	err = result.Report(os.Stdout)
+	if err != nil {
+		return err
+	}
+	if opts.AuditLog != "" && !opts.DryRun {
+		err = result.AppendAuditLog(opts.AuditLog)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range extracted {
+		facts := &extracted[i].facts
+		facts.Entities, facts.Relations = result.Apply(facts.Entities, facts.Relations)
+	}
+	return nil
+}
+
+func joinErrors(errs []error) error {
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to generate knowledge graph: %w", errors.Join(errs...))
+	}
+	return nil
+}
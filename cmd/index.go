@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/hnsw"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+)
+
+type IndexOptions struct {
+	// M, EfConstruction and EfSearch configure a new index; an existing index
+	// keeps its own.
+	M              int
+	EfConstruction int
+	EfSearch       int
+	// Rebuild builds the index from scratch instead of updating it.
+	Rebuild bool
+}
+
+// BuildVectorIndex builds or updates the HNSW index at path from the plot
+// embeddings stored in the knowledge graph. An existing index is updated
+// incrementally: new and changed embeddings are added and movies that lost
+// their embedding are removed. When more than half of the index is removed
+// vectors, it is compacted.
+func BuildVectorIndex(ctx context.Context, kg knowledgegraph.KnowledgeGraph, path string, opts IndexOptions) error {
+	index, err := hnsw.Load(path)
+	if errors.Is(err, os.ErrNotExist) || opts.Rebuild {
+		index = hnsw.New(opts.M, opts.EfConstruction, opts.EfSearch)
+	} else if err != nil {
+		return err
+	}
+
+	movies, err := kg.GetEmbeddings(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get embeddings: %w", err)
+	}
+
+	stored := map[string]bool{}
+	added := 0
+	for _, movie := range movies {
+		stored[movie.MovieID] = true
+		if index.Contains(movie.MovieID, movie.Embedding) {
+			continue
+		}
+		err := index.Add(movie.MovieID, movie.Embedding)
+		if err != nil {
+			return fmt.Errorf("movie %s: %w", movie.MovieID, err)
+		}
+		added++
+		if added%1000 == 0 {
+			log.Printf("indexed %d embeddings", added)
+		}
+	}
+
+	removed := 0
+	for _, id := range index.IDs() {
+		if !stored[id] {
+			index.Remove(id)
+			removed++
+		}
+	}
+
+	if index.Tombstones() > index.Len() {
+		log.Printf("compacting index with %d removed vectors", index.Tombstones())
+		err = index.Compact()
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("index has %d embeddings: %d added or changed, %d removed", index.Len(), added, removed)
+	return index.Save(path)
+}
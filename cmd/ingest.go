@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/ingest"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+)
+
+// Ingest loads the sources of the mapping into the knowledge graph. The
+// uniqueness constraints on the keys are created first, so merging nodes is
+// indexed, and the rows of every source are upserted in transactions of
+// batchSize rows. Rows that can't be converted are skipped; they are returned
+// together once all sources are loaded.
+func Ingest(ctx context.Context, kg knowledgegraph.KnowledgeGraph, mapping ingest.Mapping, batchSize int) error {
+	for _, key := range mapping.Keys() {
+		err := kg.EnsureUniqueConstraint(ctx, key[0], key[1])
+		if err != nil {
+			return fmt.Errorf("failed to create constraint on %s.%s: %w", key[0], key[1], err)
+		}
+	}
+
+	var errs []error
+	for _, source := range mapping.Sources {
+		rows := 0
+		err := source.ReadRows(batchSize, func(batch []ingest.Row) error {
+			nodes, relationships, rowErrs := source.Batches(batch)
+			errs = append(errs, rowErrs...)
+			err := kg.Upsert(ctx, nodes, relationships)
+			if err != nil {
+				return fmt.Errorf("failed to upsert rows %d-%d: %w", batch[0].Line, batch[len(batch)-1].Line, err)
+			}
+			rows += len(batch)
+			log.Printf("%s: upserted %d rows", source.File, rows)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to ingest %s: %w", source.File, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("skipped %d rows: %w", len(errs), errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/ollama"
+)
+
+// ListModels prints the models pulled to the Ollama server.
+func ListModels(ctx context.Context, client *ollama.Client, w io.Writer) error {
+	models, err := client.ListModels(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tPARAMETERS\tQUANTIZATION\tSIZE\tMODIFIED")
+	for _, model := range models {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.1f GB\t%s\n", model.Name, model.Details.ParameterSize, model.Details.QuantizationLevel, float64(model.Size)/1e9, model.ModifiedAt.Format("2006-01-02"))
+	}
+	return tw.Flush()
+}
+
+// PullModel pulls a model and prints its progress, one line per status and
+// per 10% of a layer.
+func PullModel(ctx context.Context, client *ollama.Client, model string, w io.Writer) error {
+	var status string
+	var percent int64
+	err := client.Pull(ctx, model, func(p ollama.PullProgress) {
+		if p.Status != status {
+			status, percent = p.Status, -10
+			if p.Total == 0 {
+				fmt.Fprintln(w, p.Status)
+			}
+		}
+		if p.Total == 0 {
+			return
+		}
+		if done := 100 * p.Completed / p.Total; done/10 > percent/10 {
+			percent = done
+			fmt.Fprintf(w, "%s: %d%% of %.1f MB\n", p.Status, done, float64(p.Total)/1e6)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "pulled %s\n", model)
+	return nil
+}
+
+// ShowModel prints the details of a model.
+func ShowModel(ctx context.Context, client *ollama.Client, model string, w io.Writer) error {
+	info, err := client.Show(ctx, model)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%s: %s\n", model, info)
+	if len(info.Capabilities) > 0 {
+		fmt.Fprintf(w, "capabilities: %v\n", info.Capabilities)
+	}
+	return nil
+}
+
+// CheckModel checks that a model is pulled to the Ollama server and returns its
+// details.
+func CheckModel(ctx context.Context, client *ollama.Client, model string) (ollama.ModelInfo, error) {
+	info, err := client.Show(ctx, model)
+	if errors.Is(err, ollama.ErrNotFound) {
+		return ollama.ModelInfo{}, fmt.Errorf("model %s is not pulled, run `models pull %s`", model, model)
+	}
+	return info, err
+}
@@ -0,0 +1,100 @@
+package chunking
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Chunk is a piece of a document. Start and End are byte offsets into the
+// document. Heading is the path of markdown headings the chunk is under.
+type Chunk struct {
+	Index   int    `json:"index"`
+	Text    string `json:"text"`
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
+	Heading string `json:"heading,omitempty"`
+}
+
+// WithHeading returns the text of the chunk preceded by its heading, which
+// gives a chunk from the middle of a document its context.
+func (c Chunk) WithHeading() string {
+	if c.Heading == "" {
+		return c.Text
+	}
+	return c.Heading + "\n\n" + c.Text
+}
+
+// Func splits text into chunks of at most size bytes that overlap by about
+// overlap bytes.
+type Func func(text string, size, overlap int) []Chunk
+
+// Strategy returns the chunking function with the given name: fixed,
+// sentence or markdown.
+func Strategy(name string) (Func, error) {
+	switch name {
+	case "fixed":
+		return FixedSize, nil
+	case "sentence":
+		return Sentences, nil
+	case "markdown":
+		return Markdown, nil
+	}
+	return nil, fmt.Errorf("unknown chunking strategy %q", name)
+}
+
+// FixedSize splits text into chunks of at most size bytes, each overlapping
+// the previous one by about overlap bytes. Chunks are cut at whitespace when
+// there is some in the second half of the chunk, so words stay whole.
+func FixedSize(text string, size, overlap int) []Chunk {
+	if size <= 0 {
+		return nil
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+
+	var chunks []Chunk
+	start := 0
+	for start < len(text) {
+		end := min(start+size, len(text))
+		if end < len(text) {
+			if cut := strings.LastIndexAny(text[start:end], " \t\n"); cut > size/2 {
+				end = start + cut
+			}
+			for end > start && !utf8.RuneStart(text[end]) {
+				end--
+			}
+			if end == start {
+				// size is smaller than the rune at start
+				_, n := utf8.DecodeRuneInString(text[start:])
+				end = start + n
+			}
+		}
+
+		if strings.TrimSpace(text[start:end]) != "" {
+			chunks = append(chunks, Chunk{
+				Index: len(chunks),
+				Text:  strings.TrimSpace(text[start:end]),
+				Start: start,
+				End:   end,
+			})
+		}
+		if end == len(text) {
+			break
+		}
+
+		next := end - overlap
+		if next <= start {
+			next = end
+		} else if space := strings.IndexAny(text[next:end], " \t\n"); space >= 0 {
+			// don't start the overlap halfway through a word
+			next += space + 1
+		}
+		for next < len(text) && !utf8.RuneStart(text[next]) {
+			next++
+		}
+		start = next
+	}
+	return chunks
+}
@@ -0,0 +1,86 @@
+package chunking
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func texts(chunks []Chunk) []string {
+	strs := []string{}
+	for _, c := range chunks {
+		strs = append(strs, c.Text)
+	}
+	return strs
+}
+
+// checkChunks checks what every strategy promises: chunks are numbered in
+// order, fit in size, are valid UTF-8, and their text is at their offsets.
+func checkChunks(t *testing.T, text string, chunks []Chunk, size int) {
+	t.Helper()
+	for i, c := range chunks {
+		if c.Index != i {
+			t.Errorf("chunk %d has index %d", i, c.Index)
+		}
+		if len(c.Text) > size && utf8.RuneCountInString(c.Text) > 1 {
+			t.Errorf("chunk %d has %d bytes, more than %d", i, len(c.Text), size)
+		}
+		if !utf8.ValidString(c.Text) {
+			t.Errorf("chunk %d is not valid UTF-8: %q", i, c.Text)
+		}
+		if c.Start < 0 || c.End > len(text) || strings.TrimSpace(text[c.Start:c.End]) != c.Text {
+			t.Errorf("chunk %d text %q isn't at %d:%d", i, c.Text, c.Start, c.End)
+		}
+	}
+}
+
+func TestFixedSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		text          string
+		size, overlap int
+		want          []string
+	}{
+		{"cut at whitespace", "aaaa bbbb cccc dddd", 10, 0, []string{"aaaa bbbb", "cccc dddd"}},
+		{"overlap starts at a word", "aaaa bbbb cccc dddd", 10, 5, []string{"aaaa bbbb", "bbbb cccc", "cccc dddd"}},
+		{"overlap too large is ignored", "aaaa bbbb cccc dddd", 10, 10, []string{"aaaa bbbb", "cccc dddd"}},
+		{"no whitespace", "abcdefghij", 4, 0, []string{"abcd", "efgh", "ij"}},
+		{"whole runes", "ééé", 3, 0, []string{"é", "é", "é"}},
+		{"size smaller than a rune", "é", 1, 0, []string{"é"}},
+		{"fits", "short", 100, 10, []string{"short"}},
+		{"only whitespace", "   \n ", 2, 0, []string{}},
+		{"no size", "text", 0, 0, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := FixedSize(tt.text, tt.size, tt.overlap)
+			if got := texts(chunks); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FixedSize = %q, want %q", got, tt.want)
+			}
+			checkChunks(t, tt.text, chunks, tt.size)
+		})
+	}
+}
+
+func TestStrategy(t *testing.T) {
+	for _, name := range []string{"fixed", "sentence", "markdown"} {
+		chunker, err := Strategy(name)
+		if err != nil || chunker == nil {
+			t.Errorf("Strategy(%q) = %v, %v", name, chunker, err)
+		}
+	}
+	_, err := Strategy("paragraph")
+	if err == nil {
+		t.Error("Strategy of an unknown name succeeded")
+	}
+}
+
+func TestWithHeading(t *testing.T) {
+	if got := (Chunk{Text: "Toys live."}).WithHeading(); got != "Toys live." {
+		t.Errorf("WithHeading without heading = %q", got)
+	}
+	if got := (Chunk{Text: "Toys live.", Heading: "# Plot"}).WithHeading(); got != "# Plot\n\nToys live." {
+		t.Errorf("WithHeading = %q", got)
+	}
+}
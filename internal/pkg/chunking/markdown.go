@@ -0,0 +1,71 @@
+package chunking
+
+import (
+	"strings"
+)
+
+// Markdown splits a markdown document at its headings, and each section into
+// chunks of whole sentences with Sentences. Every chunk keeps the path of
+// headings it is under, like "# Movies > ## Plot", in Heading. Lines in
+// fenced code blocks are never taken for headings.
+func Markdown(text string, size, overlap int) []Chunk {
+	type section struct {
+		heading    string
+		start, end int
+	}
+
+	var (
+		sections []section
+		path     []string
+		inFence  bool
+	)
+	current := section{}
+	for offset := 0; offset < len(text); {
+		lineEnd := strings.IndexByte(text[offset:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(text)
+		} else {
+			lineEnd += offset + 1
+		}
+		line := strings.TrimSpace(text[offset:lineEnd])
+
+		if strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~") {
+			inFence = !inFence
+		}
+		if level := headingLevel(line); level > 0 && !inFence {
+			current.end = offset
+			sections = append(sections, current)
+
+			if level <= len(path) {
+				path = path[:level-1]
+			}
+			path = append(path, line)
+			current = section{heading: strings.Join(path, " > "), start: lineEnd}
+		}
+		offset = lineEnd
+	}
+	current.end = len(text)
+	sections = append(sections, current)
+
+	var chunks []Chunk
+	for _, s := range sections {
+		chunks = append(chunks, pack(text, sentenceSpans(text, s.start, s.end), size, overlap, s.heading)...)
+	}
+	for i := range chunks {
+		chunks[i].Index = i
+	}
+	return chunks
+}
+
+// headingLevel returns the level of an ATX heading like "## Plot", or 0 when
+// the line is not a heading.
+func headingLevel(line string) int {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || (level < len(line) && line[level] != ' ') {
+		return 0
+	}
+	return level
+}
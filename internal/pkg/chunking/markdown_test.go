@@ -0,0 +1,56 @@
+package chunking
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarkdown(t *testing.T) {
+	text := "Preface.\n# Movies\nIntro.\n## Plot\nToys live.\n```\n# not a heading\n```\n#hashtag\n# Cast\nTom. Tim."
+	chunks := Markdown(text, 100, 0)
+	checkChunks(t, text, chunks, 100)
+
+	type headed struct{ Heading, Text string }
+	var got []headed
+	for _, c := range chunks {
+		got = append(got, headed{c.Heading, c.Text})
+	}
+	want := []headed{
+		{"", "Preface."},
+		{"# Movies", "Intro."},
+		{"# Movies > ## Plot", "Toys live.\n```\n# not a heading\n```\n#hashtag"},
+		{"# Cast", "Tom. Tim."},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Markdown = %q, want %q", got, want)
+	}
+}
+
+func TestMarkdownSplitsSections(t *testing.T) {
+	text := "# Plot\nToys come to life. Their owner is away.\n## Cast\nTom."
+	chunks := Markdown(text, 20, 0)
+	checkChunks(t, text, chunks, 20)
+	want := []string{"Toys come to life.", "Their owner is away.", "Tom."}
+	if got := texts(chunks); !reflect.DeepEqual(got, want) {
+		t.Errorf("Markdown = %q, want %q", got, want)
+	}
+	if chunks[1].Heading != "# Plot" || chunks[2].Heading != "# Plot > ## Cast" {
+		t.Errorf("headings = %q, %q", chunks[1].Heading, chunks[2].Heading)
+	}
+}
+
+func TestHeadingLevel(t *testing.T) {
+	tests := map[string]int{
+		"# Movies":    1,
+		"### Plot":    3,
+		"#hashtag":    0,
+		"####### Too": 0,
+		"Text # not":  0,
+		"#":           1,
+	}
+	for line, want := range tests {
+		if got := headingLevel(line); got != want {
+			t.Errorf("headingLevel(%q) = %d, want %d", line, got, want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package chunking
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// span is a byte range of a text.
+type span struct {
+	start, end int
+}
+
+// Sentences splits text into chunks of whole sentences of at most size bytes.
+// Consecutive chunks share the last sentences of the previous chunk, as long
+// as they fit in overlap bytes. A sentence longer than size is split with
+// FixedSize.
+func Sentences(text string, size, overlap int) []Chunk {
+	return pack(text, sentenceSpans(text, 0, len(text)), size, overlap, "")
+}
+
+// sentenceSpans returns the sentences of text[start:end]. A sentence ends
+// after '.', '!' or '?' (and any closing quotes or brackets) followed by
+// whitespace, or at a blank line.
+func sentenceSpans(text string, start, end int) []span {
+	var spans []span
+	add := func(from, to int) {
+		for from < to && isSpace(text[from]) {
+			from++
+		}
+		for to > from && isSpace(text[to-1]) {
+			to--
+		}
+		if from < to {
+			spans = append(spans, span{from, to})
+		}
+	}
+
+	from := start
+	for i := start; i < end; {
+		r, n := utf8.DecodeRuneInString(text[i:end])
+		next := i + n
+		switch {
+		case r == '\n' && strings.HasPrefix(strings.TrimLeft(text[next:end], " \t"), "\n"):
+			add(from, i)
+			from = next
+		case r == '.' || r == '!' || r == '?':
+			for next < end {
+				closing, n := utf8.DecodeRuneInString(text[next:end])
+				if !strings.ContainsRune(`"')]”’`, closing) {
+					break
+				}
+				next += n
+			}
+			if next == end || isSpace(text[next]) {
+				add(from, next)
+				from = next
+			}
+		}
+		i = next
+	}
+	add(from, end)
+	return spans
+}
+
+// pack groups consecutive spans into chunks of at most size bytes, each
+// starting with the trailing spans of the previous chunk that fit in overlap
+// bytes.
+func pack(text string, spans []span, size, overlap int, heading string) []Chunk {
+	var chunks []Chunk
+	for i := 0; i < len(spans); {
+		start := spans[i].start
+		if spans[i].end-start > size {
+			for _, c := range FixedSize(text[start:spans[i].end], size, overlap) {
+				c.Start += start
+				c.End += start
+				c.Heading = heading
+				chunks = append(chunks, c)
+			}
+			i++
+			continue
+		}
+
+		j := i
+		for j+1 < len(spans) && spans[j+1].end-start <= size {
+			j++
+		}
+		chunks = append(chunks, Chunk{
+			Text:    text[start:spans[j].end],
+			Start:   start,
+			End:     spans[j].end,
+			Heading: heading,
+		})
+		if j+1 == len(spans) {
+			break
+		}
+
+		// step back over the sentences that fit in the overlap, as long as
+		// the next chunk still has room for a new sentence
+		next := j + 1
+		for next-1 > i && spans[j].end-spans[next-1].start <= overlap && spans[j+1].end-spans[next-1].start <= size {
+			next--
+		}
+		i = next
+	}
+
+	for i := range chunks {
+		chunks[i].Index = i
+	}
+	return chunks
+}
+
+func isSpace(b byte) bool {
+	return b < utf8.RuneSelf && unicode.IsSpace(rune(b))
+}
@@ -0,0 +1,33 @@
+package chunking
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSentences(t *testing.T) {
+	tests := []struct {
+		name          string
+		text          string
+		size, overlap int
+		want          []string
+	}{
+		{"packs whole sentences", "One. Two! Three? Four.", 10, 0, []string{"One. Two!", "Three?", "Four."}},
+		{"overlaps by sentences", "One. Two! Three? Four.", 12, 6, []string{"One. Two!", "Two! Three?", "Three? Four."}},
+		{"closing quotes", `He said "Hi." Then he left.`, 15, 0, []string{`He said "Hi."`, "Then he left."}},
+		{"no break inside numbers", "It costs 3.5 dollars. Cheap.", 21, 0, []string{"It costs 3.5 dollars.", "Cheap."}},
+		{"blank lines end sentences", "Title\n\nBody text", 10, 0, []string{"Title", "Body text"}},
+		{"long sentence is split", "Short. A sentence that is too long to fit.", 12, 0, []string{"Short.", "A sentence", "that is", "too long", "to fit."}},
+		{"fits", "One. Two.", 100, 10, []string{"One. Two."}},
+		{"empty", " \n ", 10, 0, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := Sentences(tt.text, tt.size, tt.overlap)
+			if got := texts(chunks); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Sentences = %q, want %q", got, tt.want)
+			}
+			checkChunks(t, tt.text, chunks, tt.size)
+		})
+	}
+}
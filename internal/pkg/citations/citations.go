@@ -0,0 +1,112 @@
+// Package citations labels the context of a prompt with stable IDs, finds the
+// IDs the model cites in its answer and renders them as a list of sources.
+package citations
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+)
+
+// Source is an item of the context that can be cited.
+type Source struct {
+	// ID is the stable ID of the item, such as movie:1 for the movie with
+	// movieId 1, or passage: followed by the chunkId of a passage.
+	ID    string
+	Title string
+	Links []string
+}
+
+// MovieSource returns the source of a movie, with its IMDb and TMDb pages.
+func MovieSource(movie knowledgegraph.Movie) Source {
+	title := movie.Title
+	if movie.Year > 0 && !strings.Contains(title, fmt.Sprint(movie.Year)) {
+		title = fmt.Sprintf("%s (%d)", title, movie.Year)
+	}
+	var links []string
+	if movie.ImdbID != "" {
+		// The recommendations dataset stores IMDb IDs without the tt prefix.
+		links = append(links, fmt.Sprintf("https://www.imdb.com/title/tt%s/", strings.TrimPrefix(movie.ImdbID, "tt")))
+	}
+	if movie.TmdbID != "" {
+		links = append(links, fmt.Sprintf("https://www.themoviedb.org/movie/%s", movie.TmdbID))
+	}
+	if movie.URL != "" && !strings.Contains(movie.URL, "themoviedb.org/movie/"+movie.TmdbID) {
+		links = append(links, movie.URL)
+	}
+	return Source{ID: "movie:" + movie.MovieID, Title: title, Links: links}
+}
+
+// PassageSource returns the source of a passage, titled with its document and
+// section.
+func PassageSource(passage knowledgegraph.ChunkMatch) Source {
+	title := passage.Chunk.DocumentID
+	if passage.Chunk.Heading != "" {
+		title += ", " + passage.Chunk.Heading
+	}
+	return Source{ID: "passage:" + passage.Chunk.ChunkID, Title: title}
+}
+
+// Label returns the line that labels a source in the prompt.
+func Label(source Source) string {
+	return fmt.Sprintf("ID: [%s]\n", source.ID)
+}
+
+// Instructions tell the model how to cite.
+const Instructions = `
+Every movie and passage has an ID in square brackets. Cite the ID of every movie or passage you use,
+right after the claim, like [movie:1]. Only cite IDs that are listed above.
+`
+
+// brackets matches text in square brackets, which may hold several IDs.
+var brackets = regexp.MustCompile(`\[([^\[\]\n]+)\]`)
+
+// Parse returns the sources cited in the answer, in the order they are first
+// cited, and the cited IDs that aren't among the sources, which the model made
+// up. Bracketed text that doesn't look like an ID is ignored.
+func Parse(answer string, sources []Source) ([]Source, []string) {
+	byID := map[string]Source{}
+	for _, source := range sources {
+		byID[source.ID] = source
+	}
+
+	var cited []Source
+	var unknown []string
+	seen := map[string]bool{}
+	for _, match := range brackets.FindAllStringSubmatch(answer, -1) {
+		for _, id := range strings.FieldsFunc(match[1], func(r rune) bool { return r == ',' || r == ';' }) {
+			id = strings.TrimSpace(id)
+			kind, _, ok := strings.Cut(id, ":")
+			if !ok || (kind != "movie" && kind != "passage") || seen[id] {
+				continue
+			}
+			seen[id] = true
+			source, ok := byID[id]
+			if !ok {
+				unknown = append(unknown, id)
+				continue
+			}
+			cited = append(cited, source)
+		}
+	}
+	return cited, unknown
+}
+
+// Render renders the sources as a sources section.
+func Render(sources []Source) string {
+	if len(sources) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\nSources:\n")
+	for _, source := range sources {
+		fmt.Fprintf(&b, "[%s] %s", source.ID, source.Title)
+		if len(source.Links) > 0 {
+			fmt.Fprintf(&b, " - %s", strings.Join(source.Links, " "))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,384 @@
+package embeddings
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// maxCachedDimensions bounds the dimensions read from a record, so a corrupt
+// record doesn't allocate gigabytes.
+const maxCachedDimensions = 1 << 16
+
+// cacheMagic starts a cache file, so other files aren't read as a cache.
+const cacheMagic = "EMBCACHE1\n"
+
+// Cache wraps an Embeddings and keeps the embeddings it returns in memory and
+// in an append-only log file, so repeated texts skip the embeddings service,
+// also between runs. Texts are keyed by the model and a hash of the text with
+// its whitespace normalised. When the cache holds more than maxEntries
+// embeddings the oldest are evicted, and the log is compacted once it holds
+// twice as many records as the cache.
+type Cache struct {
+	embedder   Embeddings
+	model      string
+	path       string
+	maxEntries int
+
+	mu      sync.Mutex
+	file    *os.File
+	entries map[string][]float32
+	order   []string
+	records int
+	stats   CacheStats
+}
+
+// CacheStats counts the lookups of a Cache.
+type CacheStats struct {
+	Hits      int
+	Misses    int
+	Evictions int
+	Entries   int
+}
+
+func (s CacheStats) String() string {
+	hitRate := 0.0
+	if s.Hits+s.Misses > 0 {
+		hitRate = float64(s.Hits) / float64(s.Hits+s.Misses)
+	}
+	return fmt.Sprintf("%d hits, %d misses (%.0f%% hit rate), %d evictions, %d entries", s.Hits, s.Misses, hitRate*100, s.Evictions, s.Entries)
+}
+
+// NewCache opens the cache file at path, creating it when it doesn't exist,
+// and wraps the embedder with it. model is part of the key, so embeddings of
+// different models don't mix. maxEntries of 0 means no limit.
+func NewCache(embedder Embeddings, model, path string, maxEntries int) (*Cache, error) {
+	c := &Cache{
+		embedder:   embedder,
+		model:      model,
+		path:       path,
+		maxEntries: maxEntries,
+		entries:    map[string][]float32{},
+	}
+	err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	c.evict()
+	if c.needsCompaction() {
+		err = c.compact()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Embedding returns the cached embedding of the prompt, or asks the wrapped
+// embedder and caches the result.
+func (c *Cache) Embedding(ctx context.Context, prompt string) (Embedding, error) {
+	key := c.key(prompt)
+
+	c.mu.Lock()
+	vector, ok := c.entries[key]
+	if ok {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+	c.mu.Unlock()
+	if ok {
+		return Embedding{Embedding: append([]float32{}, vector...)}, nil
+	}
+
+	embedding, err := c.embedder.Embedding(ctx, prompt)
+	if err != nil {
+		return Embedding{}, err
+	}
+
+	// An error response of the service may decode into an empty embedding,
+	// which must not stick.
+	if len(embedding.Embedding) == 0 {
+		return embedding, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return embedding, nil
+	}
+	err = c.append(key, embedding.Embedding)
+	if err != nil {
+		return Embedding{}, fmt.Errorf("failed to write embeddings cache: %w", err)
+	}
+	c.evict()
+	if c.needsCompaction() {
+		err = c.compact()
+		if err != nil {
+			return Embedding{}, fmt.Errorf("failed to compact embeddings cache: %w", err)
+		}
+	}
+	return embedding, nil
+}
+
+// EmbeddingBatch returns the cached embeddings of the prompts, and embeds the
+// others in one batch when the wrapped embedder is a Batcher.
+func (c *Cache) EmbeddingBatch(ctx context.Context, prompts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(prompts))
+	keys := make([]string, len(prompts))
+	var missing []int
+	var missingPrompts []string
+
+	c.mu.Lock()
+	for i, prompt := range prompts {
+		keys[i] = c.key(prompt)
+		if vector, ok := c.entries[keys[i]]; ok {
+			vectors[i] = append([]float32{}, vector...)
+			c.stats.Hits++
+			continue
+		}
+		c.stats.Misses++
+		missing = append(missing, i)
+		missingPrompts = append(missingPrompts, prompt)
+	}
+	c.mu.Unlock()
+	if len(missing) == 0 {
+		return vectors, nil
+	}
+
+	embedded, err := EmbedAll(ctx, c.embedder, missingPrompts)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for j, i := range missing {
+		vectors[i] = embedded[j]
+		if _, ok := c.entries[keys[i]]; ok || len(embedded[j]) == 0 {
+			continue
+		}
+		err = c.append(keys[i], embedded[j])
+		if err != nil {
+			return nil, fmt.Errorf("failed to write embeddings cache: %w", err)
+		}
+	}
+	c.evict()
+	if c.needsCompaction() {
+		err = c.compact()
+		if err != nil {
+			return nil, fmt.Errorf("failed to compact embeddings cache: %w", err)
+		}
+	}
+	return vectors, nil
+}
+
+// Stats returns the lookups since the cache was opened.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	return stats
+}
+
+// Close closes the cache file.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.file.Close()
+}
+
+// key hashes the model and the prompt with its whitespace collapsed, so
+// reformatted texts share their embedding.
+func (c *Cache) key(prompt string) string {
+	h := sha256.Sum256([]byte(c.model + "\x00" + strings.Join(strings.Fields(prompt), " ")))
+	return hex.EncodeToString(h[:])
+}
+
+// load reads the log into memory and opens it for appending. A record cut off
+// by a crash ends the log; it is truncated to the last whole record.
+func (c *Cache) load() error {
+	file, err := os.OpenFile(c.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	c.file = file
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if info.Size() == 0 {
+		_, err = file.WriteString(cacheMagic)
+		if err != nil {
+			file.Close()
+		}
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	magic := make([]byte, len(cacheMagic))
+	_, err = io.ReadFull(reader, magic)
+	if err != nil || string(magic) != cacheMagic {
+		file.Close()
+		return fmt.Errorf("%s is not an embeddings cache", c.path)
+	}
+
+	offset := int64(len(cacheMagic))
+	for {
+		key, vector, n, err := readRecord(reader)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			err = file.Truncate(offset)
+			if err != nil {
+				file.Close()
+				return err
+			}
+			break
+		}
+		offset += n
+		c.put(key, vector)
+	}
+
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		file.Close()
+	}
+	return err
+}
+
+// append writes a record to the log and adds it to the cache.
+func (c *Cache) append(key string, vector []float32) error {
+	_, err := c.file.Write(encodeRecord(key, vector))
+	if err != nil {
+		return err
+	}
+	c.put(key, vector)
+	return nil
+}
+
+func (c *Cache) put(key string, vector []float32) {
+	if _, ok := c.entries[key]; !ok {
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = vector
+	c.records++
+}
+
+// evict removes the oldest entries beyond maxEntries.
+func (c *Cache) evict() {
+	if c.maxEntries <= 0 {
+		return
+	}
+	for len(c.entries) > c.maxEntries {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+		c.stats.Evictions++
+	}
+}
+
+// needsCompaction reports whether at least half of the log is replaced or
+// evicted records.
+func (c *Cache) needsCompaction() bool {
+	return c.records > 2*max(len(c.entries), c.maxEntries)
+}
+
+// compact rewrites the log with only the entries in the cache, replacing the
+// file at once.
+func (c *Cache) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	_, err = writer.WriteString(cacheMagic)
+	for _, key := range c.order {
+		if err != nil {
+			break
+		}
+		_, err = writer.Write(encodeRecord(key, c.entries[key]))
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), c.path)
+	if err != nil {
+		return err
+	}
+
+	c.file.Close()
+	c.file, err = os.OpenFile(c.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	c.records = len(c.entries)
+	return nil
+}
+
+// encodeRecord encodes a key and vector as: key length (uint16), key,
+// dimensions (uint32), and the vector as little-endian float32s.
+func encodeRecord(key string, vector []float32) []byte {
+	record := make([]byte, 0, 2+len(key)+4+4*len(vector))
+	record = binary.LittleEndian.AppendUint16(record, uint16(len(key)))
+	record = append(record, key...)
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(vector)))
+	for _, v := range vector {
+		record = binary.LittleEndian.AppendUint32(record, math.Float32bits(v))
+	}
+	return record
+}
+
+// readRecord reads a record written by encodeRecord and returns its size. It
+// returns io.EOF at the end of the log and io.ErrUnexpectedEOF for a record
+// that was cut off.
+func readRecord(r io.Reader) (string, []float32, int64, error) {
+	var keyLen uint16
+	err := binary.Read(r, binary.LittleEndian, &keyLen)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	key := make([]byte, keyLen)
+	_, err = io.ReadFull(r, key)
+	if err != nil {
+		return "", nil, 0, io.ErrUnexpectedEOF
+	}
+	var dimensions uint32
+	err = binary.Read(r, binary.LittleEndian, &dimensions)
+	if err != nil || dimensions > maxCachedDimensions {
+		return "", nil, 0, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, 4*int64(dimensions))
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return "", nil, 0, io.ErrUnexpectedEOF
+	}
+	vector := make([]float32, dimensions)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return string(key), vector, 2 + int64(keyLen) + 4 + int64(len(data)), nil
+}
@@ -0,0 +1,217 @@
+package embeddings
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// countingEmbedder embeds a text as its length and counts the texts it
+// embedded.
+type countingEmbedder struct {
+	calls int
+}
+
+func (e *countingEmbedder) Embedding(ctx context.Context, prompt string) (Embedding, error) {
+	e.calls++
+	if prompt == "fail" {
+		return Embedding{}, errors.New("embedding failed")
+	}
+	return Embedding{Embedding: []float32{float32(len(prompt)), 1}}, nil
+}
+
+func openCache(t *testing.T, embedder Embeddings, path string, maxEntries int) *Cache {
+	t.Helper()
+	c, err := NewCache(embedder, "model", path, maxEntries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func embed(t *testing.T, c *Cache, prompts ...string) {
+	t.Helper()
+	for _, prompt := range prompts {
+		_, err := c.Embedding(context.Background(), prompt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCacheHitsAndMisses(t *testing.T) {
+	embedder := &countingEmbedder{}
+	c := openCache(t, embedder, filepath.Join(t.TempDir(), "cache"), 0)
+
+	embed(t, c, "toys", "robbers", "toys", "  toys\n", "robbers")
+	_, err := c.Embedding(context.Background(), "fail")
+	if err == nil {
+		t.Error("Embedding of a failing text succeeded")
+	}
+	want := CacheStats{Hits: 3, Misses: 3, Entries: 2}
+	if got := c.Stats(); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+	if embedder.calls != 3 {
+		t.Errorf("embedder called %d times, want 3", embedder.calls)
+	}
+
+	other, err := NewCache(embedder, "other model", filepath.Join(t.TempDir(), "cache"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	embedding, err := other.Embedding(context.Background(), "toys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(embedding.Embedding, []float32{4, 1}) || other.Stats().Misses != 1 {
+		t.Errorf("other model got %v with %+v, want a miss", embedding.Embedding, other.Stats())
+	}
+}
+
+func TestCacheBatch(t *testing.T) {
+	embedder := &countingEmbedder{}
+	c := openCache(t, embedder, filepath.Join(t.TempDir(), "cache"), 0)
+	embed(t, c, "toys")
+
+	vectors, err := c.EmbeddingBatch(context.Background(), []string{"toys", "robbers", "toys"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]float32{{4, 1}, {7, 1}, {4, 1}}
+	if !reflect.DeepEqual(vectors, want) {
+		t.Errorf("EmbeddingBatch = %v, want %v", vectors, want)
+	}
+	if got := c.Stats(); got.Hits != 2 || got.Misses != 2 || got.Entries != 2 {
+		t.Errorf("Stats = %+v, want 2 hits, 2 misses and 2 entries", got)
+	}
+}
+
+func TestCachePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	embedder := &countingEmbedder{}
+	c := openCache(t, embedder, path, 0)
+	embed(t, c, "toys", "robbers")
+	c.Close()
+
+	reopened := openCache(t, embedder, path, 0)
+	embedding, err := reopened.Embedding(context.Background(), "robbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(embedding.Embedding, []float32{7, 1}) {
+		t.Errorf("Embedding after reopening = %v, want [7 1]", embedding.Embedding)
+	}
+	if embedder.calls != 2 || reopened.Stats().Hits != 1 {
+		t.Errorf("embedder called %d times with %+v, want a hit from the file", embedder.calls, reopened.Stats())
+	}
+}
+
+func TestCacheRecoversCutOffRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	embedder := &countingEmbedder{}
+	c := openCache(t, embedder, path, 0)
+	embed(t, c, "toys", "robbers")
+	c.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cut the last record off half way through its vector.
+	err = os.Truncate(path, info.Size()-3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	whole := info.Size() - int64(len(encodeRecord(c.key("robbers"), []float32{7, 1})))
+
+	reopened := openCache(t, embedder, path, 0)
+	if got := reopened.Stats().Entries; got != 1 {
+		t.Errorf("Entries after recovery = %d, want 1", got)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != whole {
+		t.Errorf("log truncated to %d bytes, want the %d of the whole records", info.Size(), whole)
+	}
+	embed(t, reopened, "toys", "robbers")
+	if embedder.calls != 3 {
+		t.Errorf("embedder called %d times, want only the cut off text again", embedder.calls)
+	}
+	reopened.Close()
+
+	again := openCache(t, embedder, path, 0)
+	if got := again.Stats().Entries; got != 2 {
+		t.Errorf("Entries after appending to the recovered log = %d, want 2", got)
+	}
+}
+
+func TestCacheRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	err := os.WriteFile(path, []byte("not a cache at all"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewCache(&countingEmbedder{}, "model", path, 0)
+	if err == nil || !strings.Contains(err.Error(), "not an embeddings cache") {
+		t.Errorf("NewCache error = %v, want not an embeddings cache", err)
+	}
+}
+
+func TestCacheEvictsOldest(t *testing.T) {
+	embedder := &countingEmbedder{}
+	c := openCache(t, embedder, filepath.Join(t.TempDir(), "cache"), 2)
+	embed(t, c, "a", "bb", "a", "ccc")
+
+	if got := c.Stats(); got.Evictions != 1 || got.Entries != 2 {
+		t.Errorf("Stats = %+v, want 1 eviction and 2 entries", got)
+	}
+	// "a" was added first, so it went, even though it was looked up again.
+	calls := embedder.calls
+	embed(t, c, "bb", "ccc")
+	if embedder.calls != calls {
+		t.Error("the newest entries were evicted")
+	}
+	embed(t, c, "a")
+	if embedder.calls != calls+1 {
+		t.Error("the oldest entry was not evicted")
+	}
+}
+
+func TestCacheCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	embedder := &countingEmbedder{}
+	c := openCache(t, embedder, path, 2)
+	// Every text evicts an older one, until the log holds more than twice
+	// the entries and is compacted.
+	embed(t, c, "a", "bb", "ccc", "dddd", "eeeee")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := int64(len(cacheMagic)) +
+		int64(len(encodeRecord(c.key("dddd"), []float32{4, 1}))) +
+		int64(len(encodeRecord(c.key("eeeee"), []float32{5, 1})))
+	if info.Size() != live {
+		t.Errorf("compacted log has %d bytes, want the %d of the live entries", info.Size(), live)
+	}
+	// Appends after the compaction go to the new file.
+	embed(t, c, "ffffff")
+	c.Close()
+
+	reopened := openCache(t, embedder, path, 0)
+	calls := embedder.calls
+	embed(t, reopened, "eeeee", "ffffff")
+	if embedder.calls != calls {
+		t.Errorf("live entries lost by the compaction: %+v", reopened.Stats())
+	}
+	embed(t, reopened, "a")
+	if embedder.calls != calls+1 {
+		t.Error("an evicted entry survived the compaction")
+	}
+}
@@ -15,14 +15,14 @@ type Embeddings interface {
 type Service struct {
 	Model   string
 	Address string
-	Workers int
+	// HTTPClient sends the requests; nil uses http.DefaultClient.
+	HTTPClient *http.Client
 }
 
-func NewEmbeddings(model, address string, workers int) *Service {
+func NewEmbeddings(model, address string) *Service {
 	return &Service{
 		Model:   model,
 		Address: address,
-		Workers: workers,
 	}
 }
 
@@ -30,13 +30,22 @@ type request interface {
 	json() ([]byte, error)
 }
 
-func (g *Service) call(r request, endpoint string) (*http.Response, error) {
+func (g *Service) call(ctx context.Context, r request, endpoint string) (*http.Response, error) {
 	data, err := r.json()
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 	urlstr := fmt.Sprintf("%s/%s", g.Address, endpoint)
-	resp, err := http.Post(urlstr, "application/json", bytes.NewBuffer(data))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, urlstr, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request to %s: %w", urlstr, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	httpClient := g.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to post request to %s: %w", urlstr, err)
 	}
@@ -60,10 +69,11 @@ func (g *Service) Embedding(ctx context.Context, prompt string) (Embedding, erro
 		Prompt: prompt,
 	}
 
-	resp, err := g.call(r, endpoint)
+	resp, err := g.call(ctx, r, endpoint)
 	if err != nil {
 		return Embedding{}, fmt.Errorf("failed to call embeddings LLM: %w", err)
 	}
+	defer resp.Body.Close()
 
 	var embedding Embedding
 	err = json.NewDecoder(resp.Body).Decode(&embedding)
@@ -0,0 +1,159 @@
+package embeddings
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Batcher is implemented by embedders that embed several texts in one
+// request.
+type Batcher interface {
+	Embeddings
+	EmbeddingBatch(ctx context.Context, prompts []string) ([][]float32, error)
+}
+
+// EmbedAll embeds the prompts in one request when the embedder is a Batcher,
+// and one by one otherwise.
+func EmbedAll(ctx context.Context, embedder Embeddings, prompts []string) ([][]float32, error) {
+	if batcher, ok := embedder.(Batcher); ok {
+		return batcher.EmbeddingBatch(ctx, prompts)
+	}
+	vectors := make([][]float32, len(prompts))
+	for i, prompt := range prompts {
+		embedding, err := embedder.Embedding(ctx, prompt)
+		if err != nil {
+			return nil, err
+		}
+		vectors[i] = embedding.Embedding
+	}
+	return vectors, nil
+}
+
+// errNotFound is returned by Ollama versions without the endpoint.
+var errNotFound = errors.New("endpoint not found")
+
+// Ollama embeds texts with an embedding model served by Ollama, such as
+// nomic-embed-text, using the /api/embed endpoint that takes a batch of texts.
+// Ollama versions before /api/embed fall back to /api/embeddings, one text at
+// a time.
+type Ollama struct {
+	Model   string
+	Address string
+	// HTTPClient sends the requests; nil uses http.DefaultClient.
+	HTTPClient *http.Client
+
+	// legacy is set once the server turned out not to have /api/embed.
+	legacy atomic.Bool
+}
+
+func NewOllamaEmbeddings(model, address string) *Ollama {
+	return &Ollama{
+		Model:   model,                            // "nomic-embed-text",
+		Address: strings.TrimSuffix(address, "/"), // "http://localhost:11434",
+	}
+}
+
+type embedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embedResponse struct {
+	Model      string      `json:"model"`
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+func (o *Ollama) Embedding(ctx context.Context, prompt string) (Embedding, error) {
+	vectors, err := o.EmbeddingBatch(ctx, []string{prompt})
+	if err != nil {
+		return Embedding{}, err
+	}
+	return Embedding{Embedding: vectors[0]}, nil
+}
+
+// EmbeddingBatch embeds the prompts in one request, in the same order.
+func (o *Ollama) EmbeddingBatch(ctx context.Context, prompts []string) ([][]float32, error) {
+	if len(prompts) == 0 {
+		return nil, nil
+	}
+	if o.legacy.Load() {
+		return o.legacyBatch(ctx, prompts)
+	}
+
+	var response embedResponse
+	err := o.post(ctx, "api/embed", embedRequest{Model: o.Model, Input: prompts}, &response)
+	if errors.Is(err, errNotFound) {
+		o.legacy.Store(true)
+		return o.legacyBatch(ctx, prompts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Embeddings) != len(prompts) {
+		return nil, fmt.Errorf("ollama returned %d embeddings for %d texts", len(response.Embeddings), len(prompts))
+	}
+	return response.Embeddings, nil
+}
+
+// legacyBatch embeds the prompts one by one with /api/embeddings.
+func (o *Ollama) legacyBatch(ctx context.Context, prompts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(prompts))
+	for i, prompt := range prompts {
+		var embedding Embedding
+		err := o.post(ctx, "api/embeddings", EmbeddingRequest{Model: o.Model, Prompt: prompt}, &embedding)
+		if err != nil {
+			return nil, err
+		}
+		vectors[i] = embedding.Embedding
+	}
+	return vectors, nil
+}
+
+func (o *Ollama) post(ctx context.Context, endpoint string, request, response any) error {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	urlstr := fmt.Sprintf("%s/%s", o.Address, endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, urlstr, bytes.NewBuffer(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request to %s: %w", urlstr, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	httpClient := o.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post request to %s: %w", urlstr, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound && endpoint == "api/embed" {
+		// A missing model is a 404 with an error message too; only an
+		// unknown endpoint falls back.
+		body, _ := io.ReadAll(resp.Body)
+		if !strings.Contains(string(body), "model") {
+			return errNotFound
+		}
+		return fmt.Errorf("request to %s failed with status %s: %s", urlstr, resp.Status, body)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("request to %s failed with status %s: %s", urlstr, resp.Status, body)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		return fmt.Errorf("failed to decode embedding response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// AnswerResult is the judged answer of a variant to a question. Error is set
+// when the answer or the judgement failed.
+type AnswerResult struct {
+	Variant   string    `json:"variant"`
+	Answer    Answer    `json:"answer"`
+	Judgement Judgement `json:"judgement"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// VariantSummary has the mean grades of a variant, over its judged answers.
+type VariantSummary struct {
+	Variant      string  `json:"variant"`
+	Answers      int     `json:"answers"`
+	Failed       int     `json:"failed"`
+	Faithfulness float64 `json:"faithfulness"`
+	Relevance    float64 `json:"relevance"`
+	// OutOfList is the share of answers recommending a movie that is not in
+	// the context.
+	OutOfList float64 `json:"out_of_list"`
+}
+
+// AnswerReport compares the answers of variants, like prompt templates or
+// models, to the same questions.
+type AnswerReport struct {
+	Variants []VariantSummary `json:"variants"`
+	Results  []AnswerResult   `json:"results"`
+}
+
+// NewAnswerReport summarizes the results of the variants, in that order.
+func NewAnswerReport(variants []string, results []AnswerResult) AnswerReport {
+	report := AnswerReport{Results: results}
+	for _, variant := range variants {
+		summary := VariantSummary{Variant: variant}
+		outOfList := 0
+		for _, result := range results {
+			if result.Variant != variant {
+				continue
+			}
+			if result.Error != "" {
+				summary.Failed++
+				continue
+			}
+			summary.Answers++
+			summary.Faithfulness += result.Judgement.Faithfulness
+			summary.Relevance += result.Judgement.Relevance
+			if len(result.Judgement.OutOfList) > 0 {
+				outOfList++
+			}
+		}
+		if summary.Answers > 0 {
+			n := float64(summary.Answers)
+			summary.Faithfulness /= n
+			summary.Relevance /= n
+			summary.OutOfList = float64(outOfList) / n
+		}
+		report.Variants = append(report.Variants, summary)
+	}
+	return report
+}
+
+// Save writes the report as JSON.
+func (r AnswerReport) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Markdown renders the summary of the variants and the grades of every
+// question, with a column per variant.
+func (r AnswerReport) Markdown() string {
+	var b strings.Builder
+	b.WriteString("# Answer evaluation\n\n")
+	b.WriteString("| Variant | Answers | Failed | Faithfulness | Relevance | Out of list |\n")
+	b.WriteString("|---|---|---|---|---|---|\n")
+	for _, v := range r.Variants {
+		fmt.Fprintf(&b, "| %s | %d | %d | %.2f | %.2f | %.0f%% |\n", markdownCell(v.Variant), v.Answers, v.Failed, v.Faithfulness, v.Relevance, 100*v.OutOfList)
+	}
+
+	var questions []Answer
+	cells := map[string]map[string]string{}
+	for _, result := range r.Results {
+		id := result.Answer.QuestionID
+		if cells[id] == nil {
+			cells[id] = map[string]string{}
+			questions = append(questions, result.Answer)
+		}
+		cell := fmt.Sprintf("F %.0f / R %.0f", result.Judgement.Faithfulness, result.Judgement.Relevance)
+		if result.Error != "" {
+			cell = "failed: " + result.Error
+		} else if len(result.Judgement.OutOfList) > 0 {
+			cell += " / out of list: " + strings.Join(result.Judgement.OutOfList, ", ")
+		}
+		cells[id][result.Variant] = cell
+	}
+
+	b.WriteString("\n## Questions\n\n| Question |")
+	for _, v := range r.Variants {
+		fmt.Fprintf(&b, " %s |", markdownCell(v.Variant))
+	}
+	b.WriteString("\n|---|" + strings.Repeat("---|", len(r.Variants)) + "\n")
+	for _, question := range questions {
+		fmt.Fprintf(&b, "| %s: %s |", question.QuestionID, markdownCell(question.Question))
+		for _, v := range r.Variants {
+			fmt.Fprintf(&b, " %s |", markdownCell(cells[question.QuestionID][v.Variant]))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// markdownCell escapes the text for a cell of a markdown table.
+func markdownCell(text string) string {
+	text = strings.ReplaceAll(text, "|", `\|`)
+	return strings.Join(strings.Fields(text), " ")
+}
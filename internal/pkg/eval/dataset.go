@@ -0,0 +1,78 @@
+// Package eval measures the quality of retrieval and answers against a set
+// of queries with known relevant movies.
+package eval
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Query is a query of a golden set with the movieIds that should be
+// retrieved for it. Question sets for answers may leave Relevant out.
+type Query struct {
+	ID       string   `json:"id"`
+	Query    string   `json:"query"`
+	Relevant []string `json:"relevant"`
+}
+
+// LoadQueries reads a golden set from a JSONL file, one query per line.
+// Queries without an ID get q followed by their line number.
+func LoadQueries(path string) ([]Query, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var queries []Query
+	ids := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var query Query
+		err := json.Unmarshal(scanner.Bytes(), &query)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if query.ID == "" {
+			query.ID = fmt.Sprintf("q%d", line)
+		}
+		if query.Query == "" {
+			return nil, fmt.Errorf("%s:%d: query is empty", path, line)
+		}
+		if ids[query.ID] {
+			return nil, fmt.Errorf("%s:%d: duplicate query id %s", path, line, query.ID)
+		}
+		ids[query.ID] = true
+		queries = append(queries, query)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return queries, nil
+}
+
+// SaveQueries writes queries as JSONL, for LoadQueries.
+func SaveQueries(path string, queries []Query) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	for _, query := range queries {
+		err = encoder.Encode(query)
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
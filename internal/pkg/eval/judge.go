@@ -0,0 +1,127 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/llm"
+)
+
+// maxGrade is the top of the scale the judge grades answers on, from 1.
+const maxGrade = 5
+
+// Answer is an answer of the RAG flow with the context it was given.
+type Answer struct {
+	QuestionID string `json:"question_id"`
+	Question   string `json:"question"`
+	// Context is the movies and passages in the prompt, as the LLM saw them.
+	Context string `json:"context"`
+	// Movies are the titles of the movies in the context.
+	Movies []string `json:"movies"`
+	Answer string   `json:"answer"`
+}
+
+// Judgement is how the judge graded an answer.
+type Judgement struct {
+	// Faithfulness is whether every claim about a movie is supported by the
+	// context, from 1 to 5.
+	Faithfulness float64 `json:"faithfulness"`
+	// Relevance is whether the answer and its recommendations address the
+	// question, from 1 to 5.
+	Relevance float64 `json:"relevance"`
+	// Recommended are the titles of the movies the answer recommends.
+	Recommended []string `json:"recommended"`
+	// OutOfList are the recommended movies that are not in the context.
+	OutOfList []string `json:"out_of_list"`
+	Reason    string   `json:"reason"`
+}
+
+// Judge grades answers with an LLM. A bigger model than the one that answered
+// judges best; the same model tends to like its own answers.
+type Judge struct {
+	llm llm.Generator
+}
+
+func NewJudge(generator llm.Generator) *Judge {
+	return &Judge{llm: generator}
+}
+
+// Judge grades the answer. Whether a recommended movie is out of the list is
+// decided by comparing titles, not by the judge.
+func (j *Judge) Judge(ctx context.Context, answer Answer) (Judgement, error) {
+	prompt := fmt.Sprintf(`
+You judge the answer of a movie recommender. The recommender was given the context below and may only
+recommend movies from it.
+Grade the answer on a scale from 1 (bad) to %d (good) for:
+- faithfulness: every claim the answer makes about a movie is supported by the context.
+- relevance: the answer addresses the question and the movies it recommends fit the question.
+List the titles of all movies the answer recommends, as written in the answer.
+Respond with JSON only, in the form
+{"faithfulness": <number>, "relevance": <number>, "recommended": ["<title>"], "reason": "<one sentence>"}.
+
+### Context:
+---
+%s
+Question: %s
+
+Answer: %s
+`, maxGrade, answer.Context, answer.Question, answer.Answer)
+
+	response, err := j.llm.GenerateJSON(ctx, prompt)
+	if err != nil {
+		return Judgement{}, err
+	}
+	var judgement Judgement
+	err = json.Unmarshal([]byte(response), &judgement)
+	if err != nil {
+		return Judgement{}, fmt.Errorf("failed to decode judgement of question %s: %w", answer.QuestionID, err)
+	}
+	judgement.Faithfulness = clampGrade(judgement.Faithfulness)
+	judgement.Relevance = clampGrade(judgement.Relevance)
+	judgement.OutOfList = OutOfList(judgement.Recommended, answer.Movies)
+	return judgement, nil
+}
+
+func clampGrade(grade float64) float64 {
+	return max(1, min(grade, maxGrade))
+}
+
+// OutOfList returns the recommended titles that aren't one of the titles.
+// Titles are compared after normalizeTitle, so "Toy Story" matches "Toy Story
+// (1995)", but not "Toy Story 2".
+func OutOfList(recommended, titles []string) []string {
+	known := map[string]bool{}
+	for _, title := range titles {
+		known[normalizeTitle(title)] = true
+	}
+	var out []string
+	for _, title := range recommended {
+		n := normalizeTitle(title)
+		if n != "" && !known[n] {
+			out = append(out, title)
+		}
+	}
+	return out
+}
+
+var (
+	yearPattern    = regexp.MustCompile(`\(\d{4}\)`)
+	articlePattern = regexp.MustCompile(`^(.*), (the|a|an)$`)
+	nonWordPattern = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// normalizeTitle lowercases the title and drops its year, punctuation and a
+// leading article, which MovieLens titles put at the end, as in "American
+// President, The (1995)".
+func normalizeTitle(title string) string {
+	title = strings.ToLower(title)
+	title = strings.TrimSpace(yearPattern.ReplaceAllString(title, ""))
+	title = articlePattern.ReplaceAllString(title, "$2 $1")
+	for _, article := range []string{"the ", "a ", "an "} {
+		title = strings.TrimPrefix(title, article)
+	}
+	return strings.TrimSpace(nonWordPattern.ReplaceAllString(title, " "))
+}
@@ -0,0 +1,74 @@
+package eval
+
+import "math"
+
+// Metrics are the retrieval metrics of one query, or their means over a set.
+type Metrics struct {
+	Recall    float64 `json:"recall"`
+	Precision float64 `json:"precision"`
+	MRR       float64 `json:"mrr"`
+	NDCG      float64 `json:"ndcg"`
+}
+
+// Score computes the metrics at k of the retrieved movieIds, in ranked order,
+// against the relevant ones. Relevance is binary.
+func Score(retrieved, relevant []string, k int) Metrics {
+	if len(retrieved) > k {
+		retrieved = retrieved[:k]
+	}
+	isRelevant := map[string]bool{}
+	for _, id := range relevant {
+		isRelevant[id] = true
+	}
+
+	var m Metrics
+	hits := 0
+	dcg := 0.0
+	seen := map[string]bool{}
+	for i, id := range retrieved {
+		if !isRelevant[id] || seen[id] {
+			continue
+		}
+		seen[id] = true
+		hits++
+		if m.MRR == 0 {
+			m.MRR = 1 / float64(i+1)
+		}
+		dcg += 1 / math.Log2(float64(i+2))
+	}
+
+	idcg := 0.0
+	for i := 0; i < min(len(isRelevant), k); i++ {
+		idcg += 1 / math.Log2(float64(i+2))
+	}
+	if len(isRelevant) > 0 {
+		m.Recall = float64(hits) / float64(len(isRelevant))
+	}
+	if k > 0 {
+		m.Precision = float64(hits) / float64(k)
+	}
+	if idcg > 0 {
+		m.NDCG = dcg / idcg
+	}
+	return m
+}
+
+// Mean returns the mean of the metrics.
+func Mean(metrics []Metrics) Metrics {
+	var mean Metrics
+	if len(metrics) == 0 {
+		return mean
+	}
+	for _, m := range metrics {
+		mean.Recall += m.Recall
+		mean.Precision += m.Precision
+		mean.MRR += m.MRR
+		mean.NDCG += m.NDCG
+	}
+	n := float64(len(metrics))
+	mean.Recall /= n
+	mean.Precision /= n
+	mean.MRR /= n
+	mean.NDCG /= n
+	return mean
+}
@@ -0,0 +1,103 @@
+package eval
+
+import (
+	"math"
+	"testing"
+)
+
+func approxEqual(a, b Metrics) bool {
+	near := func(x, y float64) bool { return math.Abs(x-y) < 1e-4 }
+	return near(a.Recall, b.Recall) && near(a.Precision, b.Precision) && near(a.MRR, b.MRR) && near(a.NDCG, b.NDCG)
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name      string
+		retrieved []string
+		relevant  []string
+		k         int
+		want      Metrics
+	}{
+		{
+			name:      "first hit",
+			retrieved: []string{"a", "b", "c"},
+			relevant:  []string{"a"},
+			k:         3,
+			want:      Metrics{Recall: 1, Precision: 1.0 / 3, MRR: 1, NDCG: 1},
+		},
+		{
+			// DCG = 1/log2(3) + 1/log2(5) = 1.0616, ideal = 1 + 1/log2(3) = 1.6309
+			name:      "hits at 2 and 4",
+			retrieved: []string{"x", "a", "y", "b"},
+			relevant:  []string{"a", "b"},
+			k:         4,
+			want:      Metrics{Recall: 1, Precision: 0.5, MRR: 0.5, NDCG: 0.6509},
+		},
+		{
+			name:      "hit beyond k",
+			retrieved: []string{"x", "y", "a"},
+			relevant:  []string{"a"},
+			k:         2,
+			want:      Metrics{},
+		},
+		{
+			// DCG = 1, ideal = 1.6309
+			name:      "duplicates count once",
+			retrieved: []string{"a", "a"},
+			relevant:  []string{"a", "b"},
+			k:         2,
+			want:      Metrics{Recall: 0.5, Precision: 0.5, MRR: 1, NDCG: 0.6131},
+		},
+		{
+			name:      "more relevant than k",
+			retrieved: []string{"a", "b"},
+			relevant:  []string{"a", "b", "c"},
+			k:         2,
+			want:      Metrics{Recall: 2.0 / 3, Precision: 1, MRR: 1, NDCG: 1},
+		},
+		{
+			name:      "fewer retrieved than k",
+			retrieved: []string{"a"},
+			relevant:  []string{"a"},
+			k:         5,
+			want:      Metrics{Recall: 1, Precision: 0.2, MRR: 1, NDCG: 1},
+		},
+		{
+			// DCG = 1/log2(4) = 0.5, ideal = 1
+			name:      "third hit",
+			retrieved: []string{"x", "y", "a"},
+			relevant:  []string{"a"},
+			k:         3,
+			want:      Metrics{Recall: 1, Precision: 1.0 / 3, MRR: 1.0 / 3, NDCG: 0.5},
+		},
+		{
+			name:      "nothing relevant",
+			retrieved: []string{"a"},
+			relevant:  nil,
+			k:         1,
+			want:      Metrics{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Score(tt.retrieved, tt.relevant, tt.k)
+			if !approxEqual(got, tt.want) {
+				t.Errorf("Score = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMean(t *testing.T) {
+	got := Mean([]Metrics{
+		{Recall: 1, Precision: 0.5, MRR: 1, NDCG: 1},
+		{Recall: 0, Precision: 0.1, MRR: 0.5, NDCG: 0.2},
+	})
+	want := Metrics{Recall: 0.5, Precision: 0.3, MRR: 0.75, NDCG: 0.6}
+	if !approxEqual(got, want) {
+		t.Errorf("Mean = %+v, want %+v", got, want)
+	}
+	if got := Mean(nil); got != (Metrics{}) {
+		t.Errorf("Mean of nothing = %+v, want zeros", got)
+	}
+}
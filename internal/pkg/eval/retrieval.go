@@ -0,0 +1,166 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// ErrRegression is returned when a report is worse than its baseline.
+var ErrRegression = errors.New("retrieval regressed against the baseline")
+
+// Retriever returns the movieIds retrieved for a query, best first.
+type Retriever func(ctx context.Context, query string) ([]string, error)
+
+// QueryResult is the outcome of a query of the golden set.
+type QueryResult struct {
+	ID        string   `json:"id"`
+	Query     string   `json:"query"`
+	Relevant  []string `json:"relevant"`
+	Retrieved []string `json:"retrieved"`
+	Metrics   Metrics  `json:"metrics"`
+}
+
+// Report has the metrics at K of every query and their means. Saved reports
+// serve as the baseline of later runs.
+type Report struct {
+	K       int           `json:"k"`
+	Mean    Metrics       `json:"mean"`
+	Queries []QueryResult `json:"queries"`
+}
+
+// EvaluateRetrieval runs the queries through the retriever and scores the
+// results at k.
+func EvaluateRetrieval(ctx context.Context, queries []Query, retrieve Retriever, k int) (Report, error) {
+	report := Report{K: k}
+	metrics := make([]Metrics, 0, len(queries))
+	for i, query := range queries {
+		if len(query.Relevant) == 0 {
+			return Report{}, fmt.Errorf("query %s has no relevant movies", query.ID)
+		}
+		retrieved, err := retrieve(ctx, query.Query)
+		if err != nil {
+			return Report{}, fmt.Errorf("failed to retrieve movies for query %s: %w", query.ID, err)
+		}
+		m := Score(retrieved, query.Relevant, k)
+		metrics = append(metrics, m)
+		report.Queries = append(report.Queries, QueryResult{
+			ID:        query.ID,
+			Query:     query.Query,
+			Relevant:  query.Relevant,
+			Retrieved: retrieved,
+			Metrics:   m,
+		})
+		if (i+1)%50 == 0 {
+			log.Printf("evaluated %d of %d queries", i+1, len(queries))
+		}
+	}
+	report.Mean = Mean(metrics)
+	return report, nil
+}
+
+// LoadReport reads a report saved with Save.
+func LoadReport(path string) (Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Report{}, err
+	}
+	var report Report
+	err = json.Unmarshal(data, &report)
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to parse report %s: %w", path, err)
+	}
+	return report, nil
+}
+
+// Save writes the report as JSON.
+func (r Report) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Diff is the change of the metrics of a query, or of the means.
+type Diff struct {
+	ID       string
+	Query    string
+	Baseline Metrics
+	Current  Metrics
+}
+
+// Delta returns the current metrics minus the baseline ones.
+func (d Diff) Delta() Metrics {
+	return Metrics{
+		Recall:    d.Current.Recall - d.Baseline.Recall,
+		Precision: d.Current.Precision - d.Baseline.Precision,
+		MRR:       d.Current.MRR - d.Baseline.MRR,
+		NDCG:      d.Current.NDCG - d.Baseline.NDCG,
+	}
+}
+
+// Comparison is a report compared to a baseline.
+type Comparison struct {
+	Mean Diff
+	// Changed has the queries whose metrics changed, in the order of the
+	// current report.
+	Changed []Diff
+	// Added and Removed have the IDs of the queries that are only in the
+	// current report or only in the baseline.
+	Added   []string
+	Removed []string
+	// Regressions names the mean metrics that dropped by more than the
+	// tolerance.
+	Regressions []string
+}
+
+// Compare compares the report to the baseline. A mean metric that drops by
+// more than tolerance is a regression. The reports must have the same k.
+func Compare(baseline, current Report, tolerance float64) (Comparison, error) {
+	if baseline.K != current.K {
+		return Comparison{}, fmt.Errorf("baseline is at k=%d, report at k=%d", baseline.K, current.K)
+	}
+	c := Comparison{Mean: Diff{ID: "mean", Baseline: baseline.Mean, Current: current.Mean}}
+
+	before := map[string]QueryResult{}
+	for _, q := range baseline.Queries {
+		before[q.ID] = q
+	}
+	seen := map[string]bool{}
+	for _, q := range current.Queries {
+		seen[q.ID] = true
+		b, ok := before[q.ID]
+		if !ok {
+			c.Added = append(c.Added, q.ID)
+			continue
+		}
+		if b.Metrics != q.Metrics {
+			c.Changed = append(c.Changed, Diff{ID: q.ID, Query: q.Query, Baseline: b.Metrics, Current: q.Metrics})
+		}
+	}
+	for _, q := range baseline.Queries {
+		if !seen[q.ID] {
+			c.Removed = append(c.Removed, q.ID)
+		}
+	}
+
+	delta := c.Mean.Delta()
+	for _, m := range []struct {
+		name  string
+		delta float64
+	}{
+		{"recall", delta.Recall},
+		{"precision", delta.Precision},
+		{"MRR", delta.MRR},
+		{"nDCG", delta.NDCG},
+	} {
+		if m.delta < -tolerance {
+			c.Regressions = append(c.Regressions, fmt.Sprintf("%s dropped by %.3f", m.name, -m.delta))
+		}
+	}
+	return c, nil
+}
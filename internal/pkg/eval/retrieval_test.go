@@ -0,0 +1,160 @@
+package eval
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func report(k int, queries ...QueryResult) Report {
+	r := Report{K: k, Queries: queries}
+	metrics := make([]Metrics, len(queries))
+	for i, q := range queries {
+		metrics[i] = q.Metrics
+	}
+	r.Mean = Mean(metrics)
+	return r
+}
+
+func TestCompare(t *testing.T) {
+	baseline := report(5,
+		QueryResult{ID: "q1", Metrics: Metrics{Recall: 1, Precision: 0.2, MRR: 1, NDCG: 1}},
+		QueryResult{ID: "q2", Metrics: Metrics{Recall: 0.6, Precision: 0.6, MRR: 0.5, NDCG: 0.6}},
+		QueryResult{ID: "q3", Metrics: Metrics{Recall: 1, Precision: 0.2, MRR: 1, NDCG: 1}},
+	)
+
+	tests := []struct {
+		name            string
+		current         Report
+		tolerance       float64
+		wantChanged     []string
+		wantAdded       []string
+		wantRemoved     []string
+		wantRegressions []string
+	}{
+		{
+			name:      "unchanged",
+			current:   baseline,
+			tolerance: 0,
+		},
+		{
+			name: "improved",
+			current: report(5,
+				QueryResult{ID: "q1", Metrics: Metrics{Recall: 1, Precision: 0.2, MRR: 1, NDCG: 1}},
+				QueryResult{ID: "q2", Metrics: Metrics{Recall: 0.8, Precision: 0.8, MRR: 1, NDCG: 0.9}},
+				QueryResult{ID: "q3", Metrics: Metrics{Recall: 1, Precision: 0.2, MRR: 1, NDCG: 1}},
+			),
+			wantChanged: []string{"q2"},
+		},
+		{
+			// The mean MRR drops by 0.5/3 = 0.167, the others by 0.2/3 = 0.067.
+			name: "drop within tolerance",
+			current: report(5,
+				QueryResult{ID: "q1", Metrics: Metrics{Recall: 1, Precision: 0.2, MRR: 1, NDCG: 1}},
+				QueryResult{ID: "q2", Metrics: Metrics{Recall: 0.4, Precision: 0.4, MRR: 0, NDCG: 0.4}},
+				QueryResult{ID: "q3", Metrics: Metrics{Recall: 1, Precision: 0.2, MRR: 1, NDCG: 1}},
+			),
+			tolerance:   0.2,
+			wantChanged: []string{"q2"},
+		},
+		{
+			name: "drop beyond tolerance",
+			current: report(5,
+				QueryResult{ID: "q1", Metrics: Metrics{Recall: 1, Precision: 0.2, MRR: 1, NDCG: 1}},
+				QueryResult{ID: "q2", Metrics: Metrics{Recall: 0.4, Precision: 0.4, MRR: 0, NDCG: 0.4}},
+				QueryResult{ID: "q3", Metrics: Metrics{Recall: 1, Precision: 0.2, MRR: 1, NDCG: 1}},
+			),
+			tolerance:       0.1,
+			wantChanged:     []string{"q2"},
+			wantRegressions: []string{"MRR dropped by 0.167"},
+		},
+		{
+			name: "added and removed queries",
+			current: report(5,
+				QueryResult{ID: "q4", Metrics: Metrics{Recall: 1, Precision: 0.2, MRR: 1, NDCG: 1}},
+				QueryResult{ID: "q1", Metrics: Metrics{Recall: 1, Precision: 0.2, MRR: 1, NDCG: 1}},
+				QueryResult{ID: "q2", Metrics: Metrics{Recall: 0.6, Precision: 0.6, MRR: 0.5, NDCG: 0.6}},
+			),
+			tolerance:   0.01,
+			wantAdded:   []string{"q4"},
+			wantRemoved: []string{"q3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Compare(baseline, tt.current, tt.tolerance)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var changed []string
+			for _, diff := range c.Changed {
+				changed = append(changed, diff.ID)
+			}
+			if !reflect.DeepEqual(changed, tt.wantChanged) {
+				t.Errorf("Changed = %q, want %q", changed, tt.wantChanged)
+			}
+			if !reflect.DeepEqual(c.Added, tt.wantAdded) || !reflect.DeepEqual(c.Removed, tt.wantRemoved) {
+				t.Errorf("Added, Removed = %q, %q, want %q, %q", c.Added, c.Removed, tt.wantAdded, tt.wantRemoved)
+			}
+			if !reflect.DeepEqual(c.Regressions, tt.wantRegressions) {
+				t.Errorf("Regressions = %q, want %q", c.Regressions, tt.wantRegressions)
+			}
+		})
+	}
+}
+
+func TestCompareDifferentK(t *testing.T) {
+	_, err := Compare(report(5), report(10), 0.01)
+	if err == nil {
+		t.Error("Compare of reports at different k succeeded")
+	}
+}
+
+func TestEvaluateRetrieval(t *testing.T) {
+	queries := []Query{
+		{ID: "q1", Query: "toys", Relevant: []string{"1"}},
+		{ID: "q2", Query: "robbers", Relevant: []string{"6", "7"}},
+	}
+	retrieve := func(ctx context.Context, query string) ([]string, error) {
+		if query == "toys" {
+			return []string{"1", "2"}, nil
+		}
+		return []string{"2", "6"}, nil
+	}
+	r, err := EvaluateRetrieval(context.Background(), queries, retrieve, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// q2: DCG = 1/log2(3) = 0.6309, ideal = 1.6309
+	want := Metrics{Recall: 0.75, Precision: 0.5, MRR: 0.75, NDCG: (1 + 0.3869) / 2}
+	if !approxEqual(r.Mean, want) {
+		t.Errorf("mean = %+v, want %+v", r.Mean, want)
+	}
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	err = r.Save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, r) {
+		t.Errorf("LoadReport = %+v, want %+v", loaded, r)
+	}
+
+	failing := func(ctx context.Context, query string) ([]string, error) {
+		return nil, errors.New("index not found")
+	}
+	_, err = EvaluateRetrieval(context.Background(), queries, failing, 2)
+	if err == nil {
+		t.Error("EvaluateRetrieval with a failing retriever succeeded")
+	}
+	_, err = EvaluateRetrieval(context.Background(), []Query{{ID: "q", Query: "toys"}}, retrieve, 2)
+	if err == nil {
+		t.Error("EvaluateRetrieval of a query without relevant movies succeeded")
+	}
+}
@@ -0,0 +1,209 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/llm"
+)
+
+// copiedRun is the number of consecutive plot words from which a query counts
+// as copied from the plot.
+const copiedRun = 5
+
+type SyntheticOptions struct {
+	// PerMovie is the number of queries asked for per movie.
+	PerMovie int
+	// Metadata adds the genres, directors and actors of the movie to the
+	// prompt, for queries like "a Spielberg movie about ...".
+	Metadata bool
+	// MaxOverlap is the share of the words of a query that may come from the
+	// plot; more literal queries are dropped.
+	MaxOverlap float64
+	// MaxSimilarity is the word overlap (Jaccard) with an earlier query from
+	// which a query is dropped as a near-duplicate.
+	MaxSimilarity float64
+}
+
+// Synthesizer has an LLM write queries that a movie answers, to build golden
+// sets from the graph. It remembers the queries it kept, to drop
+// near-duplicates of them.
+type Synthesizer struct {
+	llm  llm.Generator
+	opts SyntheticOptions
+	kept []map[string]bool
+}
+
+func NewSynthesizer(generator llm.Generator, opts SyntheticOptions) *Synthesizer {
+	if opts.PerMovie < 1 {
+		opts.PerMovie = 1
+	}
+	return &Synthesizer{llm: generator, opts: opts}
+}
+
+// Queries asks for queries about the movie and returns the ones that pass the
+// filters, with the movie as the relevant one. The dropped queries are
+// returned with the reason.
+func (s *Synthesizer) Queries(ctx context.Context, movie knowledgegraph.Movie) ([]Query, []string, error) {
+	var metadata string
+	if s.opts.Metadata {
+		metadata = fmt.Sprintf("Genres: %s\nDirectors: %s\nActors: %s\n",
+			strings.Join(movie.Genres, ", "), strings.Join(movie.Directors, ", "), strings.Join(movie.Actors[:min(3, len(movie.Actors))], ", "))
+	}
+	prompt := fmt.Sprintf(`
+You write what users type into a movie recommender when they are looking for a movie to watch.
+Write %d different requests that the movie below would be a great answer to. Write them like a user who
+has not seen the movie: short and vague, in your own words, about the story, mood or themes. Don't mention
+the title and don't copy sentences from the plot.
+Respond with JSON only, in the form {"queries": ["<request>"]}.
+
+Title: %s
+Plot: %s
+%s`, s.opts.PerMovie, movie.Title, movie.Plot, metadata)
+
+	answer, err := s.llm.GenerateJSON(ctx, prompt)
+	if err != nil {
+		return nil, nil, err
+	}
+	var response struct {
+		Queries []string `json:"queries"`
+	}
+	err = json.Unmarshal([]byte(answer), &response)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode queries for movie %s: %w", movie.MovieID, err)
+	}
+
+	var queries []Query
+	var dropped []string
+	for _, text := range response.Queries {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if reason := s.drop(text, movie); reason != "" {
+			dropped = append(dropped, fmt.Sprintf("%q: %s", text, reason))
+			continue
+		}
+		s.kept = append(s.kept, wordSet(contentWords(text)))
+		queries = append(queries, Query{
+			ID:       fmt.Sprintf("%s-%d", movie.MovieID, len(queries)+1),
+			Query:    text,
+			Relevant: []string{movie.MovieID},
+		})
+	}
+	return queries, dropped, nil
+}
+
+// drop returns why the query should be dropped, or "" to keep it.
+func (s *Synthesizer) drop(query string, movie knowledgegraph.Movie) string {
+	words := contentWords(query)
+	if len(words) == 0 {
+		return "no content words"
+	}
+	if title := normalizeTitle(movie.Title); title != "" && strings.Contains(" "+normalizeTitle(query)+" ", " "+title+" ") {
+		return "mentions the title"
+	}
+
+	plot := contentWords(movie.Plot)
+	inPlot := wordSet(plot)
+	overlap := 0
+	for _, word := range words {
+		if inPlot[word] {
+			overlap++
+		}
+	}
+	if share := float64(overlap) / float64(len(words)); share > s.opts.MaxOverlap {
+		return fmt.Sprintf("%.0f%% of its words are from the plot", 100*share)
+	}
+	if copied(allWords(query), allWords(movie.Plot)) {
+		return fmt.Sprintf("copies %d words of the plot", copiedRun)
+	}
+
+	set := wordSet(words)
+	for _, kept := range s.kept {
+		if similarity := jaccard(set, kept); similarity > s.opts.MaxSimilarity {
+			return fmt.Sprintf("%.0f%% like an earlier query", 100*similarity)
+		}
+	}
+	return ""
+}
+
+// Sample picks n movies at random, or all of them in random order when there
+// are fewer.
+func Sample(movies []knowledgegraph.Movie, n int, rng *rand.Rand) []knowledgegraph.Movie {
+	sample := make([]knowledgegraph.Movie, len(movies))
+	copy(sample, movies)
+	rng.Shuffle(len(sample), func(i, j int) { sample[i], sample[j] = sample[j], sample[i] })
+	return sample[:min(n, len(sample))]
+}
+
+var wordPattern = regexp.MustCompile(`[a-z0-9']+`)
+
+// stopWords are left out of the word overlap of queries.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "and": true, "or": true, "but": true, "of": true, "in": true,
+	"on": true, "at": true, "to": true, "for": true, "with": true, "by": true, "from": true, "about": true,
+	"is": true, "are": true, "was": true, "be": true, "it": true, "its": true, "that": true, "this": true,
+	"who": true, "where": true, "when": true, "what": true, "his": true, "her": true, "their": true,
+	"he": true, "she": true, "they": true, "i": true, "me": true, "my": true, "want": true, "movie": true,
+	"movies": true, "film": true, "films": true, "something": true, "like": true, "some": true,
+}
+
+func allWords(text string) []string {
+	return wordPattern.FindAllString(strings.ToLower(text), -1)
+}
+
+func contentWords(text string) []string {
+	var words []string
+	for _, word := range allWords(text) {
+		if !stopWords[word] {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+func wordSet(words []string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range words {
+		set[word] = true
+	}
+	return set
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	shared := 0
+	for word := range a {
+		if b[word] {
+			shared++
+		}
+	}
+	union := len(a) + len(b) - shared
+	if union == 0 {
+		return 0
+	}
+	return float64(shared) / float64(union)
+}
+
+// copied reports whether the query has copiedRun consecutive words of the
+// plot.
+func copied(query, plot []string) bool {
+	if len(query) < copiedRun {
+		return false
+	}
+	runs := map[string]bool{}
+	for i := 0; i+copiedRun <= len(plot); i++ {
+		runs[strings.Join(plot[i:i+copiedRun], " ")] = true
+	}
+	for i := 0; i+copiedRun <= len(query); i++ {
+		if runs[strings.Join(query[i:i+copiedRun], " ")] {
+			return true
+		}
+	}
+	return false
+}
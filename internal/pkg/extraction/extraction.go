@@ -0,0 +1,172 @@
+package extraction
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/llm"
+)
+
+// Extraction holds the validated facts extracted from a chunk, and the reasons
+// why other facts the LLM returned were dropped.
+type Extraction struct {
+	Entities  []knowledgegraph.Entity   `json:"entities"`
+	Relations []knowledgegraph.Relation `json:"relations"`
+	Dropped   []string                  `json:"dropped,omitempty"`
+}
+
+type Extractor struct {
+	llm      llm.LLM
+	ontology Ontology
+}
+
+func NewExtractor(llm llm.LLM, ontology Ontology) *Extractor {
+	return &Extractor{
+		llm:      llm,
+		ontology: ontology,
+	}
+}
+
+// rawExtraction is the JSON the LLM is asked to produce.
+type rawExtraction struct {
+	Entities []struct {
+		Name        string `json:"name"`
+		Type        string `json:"type"`
+		Description string `json:"description"`
+	} `json:"entities"`
+	Relationships []struct {
+		Source string `json:"source"`
+		Target string `json:"target"`
+		Type   string `json:"type"`
+	} `json:"relationships"`
+}
+
+// Extract asks the LLM, in JSON mode, for the entities and relationships in
+// the text, and validates them against the ontology.
+func (e *Extractor) Extract(ctx context.Context, text string) (Extraction, error) {
+	prompt := fmt.Sprintf(`
+You extract a knowledge graph from text. Find the entities in the text below and the relationships between
+them. Only use these types:
+
+%s
+Respond with JSON only, in the form:
+{"entities": [{"name": "<name>", "type": "<entity type>", "description": "<one sentence from the text>"}],
+ "relationships": [{"source": "<entity name>", "target": "<entity name>", "type": "<relationship type>"}]}
+
+Use the full name of an entity as it appears in the text. Only extract facts stated in the text.
+
+### Text:
+%s
+`, e.ontology.describe(), text)
+
+	answer, err := e.llm.GenerateJSON(ctx, prompt)
+	if err != nil {
+		return Extraction{}, err
+	}
+
+	var raw rawExtraction
+	err = json.Unmarshal([]byte(answer), &raw)
+	if err != nil {
+		return Extraction{}, fmt.Errorf("failed to decode extraction: %w", err)
+	}
+	return e.validate(raw), nil
+}
+
+// validate keeps the entities with a known type and a name, and the
+// relationships with a known type between extracted entities of the right
+// types. Names and types are normalised and duplicates are dropped.
+func (e *Extractor) validate(raw rawExtraction) Extraction {
+	var extraction Extraction
+
+	// types maps entity names to their types; an entity extracted with more
+	// than one type is ambiguous as relationship endpoint.
+	types := map[string][]string{}
+	seen := map[string]bool{}
+	for _, entity := range raw.Entities {
+		name := strings.Join(strings.Fields(entity.Name), " ")
+		if name == "" {
+			extraction.Dropped = append(extraction.Dropped, "entity without a name")
+			continue
+		}
+		entityType := e.ontology.entityType(strings.TrimSpace(entity.Type))
+		if entityType == "" {
+			extraction.Dropped = append(extraction.Dropped, fmt.Sprintf("entity %q has unknown type %q", name, entity.Type))
+			continue
+		}
+		key := entityType + "\x00" + name
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		types[name] = append(types[name], entityType)
+		extraction.Entities = append(extraction.Entities, knowledgegraph.Entity{
+			Name:        name,
+			Type:        entityType,
+			Description: strings.TrimSpace(entity.Description),
+		})
+	}
+
+	seenRelations := map[knowledgegraph.Relation]bool{}
+	for _, rel := range raw.Relationships {
+		source := strings.Join(strings.Fields(rel.Source), " ")
+		target := strings.Join(strings.Fields(rel.Target), " ")
+		relationType, ok := e.ontology.relationType(rel.Type)
+		if !ok {
+			extraction.Dropped = append(extraction.Dropped, fmt.Sprintf("relationship %q -> %q has unknown type %q", source, target, rel.Type))
+			continue
+		}
+		sourceType, ok := endpointType(types[source], relationType.From)
+		if !ok {
+			extraction.Dropped = append(extraction.Dropped, fmt.Sprintf("relationship %s has source %q that is not an extracted %s", relationType.Type, source, orAny(relationType.From)))
+			continue
+		}
+		targetType, ok := endpointType(types[target], relationType.To)
+		if !ok {
+			extraction.Dropped = append(extraction.Dropped, fmt.Sprintf("relationship %s has target %q that is not an extracted %s", relationType.Type, target, orAny(relationType.To)))
+			continue
+		}
+
+		relation := knowledgegraph.Relation{
+			Source:     source,
+			SourceType: sourceType,
+			Target:     target,
+			TargetType: targetType,
+			Type:       relationType.Type,
+		}
+		if seenRelations[relation] {
+			continue
+		}
+		seenRelations[relation] = true
+		extraction.Relations = append(extraction.Relations, relation)
+	}
+
+	return extraction
+}
+
+// endpointType picks the type of a relationship endpoint from the types the
+// entity was extracted with. Without a required type the entity must have
+// exactly one type.
+func endpointType(types []string, required string) (string, bool) {
+	if required == "" {
+		if len(types) == 1 {
+			return types[0], true
+		}
+		return "", false
+	}
+	for _, t := range types {
+		if t == required {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+func orAny(entityType string) string {
+	if entityType == "" {
+		return "entity"
+	}
+	return entityType
+}
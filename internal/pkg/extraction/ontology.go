@@ -0,0 +1,123 @@
+package extraction
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+)
+
+// Ontology lists the entity types and relationship types the LLM may extract.
+type Ontology struct {
+	Entities      []string       `json:"entities"`
+	Relationships []RelationType `json:"relationships"`
+}
+
+// RelationType is a relationship type with the entity types it connects. An
+// empty From or To accepts any entity type.
+type RelationType struct {
+	Type string `json:"type"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// DefaultOntology covers the movie domain of the recommendations dataset.
+var DefaultOntology = Ontology{
+	Entities: []string{"Person", "Movie", "Character", "Genre", "Organization", "Place"},
+	Relationships: []RelationType{
+		{Type: "ACTED_IN", From: "Person", To: "Movie"},
+		{Type: "DIRECTED", From: "Person", To: "Movie"},
+		{Type: "WROTE", From: "Person", To: "Movie"},
+		{Type: "PLAYED", From: "Person", To: "Character"},
+		{Type: "APPEARS_IN", From: "Character", To: "Movie"},
+		{Type: "PRODUCED", From: "Organization", To: "Movie"},
+		{Type: "IN_GENRE", From: "Movie", To: "Genre"},
+		{Type: "SET_IN", From: "Movie", To: "Place"},
+		{Type: "RELATED_TO"},
+	},
+}
+
+// LoadOntology reads an ontology from a JSON file and validates it.
+func LoadOntology(path string) (Ontology, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Ontology{}, err
+	}
+	var o Ontology
+	err = json.Unmarshal(data, &o)
+	if err != nil {
+		return Ontology{}, fmt.Errorf("failed to decode ontology %s: %w", path, err)
+	}
+	err = o.Validate()
+	if err != nil {
+		return Ontology{}, fmt.Errorf("invalid ontology %s: %w", path, err)
+	}
+	return o, nil
+}
+
+// Validate checks that every type can be used as a label or relationship type
+// and that relationships only refer to known entity types.
+func (o Ontology) Validate() error {
+	if len(o.Entities) == 0 {
+		return fmt.Errorf("no entity types")
+	}
+	for _, entity := range o.Entities {
+		if !knowledgegraph.ValidIdentifier(entity) {
+			return fmt.Errorf("entity type %q is not a valid label", entity)
+		}
+	}
+	for _, relation := range o.Relationships {
+		if !knowledgegraph.ValidIdentifier(relation.Type) {
+			return fmt.Errorf("relationship type %q is not a valid relationship type", relation.Type)
+		}
+		for _, endpoint := range []string{relation.From, relation.To} {
+			if endpoint != "" && o.entityType(endpoint) == "" {
+				return fmt.Errorf("relationship type %s refers to unknown entity type %q", relation.Type, endpoint)
+			}
+		}
+	}
+	return nil
+}
+
+// entityType returns the entity type matching name case-insensitively, or ""
+// when the ontology doesn't have it.
+func (o Ontology) entityType(name string) string {
+	for _, entity := range o.Entities {
+		if strings.EqualFold(entity, name) {
+			return entity
+		}
+	}
+	return ""
+}
+
+// relationType returns the relationship type matching name, ignoring case and
+// treating spaces and dashes as underscores.
+func (o Ontology) relationType(name string) (RelationType, bool) {
+	name = strings.NewReplacer(" ", "_", "-", "_").Replace(strings.TrimSpace(name))
+	for _, relation := range o.Relationships {
+		if strings.EqualFold(relation.Type, name) {
+			return relation, true
+		}
+	}
+	return RelationType{}, false
+}
+
+// describe renders the ontology for the extraction prompt.
+func (o Ontology) describe() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Entity types: %s\n", strings.Join(o.Entities, ", "))
+	b.WriteString("Relationship types:\n")
+	for _, relation := range o.Relationships {
+		from, to := relation.From, relation.To
+		if from == "" {
+			from = "any"
+		}
+		if to == "" {
+			to = "any"
+		}
+		fmt.Fprintf(&b, "- %s (from %s to %s)\n", relation.Type, from, to)
+	}
+	return b.String()
+}
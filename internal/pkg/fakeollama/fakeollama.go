@@ -0,0 +1,455 @@
+// Package fakeollama is an in-process stand-in for Ollama, which also serves
+// the /api/embeddings endpoint of the embeddings service. It answers with
+// deterministic embeddings and templated or scripted answers, and can inject
+// latency, errors, malformed chunks and truncated streams, to exercise the
+// error paths of the clients.
+package fakeollama
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/llm"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/ollama"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/utils"
+)
+
+// DefaultAnswer is the answer template of New.
+const DefaultAnswer = "Fake answer from {{.Model}} to a prompt of {{len .Prompt}} bytes."
+
+// Scripted answers prompts that contain Contains with Answer.
+type Scripted struct {
+	Contains string
+	Answer   string
+}
+
+// Fault is a failure injected into the responses of an endpoint.
+type Fault struct {
+	// Latency delays the response, and every chunk of a stream.
+	Latency time.Duration
+	// Status fails the request with this status and an Ollama error body.
+	Status int
+	// Count limits the fault to the next Count requests; 0 keeps it until it
+	// is cleared.
+	Count int
+	// After is the number of chunks a stream sends before Malformed,
+	// Truncate or StreamError take effect.
+	After int
+	// Malformed sends a chunk that is not JSON.
+	Malformed bool
+	// Truncate closes the stream without the done chunk.
+	Truncate bool
+	// StreamError sends an {"error": ...} chunk, as Ollama does when a model
+	// fails while streaming.
+	StreamError string
+}
+
+// Server fakes the Ollama API. Set its fields before it serves requests;
+// faults can be changed at any time.
+type Server struct {
+	// Models are the pulled models; requests for other models get 404 Not
+	// Found like from Ollama. Empty accepts every model.
+	Models []string
+	// Dimensions is the length of the embeddings.
+	Dimensions int
+	// NumCtx is the context window /api/show reports.
+	NumCtx int
+	// Scripted answers are tried in order before Answer.
+	Scripted []Scripted
+	// Answer is executed with the Model and the Prompt, which for chats is
+	// the content of the last message.
+	Answer *template.Template
+
+	mu       sync.Mutex
+	faults   map[string]Fault
+	requests map[string]int
+}
+
+var _ http.Handler = (*Server)(nil)
+
+// New returns a server with 384-dimensional embeddings, a context window of
+// 2048 tokens and DefaultAnswer for the models.
+func New(models ...string) *Server {
+	return &Server{
+		Models:     models,
+		Dimensions: 384,
+		NumCtx:     2048,
+		Answer:     template.Must(template.New("answer").Parse(DefaultAnswer)),
+		faults:     map[string]Fault{},
+		requests:   map[string]int{},
+	}
+}
+
+// Start serves the fake on a local port. Its URL is the address for the
+// clients; Close stops it.
+func (s *Server) Start() *httptest.Server {
+	return httptest.NewServer(s)
+}
+
+// SetFault injects the fault into the responses of the endpoint, like
+// "/api/generate".
+func (s *Server) SetFault(endpoint string, fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[endpoint] = fault
+}
+
+// ClearFaults removes the faults of all endpoints.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = map[string]Fault{}
+}
+
+// Requests returns the number of requests the endpoint got.
+func (s *Server) Requests(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[endpoint]
+}
+
+// fault counts the request and returns the fault to inject into it.
+func (s *Server) fault(endpoint string) Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[endpoint]++
+	fault, ok := s.faults[endpoint]
+	if !ok {
+		return Fault{}
+	}
+	if fault.Count > 0 {
+		fault.Count--
+		if fault.Count == 0 {
+			delete(s.faults, endpoint)
+		} else {
+			s.faults[endpoint] = fault
+		}
+	}
+	return fault
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fault := s.fault(r.URL.Path)
+	// The server only notices a client going away, canceling the request
+	// context, once the body has been read.
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if !wait(r.Context(), fault.Latency) {
+		return
+	}
+	if fault.Status != 0 {
+		writeError(w, fault.Status, fmt.Sprintf("injected %d", fault.Status))
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/api/tags" && r.Method == http.MethodGet:
+		s.tags(w)
+	case r.Method != http.MethodPost:
+		writeError(w, http.StatusNotFound, "404 page not found")
+	case r.URL.Path == "/api/generate":
+		s.generate(w, r, fault)
+	case r.URL.Path == "/api/chat":
+		s.chat(w, r, fault)
+	case r.URL.Path == "/api/embeddings":
+		s.embeddings(w, r)
+	case r.URL.Path == "/api/embed":
+		s.embed(w, r)
+	case r.URL.Path == "/api/show":
+		s.show(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "404 page not found")
+	}
+}
+
+func (s *Server) tags(w http.ResponseWriter) {
+	models := []ollama.Model{}
+	for _, name := range s.Models {
+		models = append(models, ollama.Model{
+			Name:       name,
+			ModifiedAt: time.Unix(0, 0).UTC(),
+			Digest:     fmt.Sprintf("%x", sha256.Sum256([]byte(name))),
+			Details:    ollama.ModelDetails{Format: "gguf", Family: "fake", ParameterSize: "7B", QuantizationLevel: "Q4_0"},
+		})
+	}
+	writeJSON(w, map[string]any{"models": models})
+}
+
+func (s *Server) show(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Model string `json:"model"`
+		Name  string `json:"name"`
+	}
+	if !decode(w, r, &request) {
+		return
+	}
+	model := request.Model
+	if model == "" {
+		model = request.Name
+	}
+	if !s.pulled(w, model) {
+		return
+	}
+	writeJSON(w, ollama.ModelInfo{
+		Details:      ollama.ModelDetails{Format: "gguf", Family: "fake", ParameterSize: "7B", QuantizationLevel: "Q4_0"},
+		Parameters:   fmt.Sprintf("num_ctx %d", s.NumCtx),
+		ModelInfo:    map[string]any{"fake.context_length": s.NumCtx},
+		Capabilities: []string{"completion", "embedding"},
+	})
+}
+
+func (s *Server) generate(w http.ResponseWriter, r *http.Request, fault Fault) {
+	var request struct {
+		ollama.GenerateRequest
+		Stream *bool `json:"stream"`
+	}
+	if !decode(w, r, &request) || !s.pulled(w, request.Model) {
+		return
+	}
+	// An empty prompt loads the model, as WarmUp does.
+	if request.Prompt == "" {
+		writeJSON(w, ollama.GenerateResponse{Model: request.Model, CreatedAt: time.Now(), Done: true})
+		return
+	}
+	answer, err := s.answer(request.Model, request.Prompt, request.Format)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	promptTokens := tokens(request.Prompt)
+	if request.Stream != nil && !*request.Stream {
+		writeJSON(w, ollama.GenerateResponse{
+			Model:           request.Model,
+			CreatedAt:       time.Now(),
+			Response:        answer,
+			Done:            true,
+			PromptEvalCount: promptTokens,
+			EvalCount:       tokens(answer),
+		})
+		return
+	}
+	chunks := strings.SplitAfter(answer, " ")
+	stream(r.Context(), w, fault, len(chunks), func(i int) any {
+		if i == len(chunks) {
+			return ollama.GenerateResponse{Model: request.Model, CreatedAt: time.Now(), Done: true, PromptEvalCount: promptTokens, EvalCount: tokens(answer)}
+		}
+		return ollama.GenerateResponse{Model: request.Model, CreatedAt: time.Now(), Response: chunks[i]}
+	})
+}
+
+func (s *Server) chat(w http.ResponseWriter, r *http.Request, fault Fault) {
+	var request struct {
+		ollama.ChatRequest
+		Stream *bool  `json:"stream"`
+		Format string `json:"format"`
+	}
+	if !decode(w, r, &request) || !s.pulled(w, request.Model) {
+		return
+	}
+	var prompt string
+	promptTokens := 0
+	for _, message := range request.Messages {
+		prompt = message.Content
+		promptTokens += tokens(message.Content)
+	}
+	answer, err := s.answer(request.Model, prompt, request.Format)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if request.Stream != nil && !*request.Stream {
+		writeJSON(w, ollama.ChatResponse{
+			Model:           request.Model,
+			Message:         llm.Message{Role: llm.RoleAssistant, Content: answer},
+			Done:            true,
+			PromptEvalCount: promptTokens,
+			EvalCount:       tokens(answer),
+		})
+		return
+	}
+	chunks := strings.SplitAfter(answer, " ")
+	stream(r.Context(), w, fault, len(chunks), func(i int) any {
+		if i == len(chunks) {
+			return ollama.ChatResponse{Model: request.Model, Message: llm.Message{Role: llm.RoleAssistant}, Done: true, PromptEvalCount: promptTokens, EvalCount: tokens(answer)}
+		}
+		return ollama.ChatResponse{Model: request.Model, Message: llm.Message{Role: llm.RoleAssistant, Content: chunks[i]}}
+	})
+}
+
+func (s *Server) embeddings(w http.ResponseWriter, r *http.Request) {
+	var request ollama.EmbeddingRequest
+	if !decode(w, r, &request) || !s.pulled(w, request.Model) {
+		return
+	}
+	writeJSON(w, ollama.Embedding{Embedding: s.Embedding(request.Prompt)})
+}
+
+func (s *Server) embed(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Model string          `json:"model"`
+		Input json.RawMessage `json:"input"`
+	}
+	if !decode(w, r, &request) || !s.pulled(w, request.Model) {
+		return
+	}
+	var inputs []string
+	if json.Unmarshal(request.Input, &inputs) != nil {
+		var input string
+		if err := json.Unmarshal(request.Input, &input); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid input")
+			return
+		}
+		inputs = []string{input}
+	}
+	vectors := make([][]float32, len(inputs))
+	for i, input := range inputs {
+		vectors[i] = s.Embedding(input)
+	}
+	writeJSON(w, map[string]any{"model": request.Model, "embeddings": vectors})
+}
+
+// answer returns the first scripted answer for the prompt, or executes the
+// answer template. JSON mode answers that aren't JSON become {}.
+func (s *Server) answer(model, prompt, format string) (string, error) {
+	answer, scripted := "", false
+	for _, script := range s.Scripted {
+		if strings.Contains(prompt, script.Contains) {
+			answer, scripted = script.Answer, true
+			break
+		}
+	}
+	if !scripted {
+		var b strings.Builder
+		err := s.Answer.Execute(&b, struct{ Model, Prompt string }{model, prompt})
+		if err != nil {
+			return "", fmt.Errorf("failed to execute answer template: %w", err)
+		}
+		answer = b.String()
+	}
+	if format == "json" && !json.Valid([]byte(answer)) {
+		return "{}", nil
+	}
+	return answer, nil
+}
+
+var wordPattern = regexp.MustCompile(`[a-z0-9]+`)
+
+// Embedding returns the embedding the fake gives the text: a unit vector
+// with a dimension per word, picked by hashing the word, so texts that share
+// words are similar.
+func (s *Server) Embedding(text string) []float32 {
+	vector := make([]float32, s.Dimensions)
+	for _, word := range wordPattern.FindAllString(strings.ToLower(text), -1) {
+		hash := sha256.Sum256([]byte(word))
+		i := binary.BigEndian.Uint32(hash[:4]) % uint32(s.Dimensions)
+		if hash[4]&1 == 0 {
+			vector[i]++
+		} else {
+			vector[i]--
+		}
+	}
+	if utils.Norm(vector) == 0 {
+		vector[0] = 1
+		return vector
+	}
+	return utils.Normalise(vector)
+}
+
+// pulled answers 404 Not Found like Ollama when the model isn't pulled.
+func (s *Server) pulled(w http.ResponseWriter, model string) bool {
+	if len(s.Models) == 0 {
+		return true
+	}
+	for _, name := range s.Models {
+		if name == model || name == model+":latest" || strings.TrimSuffix(name, ":latest") == model {
+			return true
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("model %q not found, try pulling it first", model))
+	return false
+}
+
+// stream writes n chunks and the done chunk of chunk as NDJSON, with the
+// stream faults. It stops when the client goes away.
+func stream(ctx context.Context, w http.ResponseWriter, fault Fault, n int, chunk func(i int) any) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	for i := 0; i <= n; i++ {
+		if i > 0 && !wait(ctx, fault.Latency) {
+			return
+		}
+		if i == fault.After {
+			switch {
+			case fault.Malformed:
+				fmt.Fprintln(w, `{"response": "malformed`)
+			case fault.StreamError != "":
+				encoder.Encode(map[string]string{"error": fault.StreamError})
+				return
+			case fault.Truncate:
+				return
+			}
+		}
+		encoder.Encode(chunk(i))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// wait waits for the latency, and reports false when the request is canceled
+// first.
+func wait(ctx context.Context, latency time.Duration) bool {
+	if latency <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(latency):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// tokens estimates the tokens of the text at 4 bytes per token.
+func tokens(text string) int {
+	return max(1, len(text)/4)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
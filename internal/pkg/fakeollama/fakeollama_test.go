@@ -0,0 +1,240 @@
+package fakeollama_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/embeddings"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/fakeollama"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/llm"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/ollama"
+)
+
+func start(t *testing.T, models ...string) (*fakeollama.Server, string) {
+	t.Helper()
+	fake := fakeollama.New(models...)
+	server := fake.Start()
+	t.Cleanup(server.Close)
+	return fake, server.URL
+}
+
+// readStream returns the text of the stream, its last chunk and the error that
+// stopped it.
+func readStream(stream *llm.Stream) (string, llm.Chunk, error) {
+	defer stream.Close()
+	var b strings.Builder
+	var last llm.Chunk
+	for stream.Next() {
+		last = stream.Chunk()
+		b.WriteString(last.Text)
+	}
+	return b.String(), last, stream.Err()
+}
+
+func TestGenerate(t *testing.T) {
+	fake, url := start(t, "llama2")
+	fake.Scripted = []fakeollama.Scripted{{Contains: "toys", Answer: "Watch Toy Story."}}
+	client := ollama.NewOllama("llama2", url)
+
+	answer, err := client.Generate(context.Background(), "Which movie has toys?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != "Watch Toy Story." {
+		t.Errorf("scripted answer = %q", answer)
+	}
+	answer, err = client.Generate(context.Background(), "Anything else?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Fake answer from llama2 to a prompt of 14 bytes."; answer != want {
+		t.Errorf("templated answer = %q, want %q", answer, want)
+	}
+
+	_, err = ollama.NewOllama("mistral", url).Generate(context.Background(), "Which movie has toys?")
+	if !errors.Is(err, ollama.ErrNotFound) {
+		t.Errorf("unpulled model: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestGenerateStreamFaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		fault    fakeollama.Fault
+		wantText string
+		wantErr  string
+	}{
+		{"no fault", fakeollama.Fault{}, "Watch Toy Story.", ""},
+		{"malformed", fakeollama.Fault{Malformed: true, After: 1}, "Watch ", "failed to decode stream response"},
+		{"truncate", fakeollama.Fault{Truncate: true, After: 2}, "Watch Toy ", llm.ErrTruncated.Error()},
+		{"stream error", fakeollama.Fault{StreamError: "model crashed", After: 1}, "Watch ", "stream failed: model crashed"},
+		{"status", fakeollama.Fault{Status: http.StatusServiceUnavailable}, "", "503 Service Unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, url := start(t, "llama2")
+			fake.Scripted = []fakeollama.Scripted{{Contains: "toys", Answer: "Watch Toy Story."}}
+			fake.SetFault("/api/generate", tt.fault)
+			client := ollama.NewOllama("llama2", url)
+
+			var text string
+			stream, err := client.GenerateStream(context.Background(), "Which movie has toys?")
+			if err == nil {
+				text, _, err = readStream(stream)
+			}
+			if text != tt.wantText {
+				t.Errorf("text = %q, want %q", text, tt.wantText)
+			}
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("err = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFaultCount(t *testing.T) {
+	fake, url := start(t, "llama2")
+	fake.SetFault("/api/generate", fakeollama.Fault{Status: http.StatusInternalServerError, Count: 2})
+	client := ollama.NewOllama("llama2", url)
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Generate(context.Background(), "Which movie has toys?")
+		if failed := err != nil; failed != (i < 2) {
+			t.Errorf("request %d: err = %v", i+1, err)
+		}
+	}
+	if got := fake.Requests("/api/generate"); got != 3 {
+		t.Errorf("Requests = %d, want 3", got)
+	}
+}
+
+func TestLatencyStopsWithRequest(t *testing.T) {
+	fake, url := start(t, "llama2")
+	fake.SetFault("/api/generate", fakeollama.Fault{Latency: time.Minute})
+	client := ollama.NewOllama("llama2", url)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.Generate(ctx, "Which movie has toys?")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want DeadlineExceeded", err)
+	}
+	// The cleanup closing the server waits for the handler, which must not
+	// sleep out the latency.
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("request took %s", elapsed)
+	}
+}
+
+func TestOllamaEmbeddings(t *testing.T) {
+	fake, url := start(t, "nomic-embed-text")
+	embedder := embeddings.NewOllamaEmbeddings("nomic-embed-text", url)
+
+	vectors, err := embedder.EmbeddingBatch(context.Background(), []string{"toys come to life", "a bank heist"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) != 2 || len(vectors[0]) != fake.Dimensions {
+		t.Fatalf("got %d vectors of %d dimensions", len(vectors), len(vectors[0]))
+	}
+	for i, text := range []string{"toys come to life", "a bank heist"} {
+		want := fake.Embedding(text)
+		for j := range want {
+			if vectors[i][j] != want[j] {
+				t.Fatalf("vector %d differs from Embedding(%q) at %d", i, text, j)
+			}
+		}
+	}
+
+	// Without /api/embed, like older Ollama versions, it falls back to
+	// /api/embeddings.
+	fake.SetFault("/api/embed", fakeollama.Fault{Status: http.StatusNotFound})
+	legacy := embeddings.NewOllamaEmbeddings("nomic-embed-text", url)
+	_, err = legacy.EmbeddingBatch(context.Background(), []string{"toys come to life", "a bank heist"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.Requests("/api/embeddings"); got != 2 {
+		t.Errorf("legacy requests = %d, want 2", got)
+	}
+
+	fake.ClearFaults()
+	fake.SetFault("/api/embed", fakeollama.Fault{Status: http.StatusInternalServerError})
+	_, err = embedder.Embedding(context.Background(), "toys come to life")
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("err = %v, want status 500", err)
+	}
+}
+
+func TestRouterFallsBack(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault fakeollama.Fault
+	}{
+		{"status", fakeollama.Fault{Status: http.StatusInternalServerError}},
+		{"latency", fakeollama.Fault{Latency: time.Minute}},
+		{"malformed", fakeollama.Fault{Malformed: true}},
+		{"stream error", fakeollama.Fault{StreamError: "out of memory"}},
+		{"truncate", fakeollama.Fault{Truncate: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary, primaryURL := start(t, "llama2")
+			_, fallbackURL := start(t, "mistral")
+			primary.SetFault("/api/generate", tt.fault)
+			router, err := llm.NewRouter([]llm.Backend{
+				{Name: "primary", LLM: ollama.NewOllama("llama2", primaryURL), Timeout: 100 * time.Millisecond},
+				{Name: "fallback", LLM: ollama.NewOllama("mistral", fallbackURL)},
+			}, nil, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stream, err := router.GenerateStream(context.Background(), "Which movie has toys?")
+			if err != nil {
+				t.Fatal(err)
+			}
+			text, last, err := readStream(stream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(text, "Fake answer from mistral") {
+				t.Errorf("answer = %q, want the fallback's", text)
+			}
+			if last.Served == nil || last.Served.Backend != "fallback" || len(last.Served.Failed) != 1 {
+				t.Errorf("served = %+v, want fallback after 1 failed backend", last.Served)
+			}
+			if got := primary.Requests("/api/generate"); got != 1 {
+				t.Errorf("primary requests = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestRouterAllFail(t *testing.T) {
+	fake, url := start(t, "llama2")
+	fake.SetFault("/api/generate", fakeollama.Fault{Status: http.StatusServiceUnavailable})
+	router, err := llm.NewRouter([]llm.Backend{
+		{Name: "first", LLM: ollama.NewOllama("llama2", url)},
+		{Name: "second", LLM: ollama.NewOllama("llama2", url)},
+	}, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = router.Generate(context.Background(), "Which movie has toys?")
+	if err == nil || !strings.Contains(err.Error(), "first:") || !strings.Contains(err.Error(), "second:") {
+		t.Errorf("err = %v, want the errors of both backends", err)
+	}
+	if got := fake.Requests("/api/generate"); got != 2 {
+		t.Errorf("requests = %d, want 2", got)
+	}
+}
@@ -0,0 +1,445 @@
+package hnsw
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/utils"
+)
+
+var ErrDimensions = errors.New("vector has the wrong number of dimensions")
+
+// Index is a Hierarchical Navigable Small World graph for approximate nearest
+// neighbour search by cosine similarity, after Malkov and Yashunin. Vectors
+// are identified by a string ID. Adding an ID again replaces its vector, and
+// removed vectors stay in the graph as tombstones, so both are incremental.
+type Index struct {
+	mu sync.RWMutex
+
+	// M is the number of neighbours of a vector on the layers above 0; layer
+	// 0 keeps 2*M. Larger M gives better recall at the cost of memory.
+	m int
+	// efConstruction is the size of the candidate list while inserting.
+	efConstruction int
+	// efSearch is the default size of the candidate list while searching.
+	efSearch int
+
+	dimensions int
+	nodes      []*node
+	ids        map[string]int
+	entry      int
+	maxLevel   int
+	deleted    int
+	rand       *rand.Rand
+}
+
+type node struct {
+	id      string
+	vector  []float32
+	level   int
+	links   [][]int
+	deleted bool
+}
+
+// Result is a vector found by Search, with its cosine similarity to the query.
+type Result struct {
+	ID         string
+	Similarity float64
+}
+
+// New returns an empty index. m is the number of neighbours per vector, and
+// efConstruction and efSearch the size of the candidate lists when inserting
+// and searching. Zero values default to 16, 200 and 64.
+func New(m, efConstruction, efSearch int) *Index {
+	if m <= 1 {
+		m = 16
+	}
+	if efConstruction <= 0 {
+		efConstruction = 200
+	}
+	if efSearch <= 0 {
+		efSearch = 64
+	}
+	return &Index{
+		m:              m,
+		efConstruction: efConstruction,
+		efSearch:       efSearch,
+		ids:            map[string]int{},
+		entry:          -1,
+		rand:           rand.New(rand.NewSource(1)),
+	}
+}
+
+// Len returns the number of vectors in the index, without the removed ones.
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.ids)
+}
+
+// Tombstones returns the number of removed or replaced vectors still in the
+// graph. Rebuilding the index gets rid of them.
+func (ix *Index) Tombstones() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.deleted
+}
+
+// Vector returns the stored (normalised) vector of the ID.
+func (ix *Index) Vector(id string) ([]float32, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	i, ok := ix.ids[id]
+	if !ok {
+		return nil, false
+	}
+	return ix.nodes[i].vector, true
+}
+
+// Contains reports whether the index has the vector for the ID, so unchanged
+// vectors can be skipped when updating the index.
+func (ix *Index) Contains(id string, vector []float32) bool {
+	stored, ok := ix.Vector(id)
+	if !ok || len(stored) != len(vector) {
+		return false
+	}
+	for i, v := range utils.Normalise(vector) {
+		if stored[i] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// IDs returns the IDs in the index.
+func (ix *Index) IDs() []string {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	ids := make([]string, 0, len(ix.ids))
+	for id := range ix.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Add inserts the vector of the ID, replacing its previous vector. All vectors
+// must have the same number of dimensions.
+func (ix *Index) Add(id string, vector []float32) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if ix.dimensions == 0 {
+		ix.dimensions = len(vector)
+	}
+	if len(vector) != ix.dimensions || len(vector) == 0 {
+		return ErrDimensions
+	}
+	ix.remove(id)
+
+	n := &node{
+		id:     id,
+		vector: utils.Normalise(vector),
+		level:  ix.randomLevel(),
+	}
+	n.links = make([][]int, n.level+1)
+	i := len(ix.nodes)
+	ix.nodes = append(ix.nodes, n)
+	ix.ids[id] = i
+
+	if ix.entry < 0 {
+		ix.entry = i
+		ix.maxLevel = n.level
+		return nil
+	}
+
+	entry := ix.entry
+	for level := ix.maxLevel; level > n.level; level-- {
+		entry = ix.greedy(n.vector, entry, level)
+	}
+	for level := min(n.level, ix.maxLevel); level >= 0; level-- {
+		candidates := ix.searchLayer(n.vector, []int{entry}, ix.efConstruction, level)
+		neighbours := ix.selectNeighbours(n.vector, candidates, ix.m)
+		n.links[level] = neighbours
+		for _, neighbour := range neighbours {
+			ix.link(neighbour, i, level)
+		}
+		entry = candidates[0].node
+	}
+	if n.level > ix.maxLevel {
+		ix.entry = i
+		ix.maxLevel = n.level
+	}
+	return nil
+}
+
+// Remove removes the vector of the ID. It is left in the graph as a tombstone,
+// so it still connects its neighbours, but isn't returned by Search.
+func (ix *Index) Remove(id string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+}
+
+func (ix *Index) remove(id string) {
+	i, ok := ix.ids[id]
+	if !ok {
+		return
+	}
+	ix.nodes[i].deleted = true
+	delete(ix.ids, id)
+	ix.deleted++
+}
+
+// Search returns the k vectors most similar to the query, most similar first.
+// ef is the size of the candidate list; it is raised to k, and zero uses the
+// efSearch of the index.
+func (ix *Index) Search(query []float32, k, ef int) ([]Result, error) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	if ix.entry < 0 || k <= 0 {
+		return nil, nil
+	}
+	if len(query) != ix.dimensions {
+		return nil, ErrDimensions
+	}
+	if ef <= 0 {
+		ef = ix.efSearch
+	}
+	// Tombstones take up room in the candidate list, so search wider.
+	ef = max(ef, k) + min(ix.deleted, max(ef, k))
+
+	q := utils.Normalise(query)
+	entry := ix.entry
+	for level := ix.maxLevel; level > 0; level-- {
+		entry = ix.greedy(q, entry, level)
+	}
+
+	var results []Result
+	for _, c := range ix.searchLayer(q, []int{entry}, ef, 0) {
+		n := ix.nodes[c.node]
+		if n.deleted {
+			continue
+		}
+		results = append(results, Result{ID: n.id, Similarity: 1 - c.distance})
+		if len(results) == k {
+			break
+		}
+	}
+	return results, nil
+}
+
+// randomLevel draws the top layer of a new vector from an exponentially
+// decaying distribution, so every layer has about 1/M of the vectors of the
+// layer below.
+func (ix *Index) randomLevel() int {
+	return int(math.Floor(-math.Log(1-ix.rand.Float64()) / math.Log(float64(ix.m))))
+}
+
+func (ix *Index) maxLinks(level int) int {
+	if level == 0 {
+		return 2 * ix.m
+	}
+	return ix.m
+}
+
+// link adds a link from one node to another on the level, pruning the links
+// of the node when it has too many.
+func (ix *Index) link(from, to, level int) {
+	n := ix.nodes[from]
+	n.links[level] = append(n.links[level], to)
+	if len(n.links[level]) <= ix.maxLinks(level) {
+		return
+	}
+	candidates := make([]candidate, len(n.links[level]))
+	for i, neighbour := range n.links[level] {
+		candidates[i] = candidate{node: neighbour, distance: distance(n.vector, ix.nodes[neighbour].vector)}
+	}
+	sortCandidates(candidates)
+	n.links[level] = ix.selectNeighbours(n.vector, candidates, ix.maxLinks(level))
+}
+
+// greedy walks from the entry to the node closest to the query on the level.
+func (ix *Index) greedy(query []float32, entry, level int) int {
+	best := distance(query, ix.nodes[entry].vector)
+	for changed := true; changed; {
+		changed = false
+		for _, neighbour := range ix.nodes[entry].links[level] {
+			d := distance(query, ix.nodes[neighbour].vector)
+			if d < best {
+				best, entry, changed = d, neighbour, true
+			}
+		}
+	}
+	return entry
+}
+
+// searchLayer returns up to ef nodes closest to the query on the level,
+// closest first, exploring from the entries.
+func (ix *Index) searchLayer(query []float32, entries []int, ef, level int) []candidate {
+	visited := ix.visited()
+	defer visitedPool.Put(visited)
+	toVisit := &minHeap{}
+	found := &maxHeap{}
+	for _, e := range entries {
+		visited.visit(e)
+		c := candidate{node: e, distance: distance(query, ix.nodes[e].vector)}
+		heap.Push(toVisit, c)
+		heap.Push(found, c)
+	}
+
+	for toVisit.Len() > 0 {
+		current := heap.Pop(toVisit).(candidate)
+		if current.distance > (*found)[0].distance && found.Len() >= ef {
+			break
+		}
+		for _, neighbour := range ix.nodes[current.node].links[level] {
+			if !visited.visit(neighbour) {
+				continue
+			}
+			d := distance(query, ix.nodes[neighbour].vector)
+			if found.Len() < ef || d < (*found)[0].distance {
+				heap.Push(toVisit, candidate{node: neighbour, distance: d})
+				heap.Push(found, candidate{node: neighbour, distance: d})
+				if found.Len() > ef {
+					heap.Pop(found)
+				}
+			}
+		}
+	}
+
+	result := make([]candidate, len(*found))
+	copy(result, *found)
+	sortCandidates(result)
+	return result
+}
+
+// visitedSet marks the nodes a search visited. Marks are stamped with an
+// epoch, so the set can be reused without clearing it.
+type visitedSet struct {
+	marks []uint32
+	epoch uint32
+}
+
+var visitedPool = sync.Pool{New: func() any { return &visitedSet{} }}
+
+// visited returns an empty visitedSet for the nodes of the index.
+func (ix *Index) visited() *visitedSet {
+	v := visitedPool.Get().(*visitedSet)
+	if len(v.marks) < len(ix.nodes) {
+		v.marks = append(v.marks, make([]uint32, len(ix.nodes)-len(v.marks))...)
+	}
+	v.epoch++
+	if v.epoch == 0 {
+		clear(v.marks)
+		v.epoch = 1
+	}
+	return v
+}
+
+// visit marks the node and reports whether it wasn't visited before.
+func (v *visitedSet) visit(node int) bool {
+	if v.marks[node] == v.epoch {
+		return false
+	}
+	v.marks[node] = v.epoch
+	return true
+}
+
+// selectNeighbours picks up to m neighbours from the candidates, closest
+// first, with the heuristic of the paper: a candidate is skipped when it is
+// closer to an already selected neighbour than to the vector, so links spread
+// in different directions. Skipped candidates fill up the remaining room.
+func (ix *Index) selectNeighbours(vector []float32, candidates []candidate, m int) []int {
+	var selected, skipped []int
+	for _, c := range candidates {
+		if len(selected) >= m {
+			break
+		}
+		keep := true
+		for _, s := range selected {
+			if distance(ix.nodes[c.node].vector, ix.nodes[s].vector) < c.distance {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			selected = append(selected, c.node)
+		} else {
+			skipped = append(skipped, c.node)
+		}
+	}
+	for _, s := range skipped {
+		if len(selected) >= m {
+			break
+		}
+		selected = append(selected, s)
+	}
+	return selected
+}
+
+// distance is the cosine distance of two normalised vectors. The dot product
+// is summed in float32 over four lanes, which is where most of the time of
+// building and searching goes.
+func distance(a, b []float32) float64 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return 1 - float64(s0+s1+s2+s3)
+}
+
+type candidate struct {
+	node     int
+	distance float64
+}
+
+func sortCandidates(candidates []candidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].node < candidates[j].node
+	})
+}
+
+// minHeap pops the closest candidate first.
+type minHeap []candidate
+
+func (h minHeap) Len() int           { return len(h) }
+func (h minHeap) Less(i, j int) bool { return h[i].distance < h[j].distance }
+func (h minHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *minHeap) Push(x any)        { *h = append(*h, x.(candidate)) }
+func (h *minHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// maxHeap pops the farthest candidate first.
+type maxHeap []candidate
+
+func (h maxHeap) Len() int           { return len(h) }
+func (h maxHeap) Less(i, j int) bool { return h[i].distance > h[j].distance }
+func (h maxHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *maxHeap) Push(x any)        { *h = append(*h, x.(candidate)) }
+func (h *maxHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
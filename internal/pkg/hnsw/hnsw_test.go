@@ -0,0 +1,216 @@
+package hnsw
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/utils"
+)
+
+func randomVectors(r *rand.Rand, n, dimensions int) [][]float32 {
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		vectors[i] = make([]float32, dimensions)
+		for j := range vectors[i] {
+			vectors[i][j] = float32(r.NormFloat64())
+		}
+	}
+	return vectors
+}
+
+// buildIndex adds the vectors with their index as ID.
+func buildIndex(t *testing.T, vectors [][]float32) *Index {
+	t.Helper()
+	ix := New(0, 0, 0)
+	for i, vector := range vectors {
+		err := ix.Add(fmt.Sprint(i), vector)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ix
+}
+
+// bruteForce returns the IDs of the k vectors most similar to the query.
+func bruteForce(vectors [][]float32, query []float32, k int) []string {
+	ids := make([]int, len(vectors))
+	for i := range ids {
+		ids[i] = i
+	}
+	sort.SliceStable(ids, func(a, b int) bool {
+		return utils.CosineSimilarity(query, vectors[ids[a]]) > utils.CosineSimilarity(query, vectors[ids[b]])
+	})
+	var top []string
+	for _, i := range ids[:k] {
+		top = append(top, fmt.Sprint(i))
+	}
+	return top
+}
+
+func resultIDs(results []Result) []string {
+	ids := []string{}
+	for _, r := range results {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func TestSearchRecall(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	vectors := randomVectors(r, 2000, 32)
+	ix := buildIndex(t, vectors)
+
+	const k = 10
+	found, total := 0, 0
+	for _, query := range randomVectors(r, 50, 32) {
+		results, err := ix.Search(query, k, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < len(results); i++ {
+			if results[i].Similarity > results[i-1].Similarity {
+				t.Fatalf("results not ordered by similarity: %+v", results)
+			}
+		}
+		want := map[string]bool{}
+		for _, id := range bruteForce(vectors, query, k) {
+			want[id] = true
+		}
+		for _, id := range resultIDs(results) {
+			if want[id] {
+				found++
+			}
+		}
+		total += k
+	}
+	if recall := float64(found) / float64(total); recall < 0.95 {
+		t.Errorf("recall@%d = %.3f, want at least 0.95", k, recall)
+	}
+}
+
+func TestSearchSimilarity(t *testing.T) {
+	ix := buildIndex(t, [][]float32{{1, 0}, {1, 1}, {0, 1}, {-1, 0}})
+	results, err := ix.Search([]float32{2, 0}, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].ID != "0" || results[1].ID != "1" {
+		t.Fatalf("Search = %+v, want 0 and 1", results)
+	}
+	if sim := results[0].Similarity; sim < 0.9999 || sim > 1.0001 {
+		t.Errorf("similarity of the same direction = %f, want 1", sim)
+	}
+	if sim := results[1].Similarity; sim < 0.7070 || sim > 0.7072 {
+		t.Errorf("similarity at 45 degrees = %f, want 0.7071", sim)
+	}
+}
+
+func TestSearchEmptyAndDimensions(t *testing.T) {
+	ix := New(0, 0, 0)
+	results, err := ix.Search([]float32{1, 0}, 3, 0)
+	if err != nil || results != nil {
+		t.Errorf("Search of an empty index = %v, %v, want nothing", results, err)
+	}
+	err = ix.Add("a", []float32{1, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ix.Add("b", []float32{1, 0, 0})
+	if !errors.Is(err, ErrDimensions) {
+		t.Errorf("Add with other dimensions = %v, want %v", err, ErrDimensions)
+	}
+	_, err = ix.Search([]float32{1, 0, 0}, 3, 0)
+	if !errors.Is(err, ErrDimensions) {
+		t.Errorf("Search with other dimensions = %v, want %v", err, ErrDimensions)
+	}
+}
+
+func TestAddReplaces(t *testing.T) {
+	ix := buildIndex(t, [][]float32{{1, 0}, {0, 1}})
+	err := ix.Add("0", []float32{0, -3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ix.Len() != 2 || ix.Tombstones() != 1 {
+		t.Errorf("Len, Tombstones = %d, %d, want 2, 1", ix.Len(), ix.Tombstones())
+	}
+	vector, ok := ix.Vector("0")
+	if !ok || !reflect.DeepEqual(vector, []float32{0, -1}) {
+		t.Errorf("Vector = %v, want the new normalised vector", vector)
+	}
+	if !ix.Contains("0", []float32{0, -3}) || ix.Contains("0", []float32{1, 0}) {
+		t.Error("Contains doesn't report the new vector only")
+	}
+	results, err := ix.Search([]float32{1, 0}, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resultIDs(results); !reflect.DeepEqual(got, []string{"1", "0"}) {
+		t.Errorf("Search = %q, want the replaced vector once, last", got)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	vectors := randomVectors(r, 200, 8)
+	ix := buildIndex(t, vectors)
+	for i := 0; i < 100; i++ {
+		ix.Remove(fmt.Sprint(i))
+	}
+	ix.Remove("unknown")
+	if ix.Len() != 100 || ix.Tombstones() != 100 {
+		t.Errorf("Len, Tombstones = %d, %d, want 100, 100", ix.Len(), ix.Tombstones())
+	}
+	if _, ok := ix.Vector("0"); ok {
+		t.Error("Vector of a removed ID is still there")
+	}
+
+	results, err := ix.Search(vectors[0], 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 10 {
+		t.Errorf("Search found %d vectors, want 10", len(results))
+	}
+	for _, result := range results {
+		var i int
+		fmt.Sscan(result.ID, &i)
+		if i < 100 {
+			t.Errorf("Search returned removed vector %s", result.ID)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	vectors := randomVectors(r, 300, 8)
+	ix := buildIndex(t, vectors)
+	for i := 0; i < 300; i += 2 {
+		ix.Remove(fmt.Sprint(i))
+	}
+	ids := ix.IDs()
+
+	err := ix.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ix.Tombstones() != 0 || ix.Len() != 150 || len(ix.nodes) != 150 {
+		t.Errorf("Tombstones, Len, nodes = %d, %d, %d, want 0, 150, 150", ix.Tombstones(), ix.Len(), len(ix.nodes))
+	}
+	if !reflect.DeepEqual(ix.IDs(), ids) {
+		t.Error("Compact changed the IDs")
+	}
+	for _, i := range []int{1, 51, 299} {
+		results, err := ix.Search(vectors[i], 1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resultIDs(results); !reflect.DeepEqual(got, []string{fmt.Sprint(i)}) {
+			t.Errorf("Search for vector %d after Compact = %q", i, got)
+		}
+	}
+}
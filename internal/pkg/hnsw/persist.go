@@ -0,0 +1,145 @@
+package hnsw
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+// savedIndex is the on-disk form of an Index.
+type savedIndex struct {
+	M              int
+	EfConstruction int
+	EfSearch       int
+	Dimensions     int
+	Entry          int
+	MaxLevel       int
+	Nodes          []savedNode
+}
+
+type savedNode struct {
+	ID      string
+	Vector  []float32
+	Level   int
+	Links   [][]int
+	Deleted bool
+}
+
+// Save writes the index to a file. The file is replaced at once, so a reader
+// never sees half an index.
+func (ix *Index) Save(path string) error {
+	ix.mu.RLock()
+	saved := savedIndex{
+		M:              ix.m,
+		EfConstruction: ix.efConstruction,
+		EfSearch:       ix.efSearch,
+		Dimensions:     ix.dimensions,
+		Entry:          ix.entry,
+		MaxLevel:       ix.maxLevel,
+		Nodes:          make([]savedNode, len(ix.nodes)),
+	}
+	for i, n := range ix.nodes {
+		saved.Nodes[i] = savedNode{
+			ID:      n.id,
+			Vector:  n.vector,
+			Level:   n.level,
+			Links:   n.links,
+			Deleted: n.deleted,
+		}
+	}
+	ix.mu.RUnlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = gob.NewEncoder(tmp).Encode(saved)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to encode index: %w", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load reads an index written by Save.
+func Load(path string) (*Index, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var saved savedIndex
+	err = gob.NewDecoder(file).Decode(&saved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode index %s: %w", path, err)
+	}
+
+	ix := New(saved.M, saved.EfConstruction, saved.EfSearch)
+	ix.dimensions = saved.Dimensions
+	ix.entry = saved.Entry
+	ix.maxLevel = saved.MaxLevel
+	ix.rand = rand.New(rand.NewSource(int64(len(saved.Nodes)) + 1))
+	ix.nodes = make([]*node, len(saved.Nodes))
+	for i, n := range saved.Nodes {
+		if len(n.Links) != n.Level+1 {
+			return nil, fmt.Errorf("index %s is corrupt: node %q has %d layers of links for level %d", path, n.ID, len(n.Links), n.Level)
+		}
+		for _, links := range n.Links {
+			for _, link := range links {
+				if link < 0 || link >= len(saved.Nodes) {
+					return nil, fmt.Errorf("index %s is corrupt: node %q links to %d", path, n.ID, link)
+				}
+			}
+		}
+		ix.nodes[i] = &node{
+			id:      n.ID,
+			vector:  n.Vector,
+			level:   n.Level,
+			links:   n.Links,
+			deleted: n.Deleted,
+		}
+		if n.Deleted {
+			ix.deleted++
+		} else {
+			ix.ids[n.ID] = i
+		}
+	}
+	if ix.entry >= len(ix.nodes) {
+		return nil, fmt.Errorf("index %s is corrupt: entry point %d out of range", path, ix.entry)
+	}
+	return ix, nil
+}
+
+// Compact rebuilds the index without its tombstones.
+func (ix *Index) Compact() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	rebuilt := New(ix.m, ix.efConstruction, ix.efSearch)
+	for _, n := range ix.nodes {
+		if n.deleted {
+			continue
+		}
+		err := rebuilt.Add(n.id, n.vector)
+		if err != nil {
+			return err
+		}
+	}
+	ix.dimensions = rebuilt.dimensions
+	ix.nodes = rebuilt.nodes
+	ix.ids = rebuilt.ids
+	ix.entry = rebuilt.entry
+	ix.maxLevel = rebuilt.maxLevel
+	ix.deleted = 0
+	ix.rand = rebuilt.rand
+	return nil
+}
@@ -0,0 +1,119 @@
+package hnsw
+
+import (
+	"encoding/gob"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	vectors := randomVectors(r, 300, 16)
+	ix := buildIndex(t, vectors)
+	ix.Remove("7")
+	path := filepath.Join(t.TempDir(), "plots.hnsw")
+
+	err := ix.Save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(loaded.IDs(), ix.IDs()) || loaded.Tombstones() != 1 {
+		t.Errorf("loaded %d IDs and %d tombstones, want %d and 1", loaded.Len(), loaded.Tombstones(), ix.Len())
+	}
+	for _, query := range randomVectors(r, 10, 16) {
+		want, err := ix.Search(query, 5, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := loaded.Search(query, 5, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Search after Load = %+v, want %+v", got, want)
+		}
+	}
+	// The loaded index can still grow.
+	err = loaded.Add("new", vectors[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Save left %d files, want only the index", len(entries))
+	}
+}
+
+func TestLoadRejectsCorruptFiles(t *testing.T) {
+	dir := t.TempDir()
+	ix := buildIndex(t, randomVectors(rand.New(rand.NewSource(5)), 20, 4))
+	valid := filepath.Join(dir, "valid.hnsw")
+	err := ix.Save(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(saved savedIndex) []byte {
+		path := filepath.Join(dir, "encoded")
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = gob.NewEncoder(file).Encode(saved)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	node := savedNode{ID: "a", Vector: []float32{1, 0}, Level: 0, Links: [][]int{{}}}
+	badLink := node
+	badLink.Links = [][]int{{3}}
+	badLevel := node
+	badLevel.Level = 1
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"not an index", []byte("not an index"), "failed to decode"},
+		{"truncated", data[:len(data)/2], "failed to decode"},
+		{"link out of range", encode(savedIndex{M: 16, Dimensions: 2, Nodes: []savedNode{badLink}}), "links to 3"},
+		{"links for another level", encode(savedIndex{M: 16, Dimensions: 2, Nodes: []savedNode{badLevel}}), "layers of links"},
+		{"entry out of range", encode(savedIndex{M: 16, Dimensions: 2, Entry: 1, Nodes: []savedNode{node}}), "entry point"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "corrupt.hnsw")
+			err := os.WriteFile(path, tt.data, 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = Load(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
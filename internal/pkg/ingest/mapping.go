@@ -0,0 +1,259 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+)
+
+// Mapping describes how the rows of CSV or JSONL files become nodes and
+// relationships, e.g.
+//
+//	{"sources": [{
+//		"file": "movies.csv",
+//		"nodes": [{"label": "Movie", "key": "movieId",
+//			"properties": {"movieId": "movie_id", "title": "title", "year": "year:int"}}],
+//		"relationships": [{"type": "IN_GENRE",
+//			"from": {"label": "Movie", "key": "movieId", "column": "movie_id"},
+//			"to": {"label": "Genre", "key": "name", "column": "genres", "split": "|"}}]
+//	}]}
+type Mapping struct {
+	Sources []Source `json:"sources"`
+}
+
+// Source maps the rows of one file. The format is csv or jsonl, and defaults to
+// the extension of the file. A CSV file must have a header row.
+type Source struct {
+	File          string                `json:"file"`
+	Format        string                `json:"format,omitempty"`
+	Nodes         []NodeMapping         `json:"nodes"`
+	Relationships []RelationshipMapping `json:"relationships"`
+}
+
+// NodeMapping turns every row into a node with the label, merged on the key
+// property. Properties map property names to columns, optionally followed by a
+// type: "column:int". Types are string (the default), int, float, bool and
+// list, which splits the value on "|".
+type NodeMapping struct {
+	Label      string            `json:"label"`
+	Key        string            `json:"key"`
+	Properties map[string]string `json:"properties"`
+}
+
+// RelationshipMapping turns every row into a relationship between the nodes
+// its endpoints refer to. Properties are mapped like those of nodes.
+type RelationshipMapping struct {
+	Type       string            `json:"type"`
+	From       Endpoint          `json:"from"`
+	To         Endpoint          `json:"to"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// Endpoint refers to the node with the label whose key property has the value
+// of the column. With Split the column holds several values, and the row gets
+// a relationship for each of them. Type converts the values like the type of a
+// property, so they match keys that aren't strings; LoadMapping defaults it to
+// the type of the key in the node mapping of the label, or string.
+type Endpoint struct {
+	Label  string `json:"label"`
+	Key    string `json:"key"`
+	Column string `json:"column"`
+	Split  string `json:"split,omitempty"`
+	Type   string `json:"type,omitempty"`
+}
+
+// LoadMapping reads a mapping from a JSON file and validates it. Files are
+// relative to the directory of the mapping.
+func LoadMapping(path string) (Mapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Mapping{}, err
+	}
+	var m Mapping
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return Mapping{}, fmt.Errorf("failed to decode mapping %s: %w", path, err)
+	}
+	for i := range m.Sources {
+		if !filepath.IsAbs(m.Sources[i].File) {
+			m.Sources[i].File = filepath.Join(filepath.Dir(path), m.Sources[i].File)
+		}
+	}
+	err = m.Validate()
+	if err != nil {
+		return Mapping{}, fmt.Errorf("invalid mapping %s: %w", path, err)
+	}
+	for i := range m.Sources {
+		for j := range m.Sources[i].Relationships {
+			rel := &m.Sources[i].Relationships[j]
+			for _, endpoint := range []*Endpoint{&rel.From, &rel.To} {
+				if endpoint.Type == "" {
+					endpoint.Type, _ = m.keyType(endpoint.Label, endpoint.Key)
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+// Validate checks that labels, keys, relationship types and property names
+// can be used in a query, that property types are known, and that endpoints
+// convert their values to the type of the key they refer to.
+func (m Mapping) Validate() error {
+	if len(m.Sources) == 0 {
+		return fmt.Errorf("no sources")
+	}
+	for _, source := range m.Sources {
+		if source.File == "" {
+			return fmt.Errorf("source without a file")
+		}
+		if _, err := source.format(); err != nil {
+			return err
+		}
+		if len(source.Nodes) == 0 && len(source.Relationships) == 0 {
+			return fmt.Errorf("source %s maps no nodes or relationships", source.File)
+		}
+		for _, node := range source.Nodes {
+			if !knowledgegraph.ValidIdentifier(node.Label) || !knowledgegraph.ValidIdentifier(node.Key) {
+				return fmt.Errorf("source %s: invalid label %q or key %q", source.File, node.Label, node.Key)
+			}
+			if _, ok := node.Properties[node.Key]; !ok {
+				return fmt.Errorf("source %s: key %s of %s is not one of its properties", source.File, node.Key, node.Label)
+			}
+			err := validateProperties(node.Properties)
+			if err != nil {
+				return fmt.Errorf("source %s: %s: %w", source.File, node.Label, err)
+			}
+		}
+		for _, rel := range source.Relationships {
+			if !knowledgegraph.ValidIdentifier(rel.Type) {
+				return fmt.Errorf("source %s: invalid relationship type %q", source.File, rel.Type)
+			}
+			for _, endpoint := range []Endpoint{rel.From, rel.To} {
+				if !knowledgegraph.ValidIdentifier(endpoint.Label) || !knowledgegraph.ValidIdentifier(endpoint.Key) || endpoint.Column == "" {
+					return fmt.Errorf("source %s: %s needs a valid label, key and column for both endpoints", source.File, rel.Type)
+				}
+				err := m.validateEndpointType(endpoint)
+				if err != nil {
+					return fmt.Errorf("source %s: %s: %w", source.File, rel.Type, err)
+				}
+			}
+			err := validateProperties(rel.Properties)
+			if err != nil {
+				return fmt.Errorf("source %s: %s: %w", source.File, rel.Type, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Keys returns every label and key property nodes are merged on, so they can
+// get a uniqueness constraint before loading.
+func (m Mapping) Keys() [][2]string {
+	var keys [][2]string
+	seen := map[[2]string]bool{}
+	add := func(label, key string) {
+		k := [2]string{label, key}
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	for _, source := range m.Sources {
+		for _, node := range source.Nodes {
+			add(node.Label, node.Key)
+		}
+		for _, rel := range source.Relationships {
+			add(rel.From.Label, rel.From.Key)
+			add(rel.To.Label, rel.To.Key)
+		}
+	}
+	return keys
+}
+
+// keyType returns the type of the key property of the nodes with the label, as
+// the node mappings declare it, or string when no source maps them.
+func (m Mapping) keyType(label, key string) (string, error) {
+	typ := ""
+	for _, source := range m.Sources {
+		for _, node := range source.Nodes {
+			if node.Label != label || node.Key != key {
+				continue
+			}
+			_, declared := splitSpec(node.Properties[key])
+			if typ != "" && declared != typ {
+				return "", fmt.Errorf("key %s of %s is mapped as both %s and %s", key, label, typ, declared)
+			}
+			typ = declared
+		}
+	}
+	if typ == "" {
+		return "string", nil
+	}
+	return typ, nil
+}
+
+func (m Mapping) validateEndpointType(endpoint Endpoint) error {
+	typ, err := m.keyType(endpoint.Label, endpoint.Key)
+	if err != nil {
+		return err
+	}
+	if typ == "list" {
+		return fmt.Errorf("key %s of %s is a list, which can't refer to a node", endpoint.Key, endpoint.Label)
+	}
+	if endpoint.Type != "" && endpoint.Type != typ {
+		return fmt.Errorf("endpoint %s.%s has type %s, but the key is mapped as %s", endpoint.Label, endpoint.Key, endpoint.Type, typ)
+	}
+	return nil
+}
+
+func (s Source) format() (string, error) {
+	format := s.Format
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(s.File)), ".")
+	}
+	switch format {
+	case "csv", "jsonl":
+		return format, nil
+	case "ndjson":
+		return "jsonl", nil
+	}
+	return "", fmt.Errorf("source %s has unknown format %q, use csv or jsonl", s.File, format)
+}
+
+func validateProperties(properties map[string]string) error {
+	for name, spec := range properties {
+		if !knowledgegraph.ValidIdentifier(name) {
+			return fmt.Errorf("invalid property name %q", name)
+		}
+		column, typ := splitSpec(spec)
+		if column == "" {
+			return fmt.Errorf("property %s has no column", name)
+		}
+		if !knownType(typ) {
+			return fmt.Errorf("property %s has unknown type %q", name, typ)
+		}
+	}
+	return nil
+}
+
+// splitSpec splits a property spec like "year:int" into its column and type.
+func splitSpec(spec string) (column, typ string) {
+	i := strings.LastIndex(spec, ":")
+	if i < 0 {
+		return spec, "string"
+	}
+	return spec[:i], spec[i+1:]
+}
+
+func knownType(typ string) bool {
+	switch typ {
+	case "string", "int", "float", "bool", "list":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package ingest
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadMappingEndpointTypes(t *testing.T) {
+	path := writeFile(t, "mapping.json", `{"sources": [
+		{"file": "movies.csv",
+		 "nodes": [{"label": "Movie", "key": "movieId", "properties": {"movieId": "movie_id:int", "title": "title"}}],
+		 "relationships": [{"type": "IN_GENRE",
+			"from": {"label": "Movie", "key": "movieId", "column": "movie_id"},
+			"to": {"label": "Genre", "key": "name", "column": "genres", "split": "|"}}]},
+		{"file": "people.jsonl",
+		 "relationships": [{"type": "DIRECTED",
+			"from": {"label": "Person", "key": "tmdbId", "column": "tmdb_id"},
+			"to": {"label": "Movie", "key": "movieId", "column": "movie_id"}}]}
+	]}`)
+
+	m, err := LoadMapping(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(filepath.Dir(path), "movies.csv"); m.Sources[0].File != want {
+		t.Errorf("file = %s, want %s", m.Sources[0].File, want)
+	}
+	types := map[string]string{}
+	for _, source := range m.Sources {
+		for _, rel := range source.Relationships {
+			types[rel.Type+" from"] = rel.From.Type
+			types[rel.Type+" to"] = rel.To.Type
+		}
+	}
+	want := map[string]string{
+		"IN_GENRE from": "int",
+		"IN_GENRE to":   "string",
+		"DIRECTED from": "string",
+		"DIRECTED to":   "int",
+	}
+	for endpoint, typ := range want {
+		if types[endpoint] != typ {
+			t.Errorf("%s type = %q, want %q", endpoint, types[endpoint], typ)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	movies := NodeMapping{Label: "Movie", Key: "movieId", Properties: map[string]string{"movieId": "movie_id:int"}}
+	tests := []struct {
+		name    string
+		source  Source
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			source: Source{File: "m.csv", Nodes: []NodeMapping{movies}},
+		},
+		{
+			name:    "unknown format",
+			source:  Source{File: "m.xlsx", Nodes: []NodeMapping{movies}},
+			wantErr: "unknown format",
+		},
+		{
+			name:    "key not a property",
+			source:  Source{File: "m.csv", Nodes: []NodeMapping{{Label: "Movie", Key: "movieId", Properties: map[string]string{"title": "title"}}}},
+			wantErr: "is not one of its properties",
+		},
+		{
+			name:    "unknown type",
+			source:  Source{File: "m.csv", Nodes: []NodeMapping{{Label: "Movie", Key: "movieId", Properties: map[string]string{"movieId": "movie_id:date"}}}},
+			wantErr: "unknown type",
+		},
+		{
+			name:    "label not an identifier",
+			source:  Source{File: "m.csv", Nodes: []NodeMapping{{Label: "Movie) DETACH DELETE (n", Key: "movieId", Properties: map[string]string{"movieId": "movie_id"}}}},
+			wantErr: "invalid label",
+		},
+		{
+			name: "endpoint type differs from the key",
+			source: Source{File: "m.csv", Nodes: []NodeMapping{movies}, Relationships: []RelationshipMapping{{
+				Type: "RATED",
+				From: Endpoint{Label: "User", Key: "userId", Column: "user_id"},
+				To:   Endpoint{Label: "Movie", Key: "movieId", Column: "movie_id", Type: "float"},
+			}}},
+			wantErr: "but the key is mapped as int",
+		},
+		{
+			name: "list key",
+			source: Source{File: "m.csv", Nodes: []NodeMapping{{Label: "Tag", Key: "names", Properties: map[string]string{"names": "tags:list"}}}, Relationships: []RelationshipMapping{{
+				Type: "TAGGED",
+				From: Endpoint{Label: "Tag", Key: "names", Column: "tags"},
+				To:   Endpoint{Label: "Movie", Key: "movieId", Column: "movie_id"},
+			}}},
+			wantErr: "is a list",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Mapping{Sources: []Source{tt.source}}.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate = %v, want an error with %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	conflicting := Mapping{Sources: []Source{
+		{File: "a.csv", Nodes: []NodeMapping{movies}},
+		{File: "b.csv", Nodes: []NodeMapping{{Label: "Movie", Key: "movieId", Properties: map[string]string{"movieId": "id"}}}, Relationships: []RelationshipMapping{{
+			Type: "IN_GENRE",
+			From: Endpoint{Label: "Movie", Key: "movieId", Column: "id"},
+			To:   Endpoint{Label: "Genre", Key: "name", Column: "genre"},
+		}}},
+	}}
+	err := conflicting.Validate()
+	if err == nil || !strings.Contains(err.Error(), "mapped as both") {
+		t.Errorf("Validate of conflicting key types = %v, want an error", err)
+	}
+}
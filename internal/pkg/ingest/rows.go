@@ -0,0 +1,283 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+)
+
+// listSeparator separates the values of a list property in a single column.
+const listSeparator = "|"
+
+// Row is a row of a source file with its line number, for error messages.
+type Row struct {
+	Line   int
+	Values map[string]any
+}
+
+// ReadRows calls fn with every row of the source file, in batches of at most
+// batchSize rows. CSV values are strings, JSONL values keep their JSON type.
+func (s Source) ReadRows(batchSize int, fn func([]Row) error) error {
+	format, err := s.format()
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(s.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var batch []Row
+	emit := func(row Row) error {
+		batch = append(batch, row)
+		if len(batch) < batchSize {
+			return nil
+		}
+		err := fn(batch)
+		batch = nil
+		return err
+	}
+
+	if format == "csv" {
+		err = readCSV(file, emit)
+	} else {
+		err = readJSONL(file, emit)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", s.File, err)
+	}
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
+
+func readCSV(r io.Reader, emit func(Row) error) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := reader.FieldPos(0)
+		values := map[string]any{}
+		for i, column := range header {
+			if i < len(record) {
+				values[column] = record[i]
+			}
+		}
+		err = emit(Row{Line: line, Values: values})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func readJSONL(r io.Reader, emit func(Row) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		// UseNumber keeps numbers as written, so ids like 1e6 aren't
+		// formatted as floats when they are read as strings.
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var values map[string]any
+		err := decoder.Decode(&values)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		err = emit(Row{Line: line, Values: values})
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Batches maps rows to the node and relationship batches of the source. Empty
+// values are left out: a node without a key value or a relationship without
+// both endpoints is skipped. Rows with a value that can't be converted to its
+// type are skipped and returned as errors.
+func (s Source) Batches(rows []Row) ([]knowledgegraph.NodeBatch, []knowledgegraph.RelationshipBatch, []error) {
+	nodes := make([]knowledgegraph.NodeBatch, len(s.Nodes))
+	for i, mapping := range s.Nodes {
+		nodes[i] = knowledgegraph.NodeBatch{Label: mapping.Label, Key: mapping.Key}
+	}
+	relationships := make([]knowledgegraph.RelationshipBatch, len(s.Relationships))
+	for i, mapping := range s.Relationships {
+		relationships[i] = knowledgegraph.RelationshipBatch{
+			Type:      mapping.Type,
+			FromLabel: mapping.From.Label,
+			FromKey:   mapping.From.Key,
+			ToLabel:   mapping.To.Label,
+			ToKey:     mapping.To.Key,
+		}
+	}
+
+	var errs []error
+	for _, row := range rows {
+		// Convert the whole row first, so a bad value skips all of it.
+		nodeProperties := make([]map[string]any, len(s.Nodes))
+		relProperties := make([]map[string]any, len(s.Relationships))
+		fromKeys := make([][]any, len(s.Relationships))
+		toKeys := make([][]any, len(s.Relationships))
+		var err error
+		for i, mapping := range s.Nodes {
+			nodeProperties[i], err = convertProperties(row, mapping.Properties)
+			if err != nil {
+				err = fmt.Errorf("%s: %w", mapping.Label, err)
+				break
+			}
+		}
+		for i := 0; err == nil && i < len(s.Relationships); i++ {
+			mapping := s.Relationships[i]
+			relProperties[i], err = convertProperties(row, mapping.Properties)
+			if err == nil {
+				fromKeys[i], err = endpointValues(row, mapping.From)
+			}
+			if err == nil {
+				toKeys[i], err = endpointValues(row, mapping.To)
+			}
+			if err != nil {
+				err = fmt.Errorf("%s: %w", mapping.Type, err)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s line %d: %w", s.File, row.Line, err))
+			continue
+		}
+
+		for i, mapping := range s.Nodes {
+			if nodeProperties[i][mapping.Key] != nil {
+				nodes[i].Rows = append(nodes[i].Rows, nodeProperties[i])
+			}
+		}
+		for i := range s.Relationships {
+			for _, from := range fromKeys[i] {
+				for _, to := range toKeys[i] {
+					relationships[i].Rows = append(relationships[i].Rows, map[string]any{
+						"from":       from,
+						"to":         to,
+						"properties": relProperties[i],
+					})
+				}
+			}
+		}
+	}
+
+	return nodes, relationships, errs
+}
+
+func convertProperties(row Row, specs map[string]string) (map[string]any, error) {
+	properties := map[string]any{}
+	for name, spec := range specs {
+		column, typ := splitSpec(spec)
+		value, err := convert(row.Values[column], typ)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", column, err)
+		}
+		if value != nil {
+			properties[name] = value
+		}
+	}
+	return properties, nil
+}
+
+// endpointValues returns the key values of an endpoint in the row, converted to
+// the type of the endpoint, so they match the keys of the nodes.
+func endpointValues(row Row, endpoint Endpoint) ([]any, error) {
+	value := row.Values[endpoint.Column]
+	var values []string
+	if list, ok := value.([]any); ok {
+		for _, v := range list {
+			values = append(values, toString(v))
+		}
+	} else if s := toString(value); endpoint.Split != "" {
+		values = strings.Split(s, endpoint.Split)
+	} else {
+		values = []string{s}
+	}
+
+	typ := endpoint.Type
+	if typ == "" {
+		typ = "string"
+	}
+	var keys []any
+	for _, v := range values {
+		key, err := convert(v, typ)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", endpoint.Column, err)
+		}
+		if key != nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// convert converts a value to the type; nil and empty strings are nil.
+func convert(value any, typ string) (any, error) {
+	if list, ok := value.([]any); ok && typ == "list" {
+		var values []string
+		for _, v := range list {
+			values = append(values, toString(v))
+		}
+		return values, nil
+	}
+
+	s := strings.TrimSpace(toString(value))
+	if s == "" {
+		return nil, nil
+	}
+	switch typ {
+	case "int":
+		return strconv.ParseInt(s, 10, 64)
+	case "float":
+		return strconv.ParseFloat(s, 64)
+	case "bool":
+		return strconv.ParseBool(s)
+	case "list":
+		values := strings.Split(s, listSeparator)
+		for i := range values {
+			values[i] = strings.TrimSpace(values[i])
+		}
+		return values, nil
+	}
+	return s, nil
+}
+
+func toString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
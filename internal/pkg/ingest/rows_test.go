@@ -0,0 +1,195 @@
+package ingest
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readAll(t *testing.T, source Source, batchSize int) [][]Row {
+	t.Helper()
+	var batches [][]Row
+	err := source.ReadRows(batchSize, func(rows []Row) error {
+		batches = append(batches, rows)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return batches
+}
+
+func TestReadRowsCSV(t *testing.T) {
+	path := writeFile(t, "movies.csv", "movie_id,title,genres\n1,Toy Story,Animation|Comedy\n2,\"Jumanji, the game\",Adventure\n3,Heat\n")
+	batches := readAll(t, Source{File: path}, 2)
+
+	want := [][]Row{
+		{
+			{Line: 2, Values: map[string]any{"movie_id": "1", "title": "Toy Story", "genres": "Animation|Comedy"}},
+			{Line: 3, Values: map[string]any{"movie_id": "2", "title": "Jumanji, the game", "genres": "Adventure"}},
+		},
+		{
+			{Line: 4, Values: map[string]any{"movie_id": "3", "title": "Heat"}},
+		},
+	}
+	if !reflect.DeepEqual(batches, want) {
+		t.Errorf("ReadRows = %+v, want %+v", batches, want)
+	}
+}
+
+func TestReadRowsJSONL(t *testing.T) {
+	path := writeFile(t, "movies.data", "{\"movie_id\": 1e6, \"title\": \"Toy Story\", \"genres\": [\"Animation\", \"Comedy\"]}\n\n{\"movie_id\": 2, \"title\": null}\n")
+	batches := readAll(t, Source{File: path, Format: "ndjson"}, 10)
+
+	want := [][]Row{{
+		{Line: 1, Values: map[string]any{"movie_id": json.Number("1e6"), "title": "Toy Story", "genres": []any{"Animation", "Comedy"}}},
+		{Line: 3, Values: map[string]any{"movie_id": json.Number("2"), "title": nil}},
+	}}
+	if !reflect.DeepEqual(batches, want) {
+		t.Errorf("ReadRows = %+v, want %+v", batches, want)
+	}
+
+	bad := writeFile(t, "bad.jsonl", "{\"movie_id\": 1}\n{\"movie_id\": \n")
+	err := Source{File: bad}.ReadRows(10, func([]Row) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("ReadRows of a bad line = %v, want an error on line 2", err)
+	}
+	_, err = Source{File: "movies.parquet"}.format()
+	if err == nil {
+		t.Error("format of a .parquet file succeeded")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		value   any
+		typ     string
+		want    any
+		wantErr bool
+	}{
+		{"Toy Story", "string", "Toy Story", false},
+		{json.Number("1e6"), "string", "1e6", false},
+		{" 1995 ", "int", int64(1995), false},
+		{json.Number("1995"), "int", int64(1995), false},
+		{"8.3", "float", 8.3, false},
+		{"true", "bool", true, false},
+		{"Animation | Comedy", "list", []string{"Animation", "Comedy"}, false},
+		{[]any{"Animation", json.Number("3")}, "list", []string{"Animation", "3"}, false},
+		{"", "int", nil, false},
+		{"  ", "string", nil, false},
+		{nil, "float", nil, false},
+		{"1995.5", "int", nil, true},
+		{"eight", "float", nil, true},
+		{"maybe", "bool", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := convert(tt.value, tt.typ)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("convert(%#v, %s) error = %v, want error %v", tt.value, tt.typ, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("convert(%#v, %s) = %#v, want %#v", tt.value, tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestEndpointValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   map[string]any
+		endpoint Endpoint
+		want     []any
+		wantErr  bool
+	}{
+		{"string by default", map[string]any{"id": "1"}, Endpoint{Column: "id"}, []any{"1"}, false},
+		{"int key", map[string]any{"id": "1"}, Endpoint{Column: "id", Type: "int"}, []any{int64(1)}, false},
+		{"JSON number to int", map[string]any{"id": json.Number("1")}, Endpoint{Column: "id", Type: "int"}, []any{int64(1)}, false},
+		{"JSON number to string", map[string]any{"id": json.Number("1")}, Endpoint{Column: "id", Type: "string"}, []any{"1"}, false},
+		{"split", map[string]any{"genres": "Animation|Comedy"}, Endpoint{Column: "genres", Split: "|"}, []any{"Animation", "Comedy"}, false},
+		{"split ints", map[string]any{"ids": "1;;2"}, Endpoint{Column: "ids", Split: ";", Type: "int"}, []any{int64(1), int64(2)}, false},
+		{"JSON list", map[string]any{"ids": []any{json.Number("1"), json.Number("2")}}, Endpoint{Column: "ids", Type: "int"}, []any{int64(1), int64(2)}, false},
+		{"missing", map[string]any{}, Endpoint{Column: "id"}, nil, false},
+		{"bad int", map[string]any{"id": "one"}, Endpoint{Column: "id", Type: "int"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := endpointValues(Row{Values: tt.values}, tt.endpoint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("endpointValues error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("endpointValues = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatches(t *testing.T) {
+	source := Source{
+		File: "movies.csv",
+		Nodes: []NodeMapping{{
+			Label:      "Movie",
+			Key:        "movieId",
+			Properties: map[string]string{"movieId": "movie_id:int", "title": "title", "year": "year:int", "genres": "genres:list"},
+		}},
+		Relationships: []RelationshipMapping{{
+			Type:       "IN_GENRE",
+			From:       Endpoint{Label: "Movie", Key: "movieId", Column: "movie_id", Type: "int"},
+			To:         Endpoint{Label: "Genre", Key: "name", Column: "genres", Split: "|", Type: "string"},
+			Properties: map[string]string{"source": "source"},
+		}},
+	}
+	rows := []Row{
+		{Line: 2, Values: map[string]any{"movie_id": "1", "title": "Toy Story", "year": "1995", "genres": "Animation|Comedy", "source": "ml"}},
+		{Line: 3, Values: map[string]any{"movie_id": "2", "title": "Jumanji", "year": "nineteen", "genres": "Adventure"}},
+		{Line: 4, Values: map[string]any{"movie_id": "", "title": "No ID", "genres": "Drama"}},
+		{Line: 5, Values: map[string]any{"movie_id": "6", "title": "Heat"}},
+	}
+
+	nodes, relationships, errs := source.Batches(rows)
+
+	wantNodes := []knowledgegraph.NodeBatch{{
+		Label: "Movie",
+		Key:   "movieId",
+		Rows: []map[string]any{
+			{"movieId": int64(1), "title": "Toy Story", "year": int64(1995), "genres": []string{"Animation", "Comedy"}},
+			{"movieId": int64(6), "title": "Heat"},
+		},
+	}}
+	if !reflect.DeepEqual(nodes, wantNodes) {
+		t.Errorf("nodes = %+v, want %+v", nodes, wantNodes)
+	}
+	wantRelationships := []knowledgegraph.RelationshipBatch{{
+		Type:      "IN_GENRE",
+		FromLabel: "Movie",
+		FromKey:   "movieId",
+		ToLabel:   "Genre",
+		ToKey:     "name",
+		Rows: []map[string]any{
+			{"from": int64(1), "to": "Animation", "properties": map[string]any{"source": "ml"}},
+			{"from": int64(1), "to": "Comedy", "properties": map[string]any{"source": "ml"}},
+		},
+	}}
+	if !reflect.DeepEqual(relationships, wantRelationships) {
+		t.Errorf("relationships = %+v, want %+v", relationships, wantRelationships)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "movies.csv line 3: Movie: column year") {
+		t.Errorf("errors = %v, want one for the year on line 3", errs)
+	}
+}
@@ -0,0 +1,105 @@
+package knowledgegraph
+
+import (
+	"context"
+	"fmt"
+)
+
+// chunkIndex is the vector index on the embeddings of Chunk nodes.
+const chunkIndex = "chunkEmbeddings"
+
+// ChunkMatch is a chunk similar to a query, with the entities it mentions.
+type ChunkMatch struct {
+	Chunk    TextChunk `json:"chunk"`
+	Score    float64   `json:"score"`
+	Entities []Entity  `json:"entities"`
+}
+
+// EnsureChunkIndex creates the vector index on the chunk embeddings, for
+// embeddings with the given number of dimensions. It is safe to call more
+// than once.
+func (g *knowledgeGraph) EnsureChunkIndex(ctx context.Context, dimensions int) error {
+	query := fmt.Sprintf(
+		"CREATE VECTOR INDEX %s IF NOT EXISTS FOR (c:Chunk) ON (c.embedding) "+
+			"OPTIONS {indexConfig: {`vector.dimensions`: %d, `vector.similarity_function`: 'cosine'}}",
+		chunkIndex, dimensions)
+	result, err := g.session.Run(ctx, query, nil)
+	if err != nil {
+		return err
+	}
+	_, err = result.Consume(ctx)
+	return err
+}
+
+// SearchSimilarChunks returns the k chunks whose embedding is closest to the
+// given embedding, and walks from each chunk to its document and the entities
+// it mentions.
+func (g *knowledgeGraph) SearchSimilarChunks(ctx context.Context, embedding []float32, k int) ([]ChunkMatch, error) {
+	query := `
+	CALL db.index.vector.queryNodes($index, $k, $embedding)
+	YIELD node, score
+	OPTIONAL MATCH (node)-[:PART_OF]->(d:Document)
+	RETURN node.chunkId AS chunkId, node.text AS text, node.index AS index, node.heading AS heading,
+		d.documentId AS documentId, score,
+		COLLECT {
+			MATCH (node)-[:MENTIONS]->(e:Entity)
+			RETURN {name: e.name, type: e.type, description: e.description}
+		} AS entities
+	ORDER BY score DESC
+	`
+	result, err := g.session.Run(ctx, query, map[string]any{
+		"index":     chunkIndex,
+		"k":         k,
+		"embedding": embedding,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []ChunkMatch
+	for result.Next(ctx) {
+		record := result.Record()
+
+		chunkId, ok := record.Get("chunkId")
+		if !ok || chunkId == nil {
+			fmt.Println("chunkId not found")
+			continue
+		}
+		match := ChunkMatch{
+			Chunk: TextChunk{
+				ChunkID: chunkId.(string),
+			},
+		}
+		if text, ok := record.Get("text"); ok && text != nil {
+			match.Chunk.Text = text.(string)
+		}
+		if index, ok := record.Get("index"); ok && index != nil {
+			match.Chunk.Index = int(toFloat64(index))
+		}
+		if heading, ok := record.Get("heading"); ok && heading != nil {
+			match.Chunk.Heading = heading.(string)
+		}
+		if documentId, ok := record.Get("documentId"); ok && documentId != nil {
+			match.Chunk.DocumentID = documentId.(string)
+		}
+		if score, ok := record.Get("score"); ok && score != nil {
+			match.Score = toFloat64(score)
+		}
+		if entities, ok := record.Get("entities"); ok && entities != nil {
+			for _, e := range entities.([]any) {
+				props, ok := e.(map[string]any)
+				if !ok {
+					continue
+				}
+				entity := Entity{}
+				entity.Name, _ = props["name"].(string)
+				entity.Type, _ = props["type"].(string)
+				entity.Description, _ = props["description"].(string)
+				match.Entities = append(match.Entities, entity)
+			}
+		}
+		matches = append(matches, match)
+	}
+
+	return matches, result.Err()
+}
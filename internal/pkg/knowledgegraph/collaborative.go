@@ -0,0 +1,102 @@
+package knowledgegraph
+
+import (
+	"context"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// collaborativeMinRating is the rating from which a RATED edge counts as the
+// user liking the movie.
+const collaborativeMinRating = 4.0
+
+// collaborativeNeighbours is the number of most similar users whose ratings
+// are used for user-based candidates.
+const collaborativeNeighbours = 50
+
+// collaborativeShrinkage dampens the similarity of users that co-rated only a
+// few movies, which would otherwise look perfectly similar.
+const collaborativeShrinkage = 10.0
+
+// UserBasedCandidates recommends up to k movies the user has not rated, liked
+// by the users whose ratings are most similar. The similarity of two users is
+// the cosine of their ratings on the movies both rated, shrunk towards 0 when
+// they co-rated few movies. A candidate scores the similarity-weighted sum of
+// the neighbours that liked it. The score is stored in CollaborativeScore.
+func (g *knowledgeGraph) UserBasedCandidates(ctx context.Context, userID string, k int) ([]Movie, error) {
+	query := `
+	MATCH (u:User {userId: $userId})-[r1:RATED]->(:Movie)<-[r2:RATED]-(other:User)
+	WITH u, other, count(*) AS coRated,
+		sum(r1.rating * r2.rating) AS dot,
+		sqrt(sum(r1.rating ^ 2)) AS norm1,
+		sqrt(sum(r2.rating ^ 2)) AS norm2
+	WITH u, other, dot / (norm1 * norm2) * coRated / (coRated + $shrinkage) AS similarity
+	ORDER BY similarity DESC
+	LIMIT $neighbours
+
+	MATCH (other)-[r:RATED]->(m:Movie)
+	WHERE r.rating >= $minRating AND NOT EXISTS { (u)-[:RATED]->(m) }
+	WITH m, sum(similarity * r.rating) AS score
+	ORDER BY score DESC
+	LIMIT $k
+	RETURN m.movieId AS movieId, m.title AS title, m.plot AS plot, m.embedding AS embedding, score
+	`
+	result, err := g.session.Run(ctx, query, map[string]any{
+		"userId":     userID,
+		"shrinkage":  collaborativeShrinkage,
+		"neighbours": collaborativeNeighbours,
+		"minRating":  collaborativeMinRating,
+		"k":          k,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return collectCollaborative(ctx, result)
+}
+
+// ItemBasedCandidates recommends up to k movies that are liked by the same
+// users as the seed movies. The similarity of a seed and a candidate is the
+// number of users that liked both, divided by the geometric mean of the number
+// of users that liked each, so popular movies don't win by default. A
+// candidate scores the sum of its similarities to the seeds. The score is
+// stored in CollaborativeScore.
+func (g *knowledgeGraph) ItemBasedCandidates(ctx context.Context, movieIDs []string, k int) ([]Movie, error) {
+	query := `
+	MATCH (seed:Movie)<-[r1:RATED]-(:User)-[r2:RATED]->(m:Movie)
+	WHERE seed.movieId IN $movieIds
+		AND NOT m.movieId IN $movieIds
+		AND r1.rating >= $minRating
+		AND r2.rating >= $minRating
+	WITH seed, m, count(*) AS coLiked
+	WITH m, coLiked,
+		COUNT { (seed)<-[r:RATED]-(:User) WHERE r.rating >= $minRating } AS seedLikes,
+		COUNT { (m)<-[r:RATED]-(:User) WHERE r.rating >= $minRating } AS likes
+	WITH m, sum(coLiked / sqrt(toFloat(seedLikes * likes))) AS score
+	ORDER BY score DESC
+	LIMIT $k
+	RETURN m.movieId AS movieId, m.title AS title, m.plot AS plot, m.embedding AS embedding, score
+	`
+	result, err := g.session.Run(ctx, query, map[string]any{
+		"movieIds":  movieIDs,
+		"minRating": collaborativeMinRating,
+		"k":         k,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return collectCollaborative(ctx, result)
+}
+
+// collectCollaborative reads the candidates of a collaborative-filtering
+// query, moving the score to CollaborativeScore.
+func collectCollaborative(ctx context.Context, result neo4j.ResultWithContext) ([]Movie, error) {
+	movies, err := collectMovies(ctx, result)
+	if err != nil {
+		return nil, err
+	}
+	for i := range movies {
+		movies[i].CollaborativeScore = movies[i].SimilarityScore
+		movies[i].SimilarityScore = 0
+	}
+	return movies, nil
+}
@@ -0,0 +1,230 @@
+package knowledgegraph
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// explainMaxHops is the maximum length of the shortest path searched between
+// a seed movie and a recommendation without a shared genre or person.
+const explainMaxHops = 4
+
+// ExplainFromMovie finds up to maxPaths short paths over ACTED_IN, DIRECTED
+// and IN_GENRE between the seed movie and each of the movies, and renders them
+// as sentences about the movie, like "shares director X with Y". Paths through
+// a shared director come first, then shared actors, then shared genres. When
+// the movies share nothing the shortest path is used. The result is keyed by
+// movieId; movies without a path are missing.
+func (g *knowledgeGraph) ExplainFromMovie(ctx context.Context, seedMovieID string, movieIDs []string, maxPaths int) (map[string][]string, error) {
+	query := fmt.Sprintf(`
+	MATCH (seed:Movie {movieId: $seedId})
+	UNWIND $movieIds AS movieId
+	MATCH (m:Movie {movieId: movieId})
+	WHERE m <> seed
+	CALL {
+		WITH seed, m
+		MATCH p = (seed)-[:ACTED_IN|DIRECTED|IN_GENRE*2]-(m)
+		WITH p, %s AS priority
+		ORDER BY priority
+		LIMIT $maxPaths
+		RETURN collect({path: p, priority: priority}) AS shared
+	}
+	CALL {
+		WITH seed, m, shared
+		WITH seed, m WHERE size(shared) = 0
+		MATCH p = shortestPath((seed)-[:ACTED_IN|DIRECTED|IN_GENRE*..%d]-(m))
+		RETURN collect({path: p, priority: %d}) AS shortest
+	}
+	RETURN m.movieId AS movieId, shared + shortest AS paths
+	`, pathPriority(0), explainMaxHops, shortestPathPriority)
+
+	result, err := g.session.Run(ctx, query, map[string]any{
+		"seedId":   seedMovieID,
+		"movieIds": movieIDs,
+		"maxPaths": maxPaths,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return collectExplanations(ctx, result, maxPaths)
+}
+
+// ExplainFromUser finds up to maxPaths paths from the user, through a movie
+// they rated at least minRating, to each of the movies, and renders them like
+// ExplainFromMovie does, e.g. "shares actor X with Y, which the user rated
+// 5.0". Paths through higher rated movies come first within a priority. The
+// result is keyed by movieId; movies without a path are missing.
+func (g *knowledgeGraph) ExplainFromUser(ctx context.Context, userID string, movieIDs []string, minRating float64, maxPaths int) (map[string][]string, error) {
+	query := fmt.Sprintf(`
+	MATCH (u:User {userId: $userId})
+	UNWIND $movieIds AS movieId
+	MATCH (m:Movie {movieId: movieId})
+	CALL {
+		WITH u, m
+		MATCH p = (u)-[r:RATED]->(:Movie)-[:ACTED_IN|DIRECTED|IN_GENRE*2]-(m)
+		WHERE r.rating >= $minRating
+		WITH p, %s AS priority, r.rating AS rating
+		ORDER BY priority, rating DESC
+		LIMIT $maxPaths
+		RETURN collect({path: p, priority: priority, rating: rating}) AS paths
+	}
+	RETURN m.movieId AS movieId, paths
+	`, pathPriority(1))
+
+	result, err := g.session.Run(ctx, query, map[string]any{
+		"userId":    userID,
+		"movieIds":  movieIDs,
+		"minRating": minRating,
+		"maxPaths":  maxPaths,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return collectExplanations(ctx, result, maxPaths)
+}
+
+// shortestPathPriority puts the shortest path after the paths through a
+// shared director, actor or genre.
+const shortestPathPriority = 3
+
+// pathPriority orders paths by the type of their relationship at index i:
+// shared directors first, then actors, then genres.
+func pathPriority(i int) string {
+	return fmt.Sprintf(`CASE type(relationships(p)[%d]) WHEN 'DIRECTED' THEN 0 WHEN 'ACTED_IN' THEN 1 ELSE 2 END`, i)
+}
+
+// rankedPath is a path returned by the explain queries, with the priority and
+// the rating of the movie it goes through, if any.
+type rankedPath struct {
+	path     neo4j.Path
+	priority int64
+	rating   float64
+}
+
+// rankPaths decodes the paths of a record and sorts them by priority, then by
+// rating, since collect doesn't keep the order of the query.
+func rankPaths(paths []any) []rankedPath {
+	var ranked []rankedPath
+	for _, p := range paths {
+		m, ok := p.(map[string]any)
+		if !ok {
+			continue
+		}
+		path, ok := m["path"].(neo4j.Path)
+		if !ok {
+			continue
+		}
+		priority, _ := m["priority"].(int64)
+		ranked = append(ranked, rankedPath{path: path, priority: priority, rating: toFloat64(m["rating"])})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].priority != ranked[j].priority {
+			return ranked[i].priority < ranked[j].priority
+		}
+		return ranked[i].rating > ranked[j].rating
+	})
+	return ranked
+}
+
+func collectExplanations(ctx context.Context, result neo4j.ResultWithContext, maxPaths int) (map[string][]string, error) {
+	explanations := map[string][]string{}
+	for result.Next(ctx) {
+		record := result.Record()
+
+		movieId, ok := record.Get("movieId")
+		if !ok {
+			fmt.Println("movieId not found")
+			continue
+		}
+		paths, ok := record.Get("paths")
+		if !ok {
+			fmt.Println("paths not found")
+			continue
+		}
+
+		seen := map[string]bool{}
+		for _, p := range rankPaths(paths.([]any)) {
+			sentence := RenderPath(p.path)
+			if sentence == "" || seen[sentence] {
+				continue
+			}
+			seen[sentence] = true
+			explanations[movieId.(string)] = append(explanations[movieId.(string)], sentence)
+		}
+		if len(explanations[movieId.(string)]) > maxPaths {
+			explanations[movieId.(string)] = explanations[movieId.(string)][:maxPaths]
+		}
+	}
+	return explanations, result.Err()
+}
+
+// RenderPath describes a path that ends in a recommended movie, from the point
+// of view of that movie. The path alternates movies with the people and genres
+// connecting them, and may start with a user rating a movie:
+//
+//	shares director Christopher Nolan with Memento, which the user rated 5.0
+func RenderPath(path neo4j.Path) string {
+	nodes, rels := path.Nodes, path.Relationships
+	if len(rels) == 0 || len(nodes) != len(rels)+1 {
+		return ""
+	}
+
+	var rated string
+	if hasLabel(nodes[0], "User") {
+		rated = fmt.Sprintf(", which the user rated %.1f", toFloat64(rels[0].Props["rating"]))
+		nodes, rels = nodes[1:], rels[1:]
+	}
+
+	// Walk from the recommendation (the end of the path) back to the seed,
+	// one movie-connector-movie step at a time.
+	var clauses []string
+	for i := len(nodes) - 1; i >= 2; i -= 2 {
+		clauses = append(clauses, connection(nodes[i-1], rels[i-1].Type, rels[i-2].Type, nodeName(nodes[i-2])))
+	}
+	if len(clauses) == 0 {
+		return ""
+	}
+	return strings.Join(clauses, ", which ") + rated
+}
+
+// connection describes how a movie relates to the farther movie of a step,
+// through the connecting person or genre, given the relationship types from
+// the connector to the nearer and the farther movie.
+func connection(via neo4j.Node, near, far, farName string) string {
+	name := nodeName(via)
+	switch {
+	case near == "IN_GENRE" && far == "IN_GENRE":
+		return fmt.Sprintf("shares genre %s with %s", name, farName)
+	case near == "DIRECTED" && far == "DIRECTED":
+		return fmt.Sprintf("shares director %s with %s", name, farName)
+	case near == "ACTED_IN" && far == "ACTED_IN":
+		return fmt.Sprintf("shares actor %s with %s", name, farName)
+	case near == "ACTED_IN" && far == "DIRECTED":
+		return fmt.Sprintf("stars %s, who directed %s", name, farName)
+	case near == "DIRECTED" && far == "ACTED_IN":
+		return fmt.Sprintf("is directed by %s, who acted in %s", name, farName)
+	}
+	return fmt.Sprintf("is connected to %s through %s", farName, name)
+}
+
+func nodeName(node neo4j.Node) string {
+	for _, key := range []string{"title", "name"} {
+		if name, ok := node.Props[key].(string); ok {
+			return name
+		}
+	}
+	return strings.Join(node.Labels, ":")
+}
+
+func hasLabel(node neo4j.Node, label string) bool {
+	for _, l := range node.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package knowledgegraph
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+func TestRankPaths(t *testing.T) {
+	path := func(name string) neo4j.Path {
+		return neo4j.Path{Nodes: []neo4j.Node{{Props: map[string]any{"name": name}}}}
+	}
+	paths := []any{
+		map[string]any{"path": path("shortest"), "priority": int64(shortestPathPriority)},
+		map[string]any{"path": path("genre"), "priority": int64(2), "rating": 5.0},
+		map[string]any{"path": path("actor rated 3"), "priority": int64(1), "rating": 3.0},
+		map[string]any{"path": path("director"), "priority": int64(0)},
+		"not a path",
+		map[string]any{"path": path("actor rated 4.5"), "priority": int64(1), "rating": 4.5},
+	}
+
+	var got []string
+	for _, p := range rankPaths(paths) {
+		got = append(got, nodeName(p.path.Nodes[0]))
+	}
+	want := []string{"director", "actor rated 4.5", "actor rated 3", "genre", "shortest"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rankPaths = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,188 @@
+package knowledgegraph
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// Entity is a node extracted from text. Entities are identified by their name
+// and type. The type is only a property, so extracted entities never get the
+// labels of the curated schema, like Movie or Person.
+type Entity struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Aliases     []string `json:"aliases,omitempty"`
+}
+
+// Relation is a typed relationship between two extracted entities.
+type Relation struct {
+	Source     string `json:"source"`
+	SourceType string `json:"sourceType"`
+	Target     string `json:"target"`
+	TargetType string `json:"targetType"`
+	Type       string `json:"type"`
+}
+
+// TextChunk is a piece of a document, that facts may be extracted from.
+type TextChunk struct {
+	ChunkID    string    `json:"chunkId"`
+	DocumentID string    `json:"documentId"`
+	Index      int       `json:"index"`
+	Text       string    `json:"text"`
+	Heading    string    `json:"heading,omitempty"`
+	Embedding  []float32 `json:"embedding,omitempty"`
+}
+
+// identifier matches the labels and relationship types that may be
+// interpolated into a query, since Cypher can't take them as parameters.
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// extractedPrefix namespaces the types of extracted relations, so extraction
+// never writes ACTED_IN, DIRECTED or IN_GENRE of the curated schema.
+const extractedPrefix = "EXTRACTED_"
+
+// ExtractedRelationType returns the relationship type an extracted relation of
+// the type is stored as.
+func ExtractedRelationType(relationType string) string {
+	return extractedPrefix + relationType
+}
+
+// ValidIdentifier reports whether name can be used as a label or relationship
+// type.
+func ValidIdentifier(name string) bool {
+	return identifier.MatchString(name)
+}
+
+// EnsureExtractionSchema creates the constraints and indexes used when merging
+// extracted facts. It is safe to call more than once.
+func (g *knowledgeGraph) EnsureExtractionSchema(ctx context.Context) error {
+	queries := []string{
+		`CREATE CONSTRAINT document_id IF NOT EXISTS FOR (d:Document) REQUIRE d.documentId IS UNIQUE`,
+		`CREATE CONSTRAINT chunk_id IF NOT EXISTS FOR (c:Chunk) REQUIRE c.chunkId IS UNIQUE`,
+		`CREATE INDEX entity_name_type IF NOT EXISTS FOR (e:Entity) ON (e.name, e.type)`,
+	}
+	for _, query := range queries {
+		result, err := g.session.Run(ctx, query, nil)
+		if err != nil {
+			return err
+		}
+		_, err = result.Consume(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeChunkFacts merges a chunk with its embedding, its document and the
+// facts extracted from it in one transaction:
+//
+//	(:Chunk)-[:PART_OF]->(:Document)
+//	(:Chunk)-[:MENTIONS]->(:Entity)
+//
+// Relations are merged between the entities as their ExtractedRelationType,
+// and keep the IDs of every chunk
+// they were extracted from in their sources property. Entity aliases are added
+// to the aliases property. Entity and relation types must be valid
+// identifiers.
+func (g *knowledgeGraph) MergeChunkFacts(ctx context.Context, chunk TextChunk, entities []Entity, relations []Relation) error {
+	byType := map[string][]map[string]any{}
+	for _, entity := range entities {
+		if !ValidIdentifier(entity.Type) {
+			return fmt.Errorf("invalid entity type %q", entity.Type)
+		}
+		byType[entity.Type] = append(byType[entity.Type], map[string]any{
+			"name":        entity.Name,
+			"description": entity.Description,
+			"aliases":     append([]string{}, entity.Aliases...),
+		})
+	}
+	relsByType := map[string][]map[string]any{}
+	for _, relation := range relations {
+		if !ValidIdentifier(relation.Type) {
+			return fmt.Errorf("invalid relation type %q", relation.Type)
+		}
+		relsByType[relation.Type] = append(relsByType[relation.Type], map[string]any{
+			"source":     relation.Source,
+			"sourceType": relation.SourceType,
+			"target":     relation.Target,
+			"targetType": relation.TargetType,
+		})
+	}
+
+	_, err := g.session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+		_, err := tx.Run(ctx, `
+		MERGE (d:Document {documentId: $documentId})
+		MERGE (c:Chunk {chunkId: $chunkId})
+		SET c.text = $text, c.index = $index, c.heading = $heading
+		MERGE (c)-[:PART_OF]->(d)
+		`, map[string]any{
+			"documentId": chunk.DocumentID,
+			"chunkId":    chunk.ChunkID,
+			"text":       chunk.Text,
+			"index":      chunk.Index,
+			"heading":    chunk.Heading,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(chunk.Embedding) > 0 {
+			_, err := tx.Run(ctx, `
+			MATCH (c:Chunk {chunkId: $chunkId})
+			CALL db.create.setNodeVectorProperty(c, 'embedding', $embedding)
+			`, map[string]any{
+				"chunkId":   chunk.ChunkID,
+				"embedding": chunk.Embedding,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for entityType, batch := range byType {
+			query := `
+			MATCH (c:Chunk {chunkId: $chunkId})
+			UNWIND $entities AS entity
+			MERGE (e:Entity {name: entity.name, type: $type})
+			ON CREATE SET e.description = entity.description
+			SET e.aliases = coalesce(e.aliases, []) + [alias IN entity.aliases WHERE NOT alias IN coalesce(e.aliases, [])]
+			MERGE (c)-[:MENTIONS]->(e)
+			`
+			_, err := tx.Run(ctx, query, map[string]any{
+				"chunkId":  chunk.ChunkID,
+				"type":     entityType,
+				"entities": batch,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for relationType, batch := range relsByType {
+			query := fmt.Sprintf(`
+			UNWIND $relations AS relation
+			MATCH (s:Entity {name: relation.source, type: relation.sourceType})
+			MATCH (t:Entity {name: relation.target, type: relation.targetType})
+			MERGE (s)-[r:%s]->(t)
+			SET r.sources = CASE
+				WHEN $chunkId IN coalesce(r.sources, []) THEN r.sources
+				ELSE coalesce(r.sources, []) + $chunkId
+			END
+			`, ExtractedRelationType(relationType))
+			_, err := tx.Run(ctx, query, map[string]any{
+				"chunkId":   chunk.ChunkID,
+				"relations": batch,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
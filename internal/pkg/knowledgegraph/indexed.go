@@ -0,0 +1,77 @@
+package knowledgegraph
+
+import (
+	"context"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/embeddings"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/hnsw"
+)
+
+// indexedGraph searches plots with an HNSW index in Go and only uses the graph
+// to expand the IDs it finds into movies.
+type indexedGraph struct {
+	KnowledgeGraph
+	index    *hnsw.Index
+	ef       int
+	embedder embeddings.Embeddings
+}
+
+// WithVectorIndex returns a KnowledgeGraph that answers plot similarity
+// searches from the index instead of the vector index of the graph. ef is the
+// size of the candidate list of a search; zero uses the default of the index.
+// The embedder embeds the plots searched by SearchSimilarPlots.
+func WithVectorIndex(kg KnowledgeGraph, index *hnsw.Index, ef int, embedder embeddings.Embeddings) KnowledgeGraph {
+	return &indexedGraph{
+		KnowledgeGraph: kg,
+		index:          index,
+		ef:             ef,
+		embedder:       embedder,
+	}
+}
+
+func (g *indexedGraph) SearchSimilarPlots(ctx context.Context, plot string) ([]Movie, error) {
+	embedding, err := g.embedder.Embedding(ctx, plot)
+	if err != nil {
+		return nil, err
+	}
+	return g.SearchSimilarPlotsByEmbedding(ctx, embedding.Embedding, DefaultSearchLimit)
+}
+
+// SearchSimilarPlotsByEmbedding finds the IDs of the k closest plots in the
+// index and fetches their movies from the graph. Scores are converted to the
+// scale of the Neo4j vector index, (1 + cosine) / 2, so they mix with scores
+// from the graph.
+func (g *indexedGraph) SearchSimilarPlotsByEmbedding(ctx context.Context, embedding []float32, k int) ([]Movie, error) {
+	results, err := g.index.Search(embedding, k, g.ef)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(results))
+	scores := make(map[string]float64, len(results))
+	for i, result := range results {
+		ids[i] = result.ID
+		scores[result.ID] = (1 + result.Similarity) / 2
+	}
+
+	movies, err := g.GetMoviesByID(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]Movie, len(movies))
+	for _, movie := range movies {
+		movie.SimilarityScore = scores[movie.MovieID]
+		byID[movie.MovieID] = movie
+	}
+
+	ordered := make([]Movie, 0, len(movies))
+	for _, id := range ids {
+		if movie, ok := byID[id]; ok {
+			ordered = append(ordered, movie)
+		}
+	}
+	return ordered, nil
+}
+
+func (g *indexedGraph) SimilarToMovie(ctx context.Context, seed Movie, k int) ([]Movie, error) {
+	return similarToMovie(ctx, g, seed, k)
+}
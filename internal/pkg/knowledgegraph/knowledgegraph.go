@@ -3,7 +3,6 @@ package knowledgegraph
 import (
 	"context"
 	"fmt"
-	"log"
 
 	"github.com/blogem/knowledge-graph-rag/internal/pkg/embeddings"
 	"github.com/blogem/knowledge-graph-rag/internal/pkg/utils"
@@ -15,14 +14,34 @@ type KnowledgeGraph interface {
 	StoreEmbeddings(ctx context.Context, embbedingsFile string) error
 	GetMovies(ctx context.Context) ([]Movie, error)
 	SearchSimilarPlots(ctx context.Context, plot string) ([]Movie, error)
+	SearchSimilarPlotsByEmbedding(ctx context.Context, embedding []float32, k int) ([]Movie, error)
+	GetEmbeddings(ctx context.Context) ([]Movie, error)
+	GetMoviesByID(ctx context.Context, movieIDs []string) ([]Movie, error)
+	GetUserRatings(ctx context.Context, userID string) ([]Rating, error)
+	UserBasedCandidates(ctx context.Context, userID string, k int) ([]Movie, error)
+	ItemBasedCandidates(ctx context.Context, movieIDs []string, k int) ([]Movie, error)
+	GetMovie(ctx context.Context, movieID string) (Movie, error)
+	SimilarToMovie(ctx context.Context, seed Movie, k int) ([]Movie, error)
+	BoostSharedMetadata(ctx context.Context, seedID string, movies []Movie, boost Boost) ([]Movie, error)
+	ExplainFromMovie(ctx context.Context, seedMovieID string, movieIDs []string, maxPaths int) (map[string][]string, error)
+	ExplainFromUser(ctx context.Context, userID string, movieIDs []string, minRating float64, maxPaths int) (map[string][]string, error)
+	EnsureExtractionSchema(ctx context.Context) error
+	MergeChunkFacts(ctx context.Context, chunk TextChunk, entities []Entity, relations []Relation) error
+	EnsureChunkIndex(ctx context.Context, dimensions int) error
+	SearchSimilarChunks(ctx context.Context, embedding []float32, k int) ([]ChunkMatch, error)
+	EnsureUniqueConstraint(ctx context.Context, label, key string) error
+	Upsert(ctx context.Context, nodes []NodeBatch, relationships []RelationshipBatch) error
 }
 
+// DefaultSearchLimit is the number of movies SearchSimilarPlots returns.
+const DefaultSearchLimit = 6
+
 type knowledgeGraph struct {
 	session  neo4j.SessionWithContext
-	embedder *embeddings.Service
+	embedder embeddings.Embeddings
 }
 
-func NewKnowledgeGraph(ctx context.Context, uri string, username string, password string, embedder *embeddings.Service) (KnowledgeGraph, error) {
+func NewKnowledgeGraph(ctx context.Context, uri string, username string, password string, embedder embeddings.Embeddings) (KnowledgeGraph, error) {
 	var g knowledgeGraph
 	err := g.startSession(ctx, uri, username, password)
 	if err != nil {
@@ -94,6 +113,17 @@ type Movie struct {
 	Released        string  `json:"released"`
 	Budget          int64   `json:"budget"`
 	SimilarityScore float64 `json:"similarityScore"`
+
+	// Scores set by re-ranking, collaborative filtering and
+	// BoostSharedMetadata.
+	RerankScore        float64 `json:"rerankScore,omitempty"`
+	CollaborativeScore float64 `json:"collaborativeScore,omitempty"`
+	BoostScore         float64 `json:"boostScore,omitempty"`
+
+	Embedding []float32 `json:"embedding,omitempty"`
+	Genres    []string  `json:"genres,omitempty"`
+	Directors []string  `json:"directors,omitempty"`
+	Actors    []string  `json:"actors,omitempty"`
 }
 
 func (g *knowledgeGraph) GetMovies(ctx context.Context) ([]Movie, error) {
@@ -148,54 +178,186 @@ func (g *knowledgeGraph) SearchSimilarPlots(ctx context.Context, plot string) ([
 	if err != nil {
 		return nil, err
 	}
+	return g.SearchSimilarPlotsByEmbedding(ctx, embedding.Embedding, DefaultSearchLimit)
+}
 
-	query := fmt.Sprintf(`
-	MATCH (m:Movie)
-
-	CALL db.index.vector.queryNodes('moviePlots', 6, [%s])
+// SearchSimilarPlotsByEmbedding returns the k movies whose plot embedding is
+// closest to the given embedding. The movies include their own embedding, so
+// callers can re-rank the candidates in Go.
+func (g *knowledgeGraph) SearchSimilarPlotsByEmbedding(ctx context.Context, embedding []float32, k int) ([]Movie, error) {
+	query := `
+	CALL db.index.vector.queryNodes('moviePlots', $k, $embedding)
 	YIELD node, score
-	
-	RETURN node.title AS title, node.plot AS plot, score
-	LIMIT 6
-	`, utils.Float32SliceToString(embedding.Embedding))
 
-	log.Println(query)
+	RETURN node.movieId AS movieId, node.title AS title, node.plot AS plot, node.embedding AS embedding, score
+	ORDER BY score DESC
+	LIMIT $k
+	`
+
+	result, err := g.session.Run(ctx, query, map[string]any{
+		"k":         k,
+		"embedding": embedding,
+	})
+	if err != nil {
+		return nil, err
+	}
 
+	return collectMovies(ctx, result)
+}
+
+// GetEmbeddings returns the movieId and plot embedding of every movie with an
+// embedding, to build a vector index outside Neo4j.
+func (g *knowledgeGraph) GetEmbeddings(ctx context.Context) ([]Movie, error) {
+	query := `
+	MATCH (m:Movie)
+	WHERE m.embedding IS NOT NULL
+	RETURN m.movieId AS movieId, m.embedding AS embedding
+	`
 	result, err := g.session.Run(ctx, query, nil)
 	if err != nil {
 		return nil, err
 	}
+	return collectMovies(ctx, result)
+}
 
-	var movies []Movie
+// GetMoviesByID returns the movies with the IDs, in the same order, with the
+// columns of SearchSimilarPlotsByEmbedding. Unknown IDs are left out.
+func (g *knowledgeGraph) GetMoviesByID(ctx context.Context, movieIDs []string) ([]Movie, error) {
+	query := `
+	UNWIND $movieIds AS movieId
+	MATCH (m:Movie {movieId: movieId})
+	RETURN m.movieId AS movieId, m.title AS title, m.plot AS plot, m.embedding AS embedding
+	`
+	result, err := g.session.Run(ctx, query, map[string]any{"movieIds": movieIDs})
+	if err != nil {
+		return nil, err
+	}
+	return collectMovies(ctx, result)
+}
+
+// Rating is a movie rated by a user.
+type Rating struct {
+	Movie  Movie   `json:"movie"`
+	Rating float64 `json:"rating"`
+}
+
+// GetUserRatings returns every movie the user rated, with its plot embedding
+// and genres.
+func (g *knowledgeGraph) GetUserRatings(ctx context.Context, userID string) ([]Rating, error) {
+	query := `
+	MATCH (u:User {userId: $userId})-[r:RATED]->(m:Movie)
+	OPTIONAL MATCH (m)-[:IN_GENRE]->(genre:Genre)
+	RETURN m.movieId AS movieId, m.title AS title, m.embedding AS embedding, r.rating AS rating, collect(genre.name) AS genres
+	ORDER BY rating DESC
+	`
+	result, err := g.session.Run(ctx, query, map[string]any{"userId": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	var ratings []Rating
 	for result.Next(ctx) {
 		record := result.Record()
 
-		title, ok := record.Get("title")
+		movie, ok := recordToMovie(record)
 		if !ok {
-			fmt.Println("title not found")
 			continue
 		}
-		plot, ok := record.Get("plot")
-		if !ok {
-			fmt.Println("plot not found")
+		rating, ok := record.Get("rating")
+		if !ok || rating == nil {
+			fmt.Println("rating not found")
 			continue
 		}
-		score, ok := record.Get("score")
+
+		ratings = append(ratings, Rating{
+			Movie:  movie,
+			Rating: toFloat64(rating),
+		})
+	}
+
+	return ratings, result.Err()
+}
+
+// collectMovies reads every record of the result with recordToMovie.
+func collectMovies(ctx context.Context, result neo4j.ResultWithContext) ([]Movie, error) {
+	var movies []Movie
+	for result.Next(ctx) {
+		movie, ok := recordToMovie(result.Record())
 		if !ok {
-			fmt.Println("score not found")
 			continue
 		}
-		_ = score
+		movies = append(movies, movie)
+	}
+	return movies, result.Err()
+}
 
-		movie := Movie{
-			Title:           title.(string),
-			Plot:            plot.(string),
-			SimilarityScore: score.(float64),
+// recordToMovie reads the movie columns present in a record: movieId, title,
+// plot, embedding, genres, directors, actors, year, imdbId, tmdbId, url and
+// score, which is the similarity score. Only movieId is required.
+func recordToMovie(record *neo4j.Record) (Movie, bool) {
+	movieId, ok := record.Get("movieId")
+	if !ok || movieId == nil {
+		fmt.Println("movieId not found")
+		return Movie{}, false
+	}
+
+	movie := Movie{
+		MovieID: movieId.(string),
+	}
+	if title, ok := record.Get("title"); ok && title != nil {
+		movie.Title = title.(string)
+	}
+	if plot, ok := record.Get("plot"); ok && plot != nil {
+		movie.Plot = plot.(string)
+	}
+	if vector, ok := record.Get("embedding"); ok && vector != nil {
+		movie.Embedding = utils.AnySliceToFloat32(vector.([]any))
+	}
+	if genres, ok := record.Get("genres"); ok && genres != nil {
+		movie.Genres = anySliceToStrings(genres.([]any))
+	}
+	if directors, ok := record.Get("directors"); ok && directors != nil {
+		movie.Directors = anySliceToStrings(directors.([]any))
+	}
+	if actors, ok := record.Get("actors"); ok && actors != nil {
+		movie.Actors = anySliceToStrings(actors.([]any))
+	}
+	if year, ok := record.Get("year"); ok && year != nil {
+		movie.Year = int64(toFloat64(year))
+	}
+	if imdbId, ok := record.Get("imdbId"); ok && imdbId != nil {
+		movie.ImdbID = imdbId.(string)
+	}
+	if tmdbId, ok := record.Get("tmdbId"); ok && tmdbId != nil {
+		movie.TmdbID = tmdbId.(string)
+	}
+	if url, ok := record.Get("url"); ok && url != nil {
+		movie.URL = url.(string)
+	}
+	if score, ok := record.Get("score"); ok && score != nil {
+		movie.SimilarityScore = toFloat64(score)
+	}
+	return movie, true
+}
+
+func anySliceToStrings(slice []any) []string {
+	strs := make([]string, 0, len(slice))
+	for _, v := range slice {
+		if s, ok := v.(string); ok {
+			strs = append(strs, s)
 		}
-		movies = append(movies, movie)
 	}
+	return strs
+}
 
-	return movies, result.Err()
+func toFloat64(v any) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int64:
+		return float64(n)
+	}
+	return 0
 }
 
 func (g *knowledgeGraph) HelloWorld(ctx context.Context, uri, username, password string) (string, error) {
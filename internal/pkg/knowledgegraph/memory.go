@@ -0,0 +1,354 @@
+package knowledgegraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/embeddings"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// MemoryGraph is a KnowledgeGraph kept in memory, for tests and offline use.
+// It stores the same nodes, relationships and properties as Neo4j, answers
+// the same queries by walking them in Go, and searches embeddings with a
+// brute-force cosine index, scored like a Neo4j vector index.
+type MemoryGraph struct {
+	mu            sync.RWMutex
+	embedder      embeddings.Embeddings
+	importDir     string
+	nextID        int64
+	nodes         []*memoryNode
+	relationships []*memoryRelationship
+}
+
+var _ KnowledgeGraph = (*MemoryGraph)(nil)
+
+type memoryNode struct {
+	id     int64
+	labels []string
+	props  map[string]any
+	out    []*memoryRelationship
+	in     []*memoryRelationship
+}
+
+type memoryRelationship struct {
+	id    int64
+	typ   string
+	from  *memoryNode
+	to    *memoryNode
+	props map[string]any
+}
+
+// NewMemoryGraph returns an empty in-memory knowledge graph. The embedder
+// embeds the plots searched by SearchSimilarPlots, and StoreEmbeddings reads
+// its files from importDir, like Neo4j reads them from its import directory.
+func NewMemoryGraph(embedder embeddings.Embeddings, importDir string) *MemoryGraph {
+	return &MemoryGraph{
+		embedder:  embedder,
+		importDir: importDir,
+	}
+}
+
+// fixture is the JSON form of a MemoryGraph. Node IDs only link relationships
+// to their nodes within the fixture.
+type fixture struct {
+	Nodes         []fixtureNode         `json:"nodes"`
+	Relationships []fixtureRelationship `json:"relationships"`
+}
+
+type fixtureNode struct {
+	ID         string         `json:"id"`
+	Labels     []string       `json:"labels"`
+	Properties map[string]any `json:"properties"`
+}
+
+type fixtureRelationship struct {
+	Type       string         `json:"type"`
+	From       string         `json:"from"`
+	To         string         `json:"to"`
+	Properties map[string]any `json:"properties,omitempty"`
+}
+
+// LoadMemoryGraph returns an in-memory knowledge graph with the nodes and
+// relationships of a JSON fixture:
+//
+//	{"nodes": [{"id": "m1", "labels": ["Movie"], "properties": {"movieId": "1", "plot": "...", "embedding": [0.1, 0.2]}},
+//	           {"id": "g1", "labels": ["Genre"], "properties": {"name": "Comedy"}}],
+//	 "relationships": [{"type": "IN_GENRE", "from": "m1", "to": "g1"}]}
+func LoadMemoryGraph(path string, embedder embeddings.Embeddings, importDir string) (*MemoryGraph, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f fixture
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode fixture %s: %w", path, err)
+	}
+
+	g := NewMemoryGraph(embedder, importDir)
+	byID := map[string]*memoryNode{}
+	for _, n := range f.Nodes {
+		if _, ok := byID[n.ID]; ok {
+			return nil, fmt.Errorf("fixture %s has node %q twice", path, n.ID)
+		}
+		byID[n.ID] = g.createNode(n.Labels, fromJSON(n.Properties))
+	}
+	for _, r := range f.Relationships {
+		from, to := byID[r.From], byID[r.To]
+		if from == nil || to == nil {
+			return nil, fmt.Errorf("fixture %s has %s relationship between unknown nodes %q and %q", path, r.Type, r.From, r.To)
+		}
+		g.createRelationship(from, r.Type, to, fromJSON(r.Properties))
+	}
+	return g, nil
+}
+
+// Save writes the graph as a fixture that LoadMemoryGraph can read.
+func (g *MemoryGraph) Save(path string) error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	f := fixture{
+		Nodes:         make([]fixtureNode, 0, len(g.nodes)),
+		Relationships: make([]fixtureRelationship, 0, len(g.relationships)),
+	}
+	for _, n := range g.nodes {
+		f.Nodes = append(f.Nodes, fixtureNode{
+			ID:         strconv.FormatInt(n.id, 10),
+			Labels:     n.labels,
+			Properties: n.props,
+		})
+	}
+	for _, r := range g.relationships {
+		f.Relationships = append(f.Relationships, fixtureRelationship{
+			Type:       r.typ,
+			From:       strconv.FormatInt(r.from.id, 10),
+			To:         strconv.FormatInt(r.to.id, 10),
+			Properties: r.props,
+		})
+	}
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// fromJSON converts decoded JSON properties to the types the driver returns:
+// embeddings become []float32, other lists of strings []string, and whole
+// numbers int64.
+func fromJSON(props map[string]any) map[string]any {
+	converted := make(map[string]any, len(props))
+	for key, value := range props {
+		switch v := value.(type) {
+		case float64:
+			if v == float64(int64(v)) {
+				converted[key] = int64(v)
+			} else {
+				converted[key] = v
+			}
+		case []any:
+			if key == "embedding" {
+				vector := make([]float32, len(v))
+				for i, x := range v {
+					f, _ := x.(float64)
+					vector[i] = float32(f)
+				}
+				converted[key] = vector
+			} else if strs := anySliceToStrings(v); len(strs) == len(v) {
+				converted[key] = strs
+			} else {
+				converted[key] = v
+			}
+		default:
+			converted[key] = value
+		}
+	}
+	return converted
+}
+
+func (g *MemoryGraph) createNode(labels []string, props map[string]any) *memoryNode {
+	g.nextID++
+	if props == nil {
+		props = map[string]any{}
+	}
+	n := &memoryNode{
+		id:     g.nextID,
+		labels: append([]string{}, labels...),
+		props:  props,
+	}
+	g.nodes = append(g.nodes, n)
+	return n
+}
+
+func (g *MemoryGraph) createRelationship(from *memoryNode, typ string, to *memoryNode, props map[string]any) *memoryRelationship {
+	g.nextID++
+	if props == nil {
+		props = map[string]any{}
+	}
+	r := &memoryRelationship{
+		id:    g.nextID,
+		typ:   typ,
+		from:  from,
+		to:    to,
+		props: props,
+	}
+	g.relationships = append(g.relationships, r)
+	from.out = append(from.out, r)
+	to.in = append(to.in, r)
+	return r
+}
+
+// find returns the first node with the label whose properties have the given
+// values, or nil.
+func (g *MemoryGraph) find(label string, props map[string]any) *memoryNode {
+	for _, n := range g.nodes {
+		if n.hasLabel(label) && n.matches(props) {
+			return n
+		}
+	}
+	return nil
+}
+
+// merge returns the node with the label and properties, creating it when
+// there is none, like MERGE.
+func (g *MemoryGraph) merge(label string, props map[string]any) *memoryNode {
+	if n := g.find(label, props); n != nil {
+		return n
+	}
+	created := make(map[string]any, len(props))
+	for key, value := range props {
+		created[key] = value
+	}
+	return g.createNode([]string{label}, created)
+}
+
+// mergeRelationship returns the relationship of the type from one node to the
+// other, creating it when there is none.
+func (g *MemoryGraph) mergeRelationship(from *memoryNode, typ string, to *memoryNode) *memoryRelationship {
+	for _, r := range from.out {
+		if r.typ == typ && r.to == to {
+			return r
+		}
+	}
+	return g.createRelationship(from, typ, to, nil)
+}
+
+// movies returns the Movie nodes.
+func (g *MemoryGraph) movies() []*memoryNode {
+	var movies []*memoryNode
+	for _, n := range g.nodes {
+		if n.hasLabel("Movie") {
+			movies = append(movies, n)
+		}
+	}
+	return movies
+}
+
+func (n *memoryNode) hasLabel(label string) bool {
+	for _, l := range n.labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *memoryNode) matches(props map[string]any) bool {
+	for key, value := range props {
+		if !sameValue(n.props[key], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// string returns a string property, or "" when it is missing.
+func (n *memoryNode) string(key string) string {
+	s, _ := n.props[key].(string)
+	return s
+}
+
+// embedding returns the embedding property, or nil when it is missing.
+func (n *memoryNode) embedding() []float32 {
+	vector, _ := n.props["embedding"].([]float32)
+	return vector
+}
+
+// related returns the nodes at the other end of the relationships of the type,
+// in the direction given by out.
+func (n *memoryNode) related(typ string, out bool) []*memoryNode {
+	rels := n.in
+	if out {
+		rels = n.out
+	}
+	var nodes []*memoryNode
+	for _, r := range rels {
+		if r.typ != typ {
+			continue
+		}
+		if out {
+			nodes = append(nodes, r.to)
+		} else {
+			nodes = append(nodes, r.from)
+		}
+	}
+	return nodes
+}
+
+// names returns the name property of the nodes.
+func names(nodes []*memoryNode) []string {
+	strs := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		if name := n.string("name"); name != "" {
+			strs = append(strs, name)
+		}
+	}
+	return strs
+}
+
+// toNeo4j converts the node to the type the driver returns in paths.
+func (n *memoryNode) toNeo4j() neo4j.Node {
+	return neo4j.Node{
+		Id:        n.id,
+		ElementId: strconv.FormatInt(n.id, 10),
+		Labels:    n.labels,
+		Props:     n.props,
+	}
+}
+
+func (r *memoryRelationship) toNeo4j() neo4j.Relationship {
+	return neo4j.Relationship{
+		Id:             r.id,
+		ElementId:      strconv.FormatInt(r.id, 10),
+		StartId:        r.from.id,
+		StartElementId: strconv.FormatInt(r.from.id, 10),
+		EndId:          r.to.id,
+		EndElementId:   strconv.FormatInt(r.to.id, 10),
+		Type:           r.typ,
+		Props:          r.props,
+	}
+}
+
+// sameValue compares property values like Cypher does, so integer and float
+// numbers are equal when their values are.
+func sameValue(a, b any) bool {
+	if isNumber(a) && isNumber(b) {
+		return toFloat64(a) == toFloat64(b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func isNumber(v any) bool {
+	switch v.(type) {
+	case int64, float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,766 @@
+package knowledgegraph
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/utils"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// explainTypes are the relationship types explanation paths walk over.
+var explainTypes = map[string]bool{"ACTED_IN": true, "DIRECTED": true, "IN_GENRE": true}
+
+func (g *MemoryGraph) HelloWorld(ctx context.Context, uri, username, password string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := g.createNode([]string{"Greeting"}, map[string]any{"message": "hello, world"})
+	return fmt.Sprintf("hello, world, from node %d", n.id), nil
+}
+
+// StoreEmbeddings reads a CSV file with movie_id and embedding columns from
+// the import directory, and sets the embedding of every movie in it.
+func (g *MemoryGraph) StoreEmbeddings(ctx context.Context, embbedingsFile string) error {
+	file, err := os.Open(filepath.Join(g.importDir, embbedingsFile))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read header of %s: %w", embbedingsFile, err)
+	}
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[column] = i
+	}
+	idColumn, ok := columns["movie_id"]
+	if !ok {
+		return fmt.Errorf("%s has no movie_id column", embbedingsFile)
+	}
+	embeddingColumn, ok := columns["embedding"]
+	if !ok {
+		return fmt.Errorf("%s has no embedding column", embbedingsFile)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	stored := 0
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var embedding []float32
+		err = json.Unmarshal([]byte(record[embeddingColumn]), &embedding)
+		if err != nil {
+			return fmt.Errorf("failed to decode embedding of movie %s: %w", record[idColumn], err)
+		}
+		movie := g.find("Movie", map[string]any{"movieId": record[idColumn]})
+		if movie == nil {
+			continue
+		}
+		movie.props["embedding"] = embedding
+		stored++
+	}
+	fmt.Printf("properties set: %d\n", stored)
+	return nil
+}
+
+func (g *MemoryGraph) GetMovies(ctx context.Context) ([]Movie, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var movies []Movie
+	for _, n := range g.movies() {
+		if n.props["movieId"] == nil || n.props["plot"] == nil {
+			continue
+		}
+		movies = append(movies, Movie{
+			MovieID: n.string("movieId"),
+			Plot:    n.string("plot"),
+		})
+	}
+	return movies, nil
+}
+
+func (g *MemoryGraph) SearchSimilarPlots(ctx context.Context, plot string) ([]Movie, error) {
+	embedding, err := g.embedder.Embedding(ctx, plot)
+	if err != nil {
+		return nil, err
+	}
+	return g.SearchSimilarPlotsByEmbedding(ctx, embedding.Embedding, DefaultSearchLimit)
+}
+
+func (g *MemoryGraph) SearchSimilarPlotsByEmbedding(ctx context.Context, embedding []float32, k int) ([]Movie, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var movies []Movie
+	for _, match := range nearest(g.movies(), embedding, k) {
+		movie := searchResult(match.node)
+		movie.SimilarityScore = match.score
+		movies = append(movies, movie)
+	}
+	return movies, nil
+}
+
+func (g *MemoryGraph) GetEmbeddings(ctx context.Context) ([]Movie, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var movies []Movie
+	for _, n := range g.movies() {
+		if len(n.embedding()) == 0 {
+			continue
+		}
+		movies = append(movies, Movie{
+			MovieID:   n.string("movieId"),
+			Embedding: n.embedding(),
+		})
+	}
+	return movies, nil
+}
+
+func (g *MemoryGraph) GetMoviesByID(ctx context.Context, movieIDs []string) ([]Movie, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var movies []Movie
+	for _, movieID := range movieIDs {
+		if n := g.find("Movie", map[string]any{"movieId": movieID}); n != nil {
+			movies = append(movies, searchResult(n))
+		}
+	}
+	return movies, nil
+}
+
+type scoredNode struct {
+	node  *memoryNode
+	score float64
+}
+
+// nearest returns the k nodes whose embedding is closest to the given one,
+// scored like a Neo4j cosine vector index: (1 + cosine) / 2. Nodes without an
+// embedding of the same dimensions aren't indexed.
+func nearest(nodes []*memoryNode, embedding []float32, k int) []scoredNode {
+	var scored []scoredNode
+	for _, n := range nodes {
+		vector := n.embedding()
+		if len(vector) != len(embedding) || len(vector) == 0 {
+			continue
+		}
+		scored = append(scored, scoredNode{
+			node:  n,
+			score: (1 + utils.CosineSimilarity(embedding, vector)) / 2,
+		})
+	}
+	sortScored(scored)
+	return scored[:min(k, len(scored))]
+}
+
+// searchResult returns the columns of a movie returned by the searches:
+// movieId, title, plot and embedding.
+func searchResult(n *memoryNode) Movie {
+	return Movie{
+		MovieID:   n.string("movieId"),
+		Title:     n.string("title"),
+		Plot:      n.string("plot"),
+		Embedding: n.embedding(),
+	}
+}
+
+func (g *MemoryGraph) GetUserRatings(ctx context.Context, userID string) ([]Rating, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	user := g.find("User", map[string]any{"userId": userID})
+	if user == nil {
+		return nil, nil
+	}
+	var ratings []Rating
+	for _, r := range user.out {
+		if r.typ != "RATED" || !r.to.hasLabel("Movie") || r.props["rating"] == nil {
+			continue
+		}
+		ratings = append(ratings, Rating{
+			Movie: Movie{
+				MovieID:   r.to.string("movieId"),
+				Title:     r.to.string("title"),
+				Embedding: r.to.embedding(),
+				Genres:    names(r.to.related("IN_GENRE", true)),
+			},
+			Rating: toFloat64(r.props["rating"]),
+		})
+	}
+	sort.SliceStable(ratings, func(i, j int) bool {
+		return ratings[i].Rating > ratings[j].Rating
+	})
+	return ratings, nil
+}
+
+// ratings returns the movies the user rated with their rating.
+func ratings(user *memoryNode) map[*memoryNode]float64 {
+	rated := map[*memoryNode]float64{}
+	for _, r := range user.out {
+		if r.typ == "RATED" && r.to.hasLabel("Movie") {
+			rated[r.to] = toFloat64(r.props["rating"])
+		}
+	}
+	return rated
+}
+
+// likedBy returns the users that rated the movie at least
+// collaborativeMinRating.
+func likedBy(movie *memoryNode) []*memoryNode {
+	var users []*memoryNode
+	for _, r := range movie.in {
+		if r.typ == "RATED" && r.from.hasLabel("User") && toFloat64(r.props["rating"]) >= collaborativeMinRating {
+			users = append(users, r.from)
+		}
+	}
+	return users
+}
+
+// UserBasedCandidates scores candidates like the Neo4j implementation.
+func (g *MemoryGraph) UserBasedCandidates(ctx context.Context, userID string, k int) ([]Movie, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	user := g.find("User", map[string]any{"userId": userID})
+	if user == nil {
+		return nil, nil
+	}
+	rated := ratings(user)
+
+	var neighbours []scoredNode
+	seen := map[*memoryNode]bool{}
+	for movie := range rated {
+		for _, r := range movie.in {
+			other := r.from
+			if r.typ != "RATED" || other == user || !other.hasLabel("User") || seen[other] {
+				continue
+			}
+			seen[other] = true
+
+			var coRated, dot, norm1, norm2 float64
+			for m, rating := range ratings(other) {
+				own, ok := rated[m]
+				if !ok {
+					continue
+				}
+				coRated++
+				dot += own * rating
+				norm1 += own * own
+				norm2 += rating * rating
+			}
+			if norm1 == 0 || norm2 == 0 {
+				continue
+			}
+			similarity := dot / (math.Sqrt(norm1) * math.Sqrt(norm2)) * coRated / (coRated + collaborativeShrinkage)
+			neighbours = append(neighbours, scoredNode{node: other, score: similarity})
+		}
+	}
+	sortScored(neighbours)
+	neighbours = neighbours[:min(collaborativeNeighbours, len(neighbours))]
+
+	scores := map[*memoryNode]float64{}
+	for _, neighbour := range neighbours {
+		for m, rating := range ratings(neighbour.node) {
+			if _, ok := rated[m]; ok || rating < collaborativeMinRating {
+				continue
+			}
+			scores[m] += neighbour.score * rating
+		}
+	}
+	return collaborativeResults(scores, k), nil
+}
+
+// ItemBasedCandidates scores candidates like the Neo4j implementation.
+func (g *MemoryGraph) ItemBasedCandidates(ctx context.Context, movieIDs []string, k int) ([]Movie, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	seeds := map[string]bool{}
+	for _, id := range movieIDs {
+		seeds[id] = true
+	}
+
+	scores := map[*memoryNode]float64{}
+	for _, seed := range g.movies() {
+		if !seeds[seed.string("movieId")] {
+			continue
+		}
+		seedLikers := likedBy(seed)
+		coLiked := map[*memoryNode]float64{}
+		for _, user := range seedLikers {
+			for m, rating := range ratings(user) {
+				if rating >= collaborativeMinRating && !seeds[m.string("movieId")] {
+					coLiked[m]++
+				}
+			}
+		}
+		for m, count := range coLiked {
+			scores[m] += count / math.Sqrt(float64(len(seedLikers)*len(likedBy(m))))
+		}
+	}
+	return collaborativeResults(scores, k), nil
+}
+
+// sortScored orders nodes by descending score, and by ID when the scores are
+// equal, so results don't depend on map order.
+func sortScored(scored []scoredNode) {
+	sort.Slice(scored, func(i, j int) bool {
+		if scored[i].score != scored[j].score {
+			return scored[i].score > scored[j].score
+		}
+		return scored[i].node.id < scored[j].node.id
+	})
+}
+
+// collaborativeResults returns the k best scoring movies with their score in
+// CollaborativeScore.
+func collaborativeResults(scores map[*memoryNode]float64, k int) []Movie {
+	scored := make([]scoredNode, 0, len(scores))
+	for n, score := range scores {
+		scored = append(scored, scoredNode{node: n, score: score})
+	}
+	sortScored(scored)
+
+	var movies []Movie
+	for _, s := range scored[:min(k, len(scored))] {
+		movie := searchResult(s.node)
+		movie.CollaborativeScore = s.score
+		movies = append(movies, movie)
+	}
+	return movies
+}
+
+func (g *MemoryGraph) GetMovie(ctx context.Context, movieID string) (Movie, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	n := g.find("Movie", map[string]any{"movieId": movieID})
+	if n == nil {
+		return Movie{}, fmt.Errorf("%w: %s", ErrMovieNotFound, movieID)
+	}
+	movie := searchResult(n)
+	movie.Year = int64(toFloat64(n.props["year"]))
+	movie.ImdbID = n.string("imdbId")
+	movie.TmdbID = n.string("tmdbId")
+	movie.URL = n.string("url")
+	movie.Genres = names(n.related("IN_GENRE", true))
+	movie.Directors = names(n.related("DIRECTED", false))
+	movie.Actors = names(n.related("ACTED_IN", false))
+	return movie, nil
+}
+
+func (g *MemoryGraph) SimilarToMovie(ctx context.Context, seed Movie, k int) ([]Movie, error) {
+	return similarToMovie(ctx, g, seed, k)
+}
+
+func (g *MemoryGraph) BoostSharedMetadata(ctx context.Context, seedID string, movies []Movie, boost Boost) ([]Movie, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	scores := map[string]float64{}
+	seed := g.find("Movie", map[string]any{"movieId": seedID})
+	if seed != nil {
+		for _, movie := range movies {
+			m := g.find("Movie", map[string]any{"movieId": movie.MovieID})
+			if m == nil {
+				continue
+			}
+			scores[movie.MovieID] = boost.Genre*shared(seed.related("IN_GENRE", true), m.related("IN_GENRE", true)) +
+				boost.Director*shared(seed.related("DIRECTED", false), m.related("DIRECTED", false)) +
+				boost.Actor*shared(seed.related("ACTED_IN", false), m.related("ACTED_IN", false))
+		}
+	}
+	return applyBoost(movies, scores), nil
+}
+
+// shared counts the nodes in both lists.
+func shared(a, b []*memoryNode) float64 {
+	in := map[*memoryNode]bool{}
+	for _, n := range a {
+		in[n] = true
+	}
+	count := 0.0
+	for _, n := range b {
+		if in[n] {
+			count++
+		}
+	}
+	return count
+}
+
+func (g *MemoryGraph) ExplainFromMovie(ctx context.Context, seedMovieID string, movieIDs []string, maxPaths int) (map[string][]string, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	explanations := map[string][]string{}
+	seed := g.find("Movie", map[string]any{"movieId": seedMovieID})
+	if seed == nil {
+		return explanations, nil
+	}
+	for _, movieID := range movieIDs {
+		m := g.find("Movie", map[string]any{"movieId": movieID})
+		if m == nil || m == seed {
+			continue
+		}
+		paths := twoHopPaths(seed, m)
+		sortPaths(paths, 0)
+		paths = paths[:min(maxPaths, len(paths))]
+		if len(paths) == 0 {
+			if shortest := shortestPath(seed, m, explainMaxHops); shortest != nil {
+				paths = append(paths, *shortest)
+			}
+		}
+		addExplanations(explanations, movieID, paths, maxPaths)
+	}
+	return explanations, nil
+}
+
+func (g *MemoryGraph) ExplainFromUser(ctx context.Context, userID string, movieIDs []string, minRating float64, maxPaths int) (map[string][]string, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	explanations := map[string][]string{}
+	user := g.find("User", map[string]any{"userId": userID})
+	if user == nil {
+		return explanations, nil
+	}
+	for _, movieID := range movieIDs {
+		m := g.find("Movie", map[string]any{"movieId": movieID})
+		if m == nil {
+			continue
+		}
+		var paths []memoryPath
+		for _, r := range user.out {
+			if r.typ != "RATED" || !r.to.hasLabel("Movie") || toFloat64(r.props["rating"]) < minRating {
+				continue
+			}
+			for _, p := range twoHopPaths(r.to, m) {
+				paths = append(paths, memoryPath{
+					nodes: append([]*memoryNode{user}, p.nodes...),
+					rels:  append([]*memoryRelationship{r}, p.rels...),
+				})
+			}
+		}
+		sort.SliceStable(paths, func(i, j int) bool {
+			pi, pj := typePriority(paths[i].rels[1].typ), typePriority(paths[j].rels[1].typ)
+			if pi != pj {
+				return pi < pj
+			}
+			return toFloat64(paths[i].rels[0].props["rating"]) > toFloat64(paths[j].rels[0].props["rating"])
+		})
+		addExplanations(explanations, movieID, paths[:min(maxPaths, len(paths))], maxPaths)
+	}
+	return explanations, nil
+}
+
+type memoryPath struct {
+	nodes []*memoryNode
+	rels  []*memoryRelationship
+}
+
+func (p memoryPath) toNeo4j() neo4j.Path {
+	path := neo4j.Path{}
+	for _, n := range p.nodes {
+		path.Nodes = append(path.Nodes, n.toNeo4j())
+	}
+	for _, r := range p.rels {
+		path.Relationships = append(path.Relationships, r.toNeo4j())
+	}
+	return path
+}
+
+// neighbours returns the relationships over which explanation paths leave the
+// node, in either direction, with the node at their other end.
+func neighbours(n *memoryNode) ([]*memoryRelationship, []*memoryNode) {
+	var rels []*memoryRelationship
+	var nodes []*memoryNode
+	for _, r := range n.out {
+		if explainTypes[r.typ] {
+			rels = append(rels, r)
+			nodes = append(nodes, r.to)
+		}
+	}
+	for _, r := range n.in {
+		if explainTypes[r.typ] {
+			rels = append(rels, r)
+			nodes = append(nodes, r.from)
+		}
+	}
+	return rels, nodes
+}
+
+// twoHopPaths returns the paths of two different relationships from one node
+// to the other.
+func twoHopPaths(from, to *memoryNode) []memoryPath {
+	var paths []memoryPath
+	rels, via := neighbours(from)
+	for i, r1 := range rels {
+		rels2, ends := neighbours(via[i])
+		for j, r2 := range rels2 {
+			if r2 == r1 || ends[j] != to {
+				continue
+			}
+			paths = append(paths, memoryPath{
+				nodes: []*memoryNode{from, via[i], to},
+				rels:  []*memoryRelationship{r1, r2},
+			})
+		}
+	}
+	return paths
+}
+
+// shortestPath finds a shortest path of at most maxHops relationships with a
+// breadth-first search, or nil.
+func shortestPath(from, to *memoryNode, maxHops int) *memoryPath {
+	type step struct {
+		node *memoryNode
+		rel  *memoryRelationship
+		prev *step
+	}
+	visited := map[*memoryNode]bool{from: true}
+	frontier := []*step{{node: from}}
+	for hop := 0; hop < maxHops && len(frontier) > 0; hop++ {
+		var next []*step
+		for _, s := range frontier {
+			rels, nodes := neighbours(s.node)
+			for i, r := range rels {
+				if visited[nodes[i]] {
+					continue
+				}
+				visited[nodes[i]] = true
+				reached := &step{node: nodes[i], rel: r, prev: s}
+				if nodes[i] != to {
+					next = append(next, reached)
+					continue
+				}
+				var path memoryPath
+				for s := reached; s != nil; s = s.prev {
+					path.nodes = append([]*memoryNode{s.node}, path.nodes...)
+					if s.rel != nil {
+						path.rels = append([]*memoryRelationship{s.rel}, path.rels...)
+					}
+				}
+				return &path
+			}
+		}
+		frontier = next
+	}
+	return nil
+}
+
+// sortPaths orders paths like pathPriority, by the type of their relationship
+// at index i.
+func sortPaths(paths []memoryPath, i int) {
+	sort.SliceStable(paths, func(a, b int) bool {
+		return typePriority(paths[a].rels[i].typ) < typePriority(paths[b].rels[i].typ)
+	})
+}
+
+func typePriority(typ string) int {
+	switch typ {
+	case "DIRECTED":
+		return 0
+	case "ACTED_IN":
+		return 1
+	}
+	return 2
+}
+
+// addExplanations renders the paths to a movie, like collectExplanations.
+func addExplanations(explanations map[string][]string, movieID string, paths []memoryPath, maxPaths int) {
+	seen := map[string]bool{}
+	for _, p := range paths {
+		sentence := RenderPath(p.toNeo4j())
+		if sentence == "" || seen[sentence] {
+			continue
+		}
+		seen[sentence] = true
+		explanations[movieID] = append(explanations[movieID], sentence)
+	}
+	if len(explanations[movieID]) > maxPaths {
+		explanations[movieID] = explanations[movieID][:maxPaths]
+	}
+}
+
+// EnsureExtractionSchema does nothing; the in-memory graph has no schema.
+func (g *MemoryGraph) EnsureExtractionSchema(ctx context.Context) error {
+	return nil
+}
+
+// EnsureChunkIndex does nothing; every chunk with an embedding is searched.
+func (g *MemoryGraph) EnsureChunkIndex(ctx context.Context, dimensions int) error {
+	return nil
+}
+
+// EnsureUniqueConstraint does nothing; nodes are merged on their key anyway.
+func (g *MemoryGraph) EnsureUniqueConstraint(ctx context.Context, label, key string) error {
+	if !ValidIdentifier(label) || !ValidIdentifier(key) {
+		return fmt.Errorf("invalid label %q or key %q", label, key)
+	}
+	return nil
+}
+
+func (g *MemoryGraph) MergeChunkFacts(ctx context.Context, chunk TextChunk, entities []Entity, relations []Relation) error {
+	for _, entity := range entities {
+		if !ValidIdentifier(entity.Type) {
+			return fmt.Errorf("invalid entity type %q", entity.Type)
+		}
+	}
+	for _, relation := range relations {
+		if !ValidIdentifier(relation.Type) {
+			return fmt.Errorf("invalid relation type %q", relation.Type)
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	d := g.merge("Document", map[string]any{"documentId": chunk.DocumentID})
+	c := g.merge("Chunk", map[string]any{"chunkId": chunk.ChunkID})
+	c.props["text"] = chunk.Text
+	c.props["index"] = int64(chunk.Index)
+	c.props["heading"] = chunk.Heading
+	if len(chunk.Embedding) > 0 {
+		c.props["embedding"] = append([]float32{}, chunk.Embedding...)
+	}
+	g.mergeRelationship(c, "PART_OF", d)
+
+	for _, entity := range entities {
+		e := g.find("Entity", map[string]any{"name": entity.Name, "type": entity.Type})
+		if e == nil {
+			e = g.createNode([]string{"Entity"}, map[string]any{
+				"name":        entity.Name,
+				"type":        entity.Type,
+				"description": entity.Description,
+			})
+		}
+		aliases, _ := e.props["aliases"].([]string)
+		for _, alias := range entity.Aliases {
+			if !contains(aliases, alias) {
+				aliases = append(aliases, alias)
+			}
+		}
+		e.props["aliases"] = aliases
+		g.mergeRelationship(c, "MENTIONS", e)
+	}
+
+	for _, relation := range relations {
+		s := g.find("Entity", map[string]any{"name": relation.Source, "type": relation.SourceType})
+		t := g.find("Entity", map[string]any{"name": relation.Target, "type": relation.TargetType})
+		if s == nil || t == nil {
+			continue
+		}
+		r := g.mergeRelationship(s, ExtractedRelationType(relation.Type), t)
+		sources, _ := r.props["sources"].([]string)
+		if !contains(sources, chunk.ChunkID) {
+			sources = append(sources, chunk.ChunkID)
+		}
+		r.props["sources"] = sources
+	}
+	return nil
+}
+
+func contains(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *MemoryGraph) SearchSimilarChunks(ctx context.Context, embedding []float32, k int) ([]ChunkMatch, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var chunks []*memoryNode
+	for _, n := range g.nodes {
+		if n.hasLabel("Chunk") {
+			chunks = append(chunks, n)
+		}
+	}
+
+	var matches []ChunkMatch
+	for _, match := range nearest(chunks, embedding, k) {
+		c := match.node
+		chunk := ChunkMatch{
+			Chunk: TextChunk{
+				ChunkID: c.string("chunkId"),
+				Text:    c.string("text"),
+				Index:   int(toFloat64(c.props["index"])),
+				Heading: c.string("heading"),
+			},
+			Score: match.score,
+		}
+		if documents := c.related("PART_OF", true); len(documents) > 0 {
+			chunk.Chunk.DocumentID = documents[0].string("documentId")
+		}
+		for _, e := range c.related("MENTIONS", true) {
+			chunk.Entities = append(chunk.Entities, Entity{
+				Name:        e.string("name"),
+				Type:        e.string("type"),
+				Description: e.string("description"),
+			})
+		}
+		matches = append(matches, chunk)
+	}
+	return matches, nil
+}
+
+func (g *MemoryGraph) Upsert(ctx context.Context, nodes []NodeBatch, relationships []RelationshipBatch) error {
+	for _, batch := range nodes {
+		if !ValidIdentifier(batch.Label) || !ValidIdentifier(batch.Key) {
+			return fmt.Errorf("invalid label %q or key %q", batch.Label, batch.Key)
+		}
+	}
+	for _, batch := range relationships {
+		for _, name := range []string{batch.Type, batch.FromLabel, batch.FromKey, batch.ToLabel, batch.ToKey} {
+			if !ValidIdentifier(name) {
+				return fmt.Errorf("invalid identifier %q in relationship %s", name, batch.Type)
+			}
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, batch := range nodes {
+		for _, row := range batch.Rows {
+			n := g.merge(batch.Label, map[string]any{batch.Key: row[batch.Key]})
+			for key, value := range row {
+				n.props[key] = value
+			}
+		}
+	}
+	for _, batch := range relationships {
+		for _, row := range batch.Rows {
+			from := g.merge(batch.FromLabel, map[string]any{batch.FromKey: row["from"]})
+			to := g.merge(batch.ToLabel, map[string]any{batch.ToKey: row["to"]})
+			r := g.mergeRelationship(from, batch.Type, to)
+			properties, _ := row["properties"].(map[string]any)
+			for key, value := range properties {
+				r.props[key] = value
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package knowledgegraph
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/embeddings"
+)
+
+// fakeEmbedder embeds the texts it knows; others fail.
+type fakeEmbedder map[string][]float32
+
+func (e fakeEmbedder) Embedding(ctx context.Context, prompt string) (embeddings.Embedding, error) {
+	vector, ok := e[prompt]
+	if !ok {
+		return embeddings.Embedding{}, errors.New("unknown prompt")
+	}
+	return embeddings.Embedding{Embedding: vector}, nil
+}
+
+func loadFixture(t *testing.T, importDir string) *MemoryGraph {
+	t.Helper()
+	embedder := fakeEmbedder{"toys": {1, 0, 0}, "robbers": {0, 1, 0}}
+	g, err := LoadMemoryGraph("testdata/movies.json", embedder, importDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func movieIDs(movies []Movie) []string {
+	ids := []string{}
+	for _, m := range movies {
+		ids = append(ids, m.MovieID)
+	}
+	return ids
+}
+
+func TestMemoryGraphGetMovies(t *testing.T) {
+	g := loadFixture(t, "")
+
+	movies, err := g.GetMovies(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nixon has no plot.
+	want := []Movie{
+		{MovieID: "1", Plot: "Toys come to life when their owner is away."},
+		{MovieID: "2", Plot: "A board game brings jungle animals to life."},
+		{MovieID: "6", Plot: "A detective hunts a crew of bank robbers."},
+		{MovieID: "7", Plot: "A chauffeur's daughter returns from Paris."},
+		{MovieID: "3", Plot: "Two old neighbours feud over a woman."},
+	}
+	if !reflect.DeepEqual(movies, want) {
+		t.Errorf("GetMovies = %+v, want %+v", movies, want)
+	}
+}
+
+func TestMemoryGraphSearchSimilarPlotsByEmbedding(t *testing.T) {
+	g := loadFixture(t, "")
+
+	tests := []struct {
+		name      string
+		embedding []float32
+		k         int
+		want      []string
+		wantScore float64
+	}{
+		{"closest first", []float32{1, 0, 0}, 2, []string{"1", "2"}, 1},
+		{"ties by node order", []float32{1, 0, 0}, 10, []string{"1", "2", "6", "7"}, 1},
+		{"limit", []float32{0, 0, 1}, 1, []string{"7"}, 1},
+		{"opposite scores zero", []float32{0, 0, -1}, 1, []string{"1"}, 0.5},
+		{"only same dimensions", []float32{1, 0}, 10, []string{"14"}, 1},
+		{"no limit", []float32{1, 0, 0}, 0, []string{}, 0},
+		{"no matching dimensions", []float32{1, 0, 0, 0}, 10, []string{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			movies, err := g.SearchSimilarPlotsByEmbedding(context.Background(), tt.embedding, tt.k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := movieIDs(movies); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("movies = %v, want %v", got, tt.want)
+			}
+			if len(movies) > 0 && movies[0].SimilarityScore != tt.wantScore {
+				t.Errorf("score = %v, want %v", movies[0].SimilarityScore, tt.wantScore)
+			}
+			for i := 1; i < len(movies); i++ {
+				if movies[i].SimilarityScore > movies[i-1].SimilarityScore {
+					t.Errorf("scores not descending: %v after %v", movies[i].SimilarityScore, movies[i-1].SimilarityScore)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryGraphSearchSimilarPlots(t *testing.T) {
+	g := loadFixture(t, "")
+
+	tests := []struct {
+		plot    string
+		want    []string
+		wantErr bool
+	}{
+		{"toys", []string{"1", "2", "6", "7"}, false},
+		{"robbers", []string{"6", "2", "1", "7"}, false},
+		{"unknown", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.plot, func(t *testing.T) {
+			movies, err := g.SearchSimilarPlots(context.Background(), tt.plot)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := movieIDs(movies); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("movies = %v, want %v", got, tt.want)
+			}
+			if movies[0].Title == "" || movies[0].Plot == "" || movies[0].Embedding == nil {
+				t.Errorf("search result %+v misses its title, plot or embedding", movies[0])
+			}
+		})
+	}
+}
+
+func TestMemoryGraphStoreEmbeddings(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    map[string][]float32
+		wantErr string
+	}{
+		{
+			name: "sets embeddings of known movies",
+			csv:  "movie_id,embedding\n3,\"[0.6,0.8,0]\"\n7,\"[0,0.6,0.8]\"\n99,\"[1,0,0]\"\n",
+			want: map[string][]float32{"3": {0.6, 0.8, 0}, "7": {0, 0.6, 0.8}},
+		},
+		{
+			name: "columns in any order",
+			csv:  "embedding,title,movie_id\n\"[0.6,0.8,0]\",Grumpier Old Men,3\n",
+			want: map[string][]float32{"3": {0.6, 0.8, 0}},
+		},
+		{name: "no movie_id column", csv: "id,embedding\n3,\"[1]\"\n", wantErr: "no movie_id column"},
+		{name: "no embedding column", csv: "movie_id,vector\n3,\"[1]\"\n", wantErr: "no embedding column"},
+		{name: "invalid embedding", csv: "movie_id,embedding\n3,[0.6\n", wantErr: "failed to decode embedding of movie 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := os.WriteFile(filepath.Join(dir, "embeddings.csv"), []byte(tt.csv), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g := loadFixture(t, dir)
+
+			err = g.StoreEmbeddings(context.Background(), "embeddings.csv")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			movies, err := g.GetMoviesByID(context.Background(), []string{"3", "7"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range movies {
+				want, ok := tt.want[m.MovieID]
+				if !ok {
+					want = []float32{0, 0, 1}
+				}
+				if !reflect.DeepEqual(m.Embedding, want) {
+					t.Errorf("embedding of movie %s = %v, want %v", m.MovieID, m.Embedding, want)
+				}
+			}
+		})
+	}
+
+	g := loadFixture(t, t.TempDir())
+	if err := g.StoreEmbeddings(context.Background(), "missing.csv"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: err = %v, want ErrNotExist", err)
+	}
+}
+
+func TestMemoryGraphSaveLoad(t *testing.T) {
+	ctx := context.Background()
+	g := loadFixture(t, "")
+	path := filepath.Join(t.TempDir(), "graph.json")
+	if err := g.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadMemoryGraph(path, g.embedder, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := []struct {
+		name  string
+		query func(*MemoryGraph) (any, error)
+	}{
+		{"GetMovies", func(g *MemoryGraph) (any, error) { return g.GetMovies(ctx) }},
+		{"GetEmbeddings", func(g *MemoryGraph) (any, error) { return g.GetEmbeddings(ctx) }},
+		{"SearchSimilarPlots", func(g *MemoryGraph) (any, error) { return g.SearchSimilarPlots(ctx, "toys") }},
+		{"GetMoviesByID", func(g *MemoryGraph) (any, error) { return g.GetMoviesByID(ctx, []string{"14", "1"}) }},
+		{"GetUserRatings", func(g *MemoryGraph) (any, error) { return g.GetUserRatings(ctx, "1") }},
+	}
+	for _, q := range queries {
+		t.Run(q.name, func(t *testing.T) {
+			want, err := q.query(g)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := q.query(loaded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("after round-trip %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	// The properties keep the types the driver returns.
+	movie := loaded.find("Movie", map[string]any{"movieId": "1"})
+	for key, want := range map[string]any{"year": int64(1995), "imdbRating": 8.3, "embedding": []float32{1, 0, 0}} {
+		if got := movie.props[key]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %#v, want %#v", key, got, want)
+		}
+	}
+	if got, want := len(loaded.relationships), len(g.relationships); got != want {
+		t.Errorf("%d relationships, want %d", got, want)
+	}
+}
+
+func TestLoadMemoryGraphErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		wantErr string
+	}{
+		{"invalid JSON", `{"nodes": [`, "failed to decode fixture"},
+		{"duplicate node", `{"nodes": [{"id": "a"}, {"id": "a"}]}`, `has node "a" twice`},
+		{"unknown node", `{"nodes": [{"id": "a"}], "relationships": [{"type": "RATED", "from": "a", "to": "b"}]}`, `unknown nodes "a" and "b"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "graph.json")
+			if err := os.WriteFile(path, []byte(tt.fixture), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadMemoryGraph(path, nil, "")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMemoryGraphExplainFromMovie(t *testing.T) {
+	g := loadFixture(t, "")
+
+	tests := []struct {
+		name     string
+		movieID  string
+		maxPaths int
+		want     []string
+	}{
+		{"director first", "2", 1, []string{"shares director John Lasseter with Toy Story"}},
+		{"then genre, without the shortest path", "2", 3, []string{"shares director John Lasseter with Toy Story", "shares genre Animation with Toy Story"}},
+		{"shortest path when sharing nothing", "6", 3, []string{"shares actor Val Kilmer with Jumanji, which shares genre Animation with Toy Story"}},
+		{"no path", "7", 3, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			explanations, err := g.ExplainFromMovie(context.Background(), "1", []string{tt.movieID}, tt.maxPaths)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := explanations[tt.movieID]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("explanations = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryGraphMergeChunkFactsKeepsCuratedSchema(t *testing.T) {
+	g := loadFixture(t, "")
+	movies, err := g.GetMovies(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunk := TextChunk{ChunkID: "c1", DocumentID: "d1", Text: "Tom Hanks starred in Toy Story."}
+	entities := []Entity{
+		{Name: "Tom Hanks", Type: "Person"},
+		{Name: "Toy Story", Type: "Movie", Description: "An animated movie."},
+	}
+	relations := []Relation{
+		{Source: "Tom Hanks", SourceType: "Person", Target: "Toy Story", TargetType: "Movie", Type: "ACTED_IN"},
+	}
+	err = g.MergeChunkFacts(context.Background(), chunk, entities, relations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range g.nodes {
+		if n.hasLabel("Entity") && len(n.labels) != 1 {
+			t.Errorf("entity %s has labels %q, want only Entity", n.string("name"), n.labels)
+		}
+	}
+	var types []string
+	for _, r := range g.relationships {
+		if r.from.hasLabel("Entity") {
+			types = append(types, r.typ)
+		}
+	}
+	if want := []string{"EXTRACTED_ACTED_IN"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("relationship types = %q, want %q", types, want)
+	}
+	after, err := g.GetMovies(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after, movies) {
+		t.Errorf("GetMovies after extraction = %+v, want %+v", after, movies)
+	}
+}
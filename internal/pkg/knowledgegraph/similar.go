@@ -0,0 +1,141 @@
+package knowledgegraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrMovieNotFound = errors.New("movie not found")
+
+var ErrNoEmbedding = errors.New("movie has no plot embedding")
+
+// Boost is the score added to a candidate for every genre, director and actor
+// it shares with the seed movie.
+type Boost struct {
+	Genre    float64
+	Director float64
+	Actor    float64
+}
+
+// DefaultBoost is small compared to the spread of similarity scores, so shared
+// metadata reorders close candidates rather than overruling the plot.
+var DefaultBoost = Boost{
+	Genre:    0.01,
+	Director: 0.05,
+	Actor:    0.02,
+}
+
+// GetMovie returns a movie with its plot embedding, genres, directors and
+// actors.
+func (g *knowledgeGraph) GetMovie(ctx context.Context, movieID string) (Movie, error) {
+	query := `
+	MATCH (m:Movie {movieId: $movieId})
+	RETURN m.movieId AS movieId, m.title AS title, m.plot AS plot, m.embedding AS embedding,
+		m.year AS year, m.imdbId AS imdbId, m.tmdbId AS tmdbId, m.url AS url,
+		COLLECT { MATCH (m)-[:IN_GENRE]->(genre:Genre) RETURN genre.name } AS genres,
+		COLLECT { MATCH (director)-[:DIRECTED]->(m) RETURN director.name } AS directors,
+		COLLECT { MATCH (actor)-[:ACTED_IN]->(m) RETURN actor.name } AS actors
+	`
+	result, err := g.session.Run(ctx, query, map[string]any{"movieId": movieID})
+	if err != nil {
+		return Movie{}, err
+	}
+	movies, err := collectMovies(ctx, result)
+	if err != nil {
+		return Movie{}, err
+	}
+	if len(movies) == 0 {
+		return Movie{}, fmt.Errorf("%w: %s", ErrMovieNotFound, movieID)
+	}
+	return movies[0], nil
+}
+
+// SimilarToMovie returns the k movies whose plot is closest to the plot of the
+// seed movie, as returned by GetMovie, using its embedding. The seed itself is
+// left out.
+func (g *knowledgeGraph) SimilarToMovie(ctx context.Context, seed Movie, k int) ([]Movie, error) {
+	return similarToMovie(ctx, g, seed, k)
+}
+
+func similarToMovie(ctx context.Context, g KnowledgeGraph, seed Movie, k int) ([]Movie, error) {
+	if len(seed.Embedding) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoEmbedding, seed.MovieID)
+	}
+
+	candidates, err := g.SearchSimilarPlotsByEmbedding(ctx, seed.Embedding, k+1)
+	if err != nil {
+		return nil, err
+	}
+
+	movies := make([]Movie, 0, k)
+	for _, movie := range candidates {
+		if movie.MovieID == seed.MovieID {
+			continue
+		}
+		movies = append(movies, movie)
+	}
+	return movies[:min(k, len(movies))], nil
+}
+
+// BoostSharedMetadata sets the BoostScore of the movies from the genres,
+// directors and actors they share with the seed movie, and orders them by
+// SimilarityScore plus BoostScore.
+func (g *knowledgeGraph) BoostSharedMetadata(ctx context.Context, seedID string, movies []Movie, boost Boost) ([]Movie, error) {
+	ids := make([]string, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.MovieID
+	}
+
+	query := `
+	MATCH (seed:Movie {movieId: $seedId})
+	UNWIND $movieIds AS movieId
+	MATCH (m:Movie {movieId: movieId})
+	RETURN m.movieId AS movieId,
+		COUNT { (seed)-[:IN_GENRE]->(:Genre)<-[:IN_GENRE]-(m) } AS genres,
+		COUNT { (seed)<-[:DIRECTED]-()-[:DIRECTED]->(m) } AS directors,
+		COUNT { (seed)<-[:ACTED_IN]-()-[:ACTED_IN]->(m) } AS actors
+	`
+	result, err := g.session.Run(ctx, query, map[string]any{
+		"seedId":   seedID,
+		"movieIds": ids,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	scores := map[string]float64{}
+	for result.Next(ctx) {
+		record := result.Record()
+		movieId, ok := record.Get("movieId")
+		if !ok {
+			fmt.Println("movieId not found")
+			continue
+		}
+		genres, _ := record.Get("genres")
+		directors, _ := record.Get("directors")
+		actors, _ := record.Get("actors")
+		scores[movieId.(string)] = boost.Genre*toFloat64(genres) +
+			boost.Director*toFloat64(directors) +
+			boost.Actor*toFloat64(actors)
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+	return applyBoost(movies, scores), nil
+}
+
+// applyBoost sets the BoostScore of copies of the movies from the scores keyed
+// by movieId, and orders them by SimilarityScore plus BoostScore.
+func applyBoost(movies []Movie, scores map[string]float64) []Movie {
+	boosted := make([]Movie, len(movies))
+	copy(boosted, movies)
+	for i := range boosted {
+		boosted[i].BoostScore = scores[boosted[i].MovieID]
+	}
+	sort.SliceStable(boosted, func(i, j int) bool {
+		return boosted[i].SimilarityScore+boosted[i].BoostScore > boosted[j].SimilarityScore+boosted[j].BoostScore
+	})
+	return boosted
+}
@@ -0,0 +1,92 @@
+package knowledgegraph
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// NodeBatch is a batch of nodes with one label, merged on the key property.
+// Every row holds the properties of a node, including the key.
+type NodeBatch struct {
+	Label string
+	Key   string
+	Rows  []map[string]any
+}
+
+// RelationshipBatch is a batch of relationships of one type between nodes
+// identified by their label and key property. Every row has a "from" and "to"
+// key value and the "properties" of the relationship.
+type RelationshipBatch struct {
+	Type      string
+	FromLabel string
+	FromKey   string
+	ToLabel   string
+	ToKey     string
+	Rows      []map[string]any
+}
+
+// EnsureUniqueConstraint creates a uniqueness constraint on the key property
+// of nodes with the label, which also indexes the key for upserts.
+func (g *knowledgeGraph) EnsureUniqueConstraint(ctx context.Context, label, key string) error {
+	if !ValidIdentifier(label) || !ValidIdentifier(key) {
+		return fmt.Errorf("invalid label %q or key %q", label, key)
+	}
+	query := fmt.Sprintf(`CREATE CONSTRAINT %s_%s IF NOT EXISTS FOR (n:%s) REQUIRE n.%s IS UNIQUE`, label, key, label, key)
+	result, err := g.session.Run(ctx, query, nil)
+	if err != nil {
+		return err
+	}
+	_, err = result.Consume(ctx)
+	return err
+}
+
+// Upsert merges the node batches and then the relationship batches in one
+// transaction. Nodes are merged on their key and get the properties of their
+// row; the endpoints of relationships are merged on their key too, so they are
+// created when no node batch has them.
+func (g *knowledgeGraph) Upsert(ctx context.Context, nodes []NodeBatch, relationships []RelationshipBatch) error {
+	for _, batch := range nodes {
+		if !ValidIdentifier(batch.Label) || !ValidIdentifier(batch.Key) {
+			return fmt.Errorf("invalid label %q or key %q", batch.Label, batch.Key)
+		}
+	}
+	for _, batch := range relationships {
+		for _, name := range []string{batch.Type, batch.FromLabel, batch.FromKey, batch.ToLabel, batch.ToKey} {
+			if !ValidIdentifier(name) {
+				return fmt.Errorf("invalid identifier %q in relationship %s", name, batch.Type)
+			}
+		}
+	}
+
+	_, err := g.session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+		for _, batch := range nodes {
+			query := fmt.Sprintf(`
+			UNWIND $rows AS row
+			MERGE (n:%s {%s: row.%s})
+			SET n += row
+			`, batch.Label, batch.Key, batch.Key)
+			_, err := tx.Run(ctx, query, map[string]any{"rows": batch.Rows})
+			if err != nil {
+				return nil, fmt.Errorf("failed to upsert %s nodes: %w", batch.Label, err)
+			}
+		}
+
+		for _, batch := range relationships {
+			query := fmt.Sprintf(`
+			UNWIND $rows AS row
+			MERGE (a:%s {%s: row.from})
+			MERGE (b:%s {%s: row.to})
+			MERGE (a)-[r:%s]->(b)
+			SET r += row.properties
+			`, batch.FromLabel, batch.FromKey, batch.ToLabel, batch.ToKey, batch.Type)
+			_, err := tx.Run(ctx, query, map[string]any{"rows": batch.Rows})
+			if err != nil {
+				return nil, fmt.Errorf("failed to upsert %s relationships: %w", batch.Type, err)
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
@@ -0,0 +1,110 @@
+// Package llm describes what the app needs from a language model, apart from
+// the server that runs it. Providers such as ollama and openai implement LLM
+// and register themselves by name, so the provider is picked by config.
+package llm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is a message of a chat.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Generator answers a single prompt.
+type Generator interface {
+	Generate(ctx context.Context, prompt string) (string, error)
+	// GenerateJSON constrains the model to answer with valid JSON. The prompt
+	// should still describe the expected shape of the JSON.
+	GenerateJSON(ctx context.Context, prompt string) (string, error)
+}
+
+// Chatter answers the last message of a chat.
+type Chatter interface {
+	Chat(ctx context.Context, messages []Message) (string, error)
+}
+
+// Streamer streams the answer to a prompt as it is generated.
+type Streamer interface {
+	GenerateStream(ctx context.Context, prompt string) (*Stream, error)
+}
+
+// Embedder embeds a text with the model.
+type Embedder interface {
+	Embedding(ctx context.Context, prompt string) ([]float32, error)
+}
+
+// LLM is a language model served by a provider.
+type LLM interface {
+	Generator
+	Chatter
+	Streamer
+	Embedder
+	// Capabilities reports what the model supports, asking the server when it
+	// can tell.
+	Capabilities(ctx context.Context) (Capabilities, error)
+}
+
+// Windowed is implemented by providers that know the context window the model
+// runs with, in tokens. 0 means the window is unknown.
+type Windowed interface {
+	ContextWindow(ctx context.Context) (int, error)
+}
+
+// Capabilities are the features a model supports.
+type Capabilities struct {
+	Streaming  bool
+	JSONMode   bool
+	Tools      bool
+	Embeddings bool
+}
+
+// Usage counts tokens. Providers also count their requests.
+type Usage struct {
+	Requests         int
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+}
+
+func (u Usage) String() string {
+	return fmt.Sprintf("%d requests, %d prompt tokens, %d completion tokens, %d total tokens", u.Requests, u.PromptTokens, u.CompletionTokens, u.TotalTokens)
+}
+
+// Meter adds up the usage of the requests of a provider. It is safe for
+// concurrent use.
+type Meter struct {
+	mu    sync.Mutex
+	usage Usage
+}
+
+// Add counts a request and its usage, which is nil when the server didn't
+// report it.
+func (m *Meter) Add(usage *Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usage.Requests++
+	if usage == nil {
+		return
+	}
+	m.usage.PromptTokens += usage.PromptTokens
+	m.usage.CompletionTokens += usage.CompletionTokens
+	m.usage.TotalTokens += usage.TotalTokens
+}
+
+// Usage returns the usage counted so far.
+func (m *Meter) Usage() Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usage
+}
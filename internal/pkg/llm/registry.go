@@ -0,0 +1,127 @@
+package llm
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Config selects a provider and a model.
+type Config struct {
+	Provider string
+	Model    string
+	Address  string
+	APIKey   string
+	// JSONMode tells providers that can't ask the server, like openai, that
+	// it supports a JSON mode.
+	JSONMode bool
+	// HTTPClient is the client providers send their requests with; nil uses
+	// http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Provider creates LLMs of a kind of server.
+type Provider struct {
+	// DefaultModel and DefaultAddress are used when the config leaves them
+	// empty.
+	DefaultModel   string
+	DefaultAddress string
+	New            func(config Config) (LLM, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]Provider{}
+)
+
+// Register makes a provider available by name. It panics when the name is
+// registered twice.
+func Register(name string, provider Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if _, ok := providers[name]; ok {
+		panic(fmt.Sprintf("llm: provider %q registered twice", name))
+	}
+	providers[name] = provider
+}
+
+// Providers returns the names of the registered providers.
+func Providers() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithDefaults fills in the model and address of the provider that the config
+// leaves empty.
+func WithDefaults(config Config) (Config, error) {
+	provider, err := lookup(config.Provider)
+	if err != nil {
+		return Config{}, err
+	}
+	if config.Model == "" {
+		config.Model = provider.DefaultModel
+	}
+	if config.Address == "" {
+		config.Address = provider.DefaultAddress
+	}
+	return config, nil
+}
+
+// New creates the LLM of the config, with the defaults of the provider.
+func New(config Config) (LLM, error) {
+	config, err := WithDefaults(config)
+	if err != nil {
+		return nil, err
+	}
+	provider, _ := lookup(config.Provider)
+	return provider.New(config)
+}
+
+func lookup(name string) (Provider, error) {
+	providersMu.RLock()
+	provider, ok := providers[name]
+	providersMu.RUnlock()
+	if !ok {
+		return Provider{}, fmt.Errorf("unknown LLM provider %q, use %s", name, strings.Join(Providers(), " or "))
+	}
+	return provider, nil
+}
+
+// ParseConfig parses a backend of the form [provider:]model[@address], such as
+// "mistral", "ollama:llama2:13b" or "openai:gpt-4o-mini@http://gpu:8000/v1".
+// The provider prefix is only taken for registered providers, since model
+// names contain colons too. The provider, API key and HTTP client default to
+// those of base, and so does the address when the provider is the same.
+func ParseConfig(spec string, base Config) (Config, error) {
+	config := Config{Provider: base.Provider, APIKey: base.APIKey, HTTPClient: base.HTTPClient}
+	model, address, hasAddress := strings.Cut(strings.TrimSpace(spec), "@")
+	if provider, rest, ok := strings.Cut(model, ":"); ok {
+		if _, err := lookup(provider); err == nil {
+			config.Provider, model = provider, rest
+		}
+	}
+	if model == "" {
+		return Config{}, fmt.Errorf("backend %q has no model", spec)
+	}
+	config.Model = model
+	switch {
+	case hasAddress:
+		config.Address = address
+	case config.Provider == base.Provider:
+		config.Address = base.Address
+	}
+	return WithDefaults(config)
+}
+
+// String returns the config as provider:model.
+func (c Config) String() string {
+	return c.Provider + ":" + c.Model
+}
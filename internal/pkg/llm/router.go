@@ -0,0 +1,310 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Backend is an LLM of a Router.
+type Backend struct {
+	Name string
+	LLM  LLM
+	// Timeout bounds a request, or the wait for the first chunk of a stream.
+	// 0 means no timeout.
+	Timeout time.Duration
+}
+
+// Served tells which backend of a Router served an answer.
+type Served struct {
+	Backend string
+	// Failed has the errors of the backends that were tried before, prefixed
+	// with their names.
+	Failed []error
+}
+
+// Router is an LLM that tries its backends in order, falling back to the next
+// one when a backend fails or times out. Prompts of at most shortLength bytes
+// go to the short backend first, so quick questions can be answered by a
+// small model and long contexts by a bigger one. Embeddings always come from
+// the first backend, since embeddings of different models don't mix.
+type Router struct {
+	backends    []Backend
+	short       *Backend
+	shortLength int
+
+	mu   sync.Mutex
+	last *Served
+}
+
+var (
+	_ LLM      = (*Router)(nil)
+	_ Windowed = (*Router)(nil)
+)
+
+// NewRouter returns a router over the backends. short may be nil.
+func NewRouter(backends []Backend, short *Backend, shortLength int) (*Router, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("router needs at least one backend")
+	}
+	return &Router{
+		backends:    backends,
+		short:       short,
+		shortLength: shortLength,
+	}, nil
+}
+
+// LastServed tells which backend served the last answer, streamed or not, or
+// nil before the first answer. With concurrent requests it is the one that
+// finished last.
+func (r *Router) LastServed() *Served {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+func (r *Router) served(served *Served) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.last = served
+}
+
+// Usage adds up the usage of the backends that count it.
+func (r *Router) Usage() Usage {
+	var total Usage
+	for _, b := range r.all() {
+		reporter, ok := b.LLM.(interface{ Usage() Usage })
+		if !ok {
+			continue
+		}
+		usage := reporter.Usage()
+		total.Requests += usage.Requests
+		total.PromptTokens += usage.PromptTokens
+		total.CompletionTokens += usage.CompletionTokens
+		total.TotalTokens += usage.TotalTokens
+	}
+	return total
+}
+
+func (r *Router) Generate(ctx context.Context, prompt string) (string, error) {
+	var answer string
+	err := r.try(ctx, len(prompt), func(ctx context.Context, b Backend) error {
+		var err error
+		answer, err = b.LLM.Generate(ctx, prompt)
+		return err
+	})
+	return answer, err
+}
+
+func (r *Router) GenerateJSON(ctx context.Context, prompt string) (string, error) {
+	var answer string
+	err := r.try(ctx, len(prompt), func(ctx context.Context, b Backend) error {
+		var err error
+		answer, err = b.LLM.GenerateJSON(ctx, prompt)
+		return err
+	})
+	return answer, err
+}
+
+func (r *Router) Chat(ctx context.Context, messages []Message) (string, error) {
+	length := 0
+	for _, message := range messages {
+		length += len(message.Content)
+	}
+	var answer string
+	err := r.try(ctx, length, func(ctx context.Context, b Backend) error {
+		var err error
+		answer, err = b.LLM.Chat(ctx, messages)
+		return err
+	})
+	return answer, err
+}
+
+// GenerateStream falls back until a backend streams its first chunk. Once the
+// answer is streaming, errors end the stream. The last chunk tells which
+// backend streamed it in Served, as does LastServed once the stream started.
+func (r *Router) GenerateStream(ctx context.Context, prompt string) (*Stream, error) {
+	var failed []error
+	for _, b := range r.route(len(prompt)) {
+		stream, err := firstChunk(ctx, b, prompt, failed)
+		if err == nil {
+			r.served(&Served{Backend: b.Name, Failed: failed})
+			return stream, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("LLM backend %s failed, falling back: %v", b.Name, err)
+		failed = append(failed, fmt.Errorf("%s: %w", b.Name, err))
+	}
+	return nil, allFailed(failed)
+}
+
+// firstChunk opens a stream on the backend and waits for its first chunk, which
+// is replayed by the returned stream. The last chunk gets the backend and the
+// errors of the backends that failed before.
+func firstChunk(ctx context.Context, b Backend, prompt string, failed []error) (*Stream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	var timer *time.Timer
+	if b.Timeout > 0 {
+		timer = time.AfterFunc(b.Timeout, cancel)
+	}
+
+	// fail reports a timeout as such, rather than as a canceled request.
+	fail := func(err error) error {
+		cancel()
+		if timer != nil && !timer.Stop() {
+			return fmt.Errorf("no answer within %s: %w", b.Timeout, err)
+		}
+		return err
+	}
+
+	stream, err := b.LLM.GenerateStream(ctx, prompt)
+	if err != nil {
+		return nil, fail(err)
+	}
+	if !stream.Next() {
+		err := stream.Err()
+		if err == nil {
+			err = ErrTruncated
+		}
+		return nil, fail(err)
+	}
+	if timer != nil {
+		timer.Stop()
+	}
+
+	served := func(chunk Chunk) Chunk {
+		if chunk.Done {
+			chunk.Served = &Served{Backend: b.Name, Failed: failed}
+		}
+		return chunk
+	}
+	first, replayed := stream.Chunk(), false
+	recv := func() (Chunk, error) {
+		if !replayed {
+			replayed = true
+			return served(first), nil
+		}
+		if !stream.Next() {
+			if err := stream.Err(); err != nil {
+				return Chunk{}, err
+			}
+			return Chunk{}, ErrTruncated
+		}
+		return served(stream.Chunk()), nil
+	}
+	return NewStream(recv, func() error {
+		defer cancel()
+		return stream.Close()
+	}), nil
+}
+
+// Embedding embeds with the first backend only.
+func (r *Router) Embedding(ctx context.Context, prompt string) ([]float32, error) {
+	return r.backends[0].LLM.Embedding(ctx, prompt)
+}
+
+// Capabilities are the capabilities all backends share, so a fallback can do
+// what the first backend did. Backends that fail to report their capabilities
+// are skipped, like a model that isn't pulled, which the router falls back
+// from anyway.
+func (r *Router) Capabilities(ctx context.Context) (Capabilities, error) {
+	shared := Capabilities{Streaming: true, JSONMode: true, Tools: true}
+	all := r.all()
+	// Embeddings come from the first backend, which follows the short one.
+	first := len(all) - len(r.backends)
+	var failed []error
+	for i, b := range all {
+		capabilities, err := b.LLM.Capabilities(ctx)
+		if err != nil {
+			log.Printf("failed to get capabilities of LLM backend %s: %v", b.Name, err)
+			failed = append(failed, fmt.Errorf("%s: %w", b.Name, err))
+			continue
+		}
+		shared.Streaming = shared.Streaming && capabilities.Streaming
+		shared.JSONMode = shared.JSONMode && capabilities.JSONMode
+		shared.Tools = shared.Tools && capabilities.Tools
+		if i == first {
+			shared.Embeddings = capabilities.Embeddings
+		}
+	}
+	if len(failed) == len(all) {
+		return Capabilities{}, allFailed(failed)
+	}
+	return shared, nil
+}
+
+// ContextWindow is the smallest context window of the backends, so a prompt
+// fits whichever backend answers it, or 0 when a backend doesn't know its
+// window.
+func (r *Router) ContextWindow(ctx context.Context) (int, error) {
+	smallest := 0
+	for _, b := range r.all() {
+		windowed, ok := b.LLM.(Windowed)
+		if !ok {
+			return 0, nil
+		}
+		window, err := windowed.ContextWindow(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get context window of %s: %w", b.Name, err)
+		}
+		if window == 0 {
+			return 0, nil
+		}
+		if smallest == 0 || window < smallest {
+			smallest = window
+		}
+	}
+	return smallest, nil
+}
+
+// try calls the backends for a prompt of the length in order until one
+// succeeds, and records which one did.
+func (r *Router) try(ctx context.Context, length int, call func(context.Context, Backend) error) error {
+	var failed []error
+	for _, b := range r.route(length) {
+		err := callWithTimeout(ctx, b, call)
+		if err == nil {
+			r.served(&Served{Backend: b.Name, Failed: failed})
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("LLM backend %s failed, falling back: %v", b.Name, err)
+		failed = append(failed, fmt.Errorf("%s: %w", b.Name, err))
+	}
+	return allFailed(failed)
+}
+
+func callWithTimeout(ctx context.Context, b Backend, call func(context.Context, Backend) error) error {
+	if b.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.Timeout)
+		defer cancel()
+	}
+	return call(ctx, b)
+}
+
+// route returns the backends to try for a prompt of the length.
+func (r *Router) route(length int) []Backend {
+	if r.short != nil && length <= r.shortLength {
+		return append([]Backend{*r.short}, r.backends...)
+	}
+	return r.backends
+}
+
+func (r *Router) all() []Backend {
+	if r.short != nil {
+		return append([]Backend{*r.short}, r.backends...)
+	}
+	return r.backends
+}
+
+func allFailed(failed []error) error {
+	return fmt.Errorf("all LLM backends failed: %w", errors.Join(failed...))
+}
@@ -0,0 +1,149 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// fakeLLM answers with its name, or fails with err.
+type fakeLLM struct {
+	name         string
+	err          error
+	capabilities Capabilities
+}
+
+func (f fakeLLM) Generate(ctx context.Context, prompt string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	return f.name, nil
+}
+
+func (f fakeLLM) GenerateJSON(ctx context.Context, prompt string) (string, error) {
+	return f.Generate(ctx, prompt)
+}
+
+func (f fakeLLM) Chat(ctx context.Context, messages []Message) (string, error) {
+	return f.Generate(ctx, "")
+}
+
+func (f fakeLLM) GenerateStream(ctx context.Context, prompt string) (*Stream, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	recv := func() (Chunk, error) {
+		return Chunk{Text: f.name, Done: true}, nil
+	}
+	return NewStream(recv, nil), nil
+}
+
+func (f fakeLLM) Embedding(ctx context.Context, prompt string) ([]float32, error) {
+	return nil, f.err
+}
+
+func (f fakeLLM) Capabilities(ctx context.Context) (Capabilities, error) {
+	return f.capabilities, f.err
+}
+
+func TestRouterCapabilities(t *testing.T) {
+	missing := errors.New("model not found")
+	all := Capabilities{Streaming: true, JSONMode: true, Tools: true, Embeddings: true}
+	tests := []struct {
+		name     string
+		backends []Backend
+		short    *Backend
+		want     Capabilities
+		wantErr  bool
+	}{
+		{
+			name: "shared",
+			backends: []Backend{
+				{Name: "primary", LLM: fakeLLM{capabilities: all}},
+				{Name: "fallback", LLM: fakeLLM{capabilities: Capabilities{Streaming: true}}},
+			},
+			want: Capabilities{Streaming: true, Embeddings: true},
+		},
+		{
+			name: "embeddings of the first backend",
+			backends: []Backend{
+				{Name: "primary", LLM: fakeLLM{capabilities: Capabilities{Streaming: true}}},
+			},
+			short: &Backend{Name: "short", LLM: fakeLLM{capabilities: all}},
+			want:  Capabilities{Streaming: true},
+		},
+		{
+			name: "skips a failed backend",
+			backends: []Backend{
+				{Name: "primary", LLM: fakeLLM{err: missing}},
+				{Name: "fallback", LLM: fakeLLM{capabilities: all}},
+			},
+			want: Capabilities{Streaming: true, JSONMode: true, Tools: true},
+		},
+		{
+			name: "all failed",
+			backends: []Backend{
+				{Name: "primary", LLM: fakeLLM{err: missing}},
+				{Name: "fallback", LLM: fakeLLM{err: missing}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, err := NewRouter(tt.backends, tt.short, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := router.Capabilities(context.Background())
+			if tt.wantErr {
+				if !errors.Is(err, missing) {
+					t.Errorf("Capabilities error = %v, want %v", err, missing)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Capabilities = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouterLastServed(t *testing.T) {
+	router, err := NewRouter([]Backend{
+		{Name: "primary", LLM: fakeLLM{name: "primary", err: errors.New("model not found")}},
+		{Name: "fallback", LLM: fakeLLM{name: "fallback"}},
+	}, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if served := router.LastServed(); served != nil {
+		t.Errorf("LastServed before an answer = %+v, want nil", served)
+	}
+
+	answer, err := router.Generate(context.Background(), "prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := router.LastServed()
+	if answer != "fallback" || served == nil || served.Backend != "fallback" || len(served.Failed) != 1 {
+		t.Errorf("Generate = %q served by %+v, want the fallback after 1 failure", answer, served)
+	}
+
+	stream, err := router.GenerateStream(context.Background(), "prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	for stream.Next() {
+		if chunk := stream.Chunk(); chunk.Done && (chunk.Served == nil || chunk.Served.Backend != "fallback") {
+			t.Errorf("last chunk served by %+v, want the fallback", chunk.Served)
+		}
+	}
+	if served := router.LastServed(); served == nil || served.Backend != "fallback" {
+		t.Errorf("LastServed after a stream = %+v, want the fallback", served)
+	}
+}
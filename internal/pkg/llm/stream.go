@@ -0,0 +1,88 @@
+package llm
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrTruncated is returned by a stream that ended before the answer was done.
+var ErrTruncated = errors.New("stream ended before the answer was done")
+
+// Chunk is a piece of a streamed answer.
+type Chunk struct {
+	Text string
+	// Done is set on the last chunk, which has the usage when the provider
+	// reports it.
+	Done  bool
+	Usage *Usage
+	// Served is set on the last chunk of a Router.
+	Served *Served
+}
+
+// Stream reads the chunks of a streamed answer:
+//
+//	defer stream.Close()
+//	for stream.Next() {
+//		fmt.Print(stream.Chunk().Text)
+//	}
+//	err := stream.Err()
+type Stream struct {
+	recv     func() (Chunk, error)
+	close    func() error
+	chunk    Chunk
+	err      error
+	done     bool
+	closed   bool
+	closeErr error
+}
+
+// NewStream returns a stream reading chunks with recv, which returns io.EOF
+// after the last chunk. close releases the connection.
+func NewStream(recv func() (Chunk, error), close func() error) *Stream {
+	return &Stream{recv: recv, close: close}
+}
+
+// Next reads the next chunk. It returns false after the last chunk or on an
+// error, and then closes the stream.
+func (s *Stream) Next() bool {
+	if s.done || s.err != nil {
+		return false
+	}
+	chunk, err := s.recv()
+	if errors.Is(err, io.EOF) {
+		err = ErrTruncated
+	}
+	if err != nil {
+		s.err = err
+		s.Close()
+		return false
+	}
+	s.chunk = chunk
+	if chunk.Done {
+		s.done = true
+		s.Close()
+	}
+	return true
+}
+
+// Chunk returns the chunk read by Next.
+func (s *Stream) Chunk() Chunk {
+	return s.chunk
+}
+
+// Err returns the error that stopped the stream, if any.
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Close releases the connection. It may be called more than once.
+func (s *Stream) Close() error {
+	if s.closed {
+		return s.closeErr
+	}
+	s.closed = true
+	if s.close != nil {
+		s.closeErr = s.close()
+	}
+	return s.closeErr
+}
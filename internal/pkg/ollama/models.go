@@ -0,0 +1,203 @@
+package ollama
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ModelDetails describes the weights of a model.
+type ModelDetails struct {
+	Format            string `json:"format"`
+	Family            string `json:"family"`
+	ParameterSize     string `json:"parameter_size"`
+	QuantizationLevel string `json:"quantization_level"`
+}
+
+// Model is a model pulled to the Ollama server.
+type Model struct {
+	Name       string       `json:"name"`
+	ModifiedAt time.Time    `json:"modified_at"`
+	Size       int64        `json:"size"`
+	Digest     string       `json:"digest"`
+	Details    ModelDetails `json:"details"`
+}
+
+// ListModels returns the models pulled to the server, from /api/tags.
+func (g *Client) ListModels(ctx context.Context) ([]Model, error) {
+	resp, err := g.do(ctx, http.MethodGet, "/api/tags", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list models: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tags struct {
+		Models []Model `json:"models"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode tags response: %w", err)
+	}
+	return tags.Models, nil
+}
+
+// ModelInfo is what /api/show tells about a model.
+type ModelInfo struct {
+	Details ModelDetails `json:"details"`
+	// Parameters holds the parameters of the Modelfile, one per line, such as
+	// "num_ctx 4096".
+	Parameters   string         `json:"parameters"`
+	Template     string         `json:"template"`
+	ModelInfo    map[string]any `json:"model_info"`
+	Capabilities []string       `json:"capabilities"`
+}
+
+// ContextLength returns the context length the model was trained with, or 0
+// when the server doesn't tell.
+func (m ModelInfo) ContextLength() int {
+	for key, value := range m.ModelInfo {
+		if !strings.HasSuffix(key, ".context_length") {
+			continue
+		}
+		if length, ok := value.(float64); ok {
+			return int(length)
+		}
+	}
+	return 0
+}
+
+// NumCtx returns the context window Ollama runs the model with when the
+// Modelfile sets num_ctx, or 0.
+func (m ModelInfo) NumCtx() int {
+	for _, value := range m.ParameterValues()["num_ctx"] {
+		n, err := strconv.Atoi(value)
+		if err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+// ParameterValues parses Parameters. Parameters like stop can be given more
+// than once.
+func (m ModelInfo) ParameterValues() map[string][]string {
+	values := map[string][]string{}
+	for _, line := range strings.Split(m.Parameters, "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		values[name] = append(values[name], strings.Trim(strings.TrimSpace(value), `"`))
+	}
+	return values
+}
+
+func (m ModelInfo) String() string {
+	var parts []string
+	if m.Details.ParameterSize != "" {
+		parts = append(parts, m.Details.ParameterSize+" parameters")
+	}
+	if m.Details.QuantizationLevel != "" {
+		parts = append(parts, m.Details.QuantizationLevel)
+	}
+	if length := m.ContextLength(); length > 0 {
+		parts = append(parts, fmt.Sprintf("context length %d", length))
+	}
+	values := m.ParameterValues()
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s %s", name, strings.Join(values[name], ", ")))
+	}
+	return strings.Join(parts, ", ")
+}
+
+type modelRequest struct {
+	Model  string `json:"model"`
+	Stream bool   `json:"stream"`
+}
+
+func (r *modelRequest) json() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// Show returns the details of a model. It returns an error wrapping
+// ErrNotFound when the model isn't pulled.
+func (g *Client) Show(ctx context.Context, model string) (ModelInfo, error) {
+	resp, err := g.call(ctx, &modelRequest{Model: model}, "/api/show")
+	if err != nil {
+		return ModelInfo{}, fmt.Errorf("failed to show model %s: %w", model, err)
+	}
+	defer resp.Body.Close()
+
+	var info ModelInfo
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		return ModelInfo{}, fmt.Errorf("failed to decode show response: %w", err)
+	}
+	return info, nil
+}
+
+// PullProgress is a status update of a pull. Total and Completed count the
+// bytes of the layer with Digest, while it is downloaded.
+type PullProgress struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest"`
+	Total     int64  `json:"total"`
+	Completed int64  `json:"completed"`
+}
+
+// Pull downloads a model, calling progress with every status update.
+func (g *Client) Pull(ctx context.Context, model string, progress func(PullProgress)) error {
+	resp, err := g.call(ctx, &modelRequest{Model: model, Stream: true}, "/api/pull")
+	if err != nil {
+		return fmt.Errorf("failed to pull model %s: %w", model, err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	var last string
+	for scanner.Scan() {
+		var update struct {
+			PullProgress
+			Error string `json:"error"`
+		}
+		err := json.Unmarshal(scanner.Bytes(), &update)
+		if err != nil {
+			return fmt.Errorf("failed to decode pull response: %w", err)
+		}
+		if update.Error != "" {
+			return fmt.Errorf("failed to pull model %s: %s", model, update.Error)
+		}
+		last = update.Status
+		if progress != nil {
+			progress(update.PullProgress)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read pull response: %w", err)
+	}
+	if last != "success" {
+		return fmt.Errorf("pull of model %s ended with status %q", model, last)
+	}
+	return nil
+}
+
+// WarmUp loads a model into memory, so the first real request doesn't wait
+// for it. Ollama loads a model for a generate request without a prompt.
+func (g *Client) WarmUp(ctx context.Context, model string) error {
+	resp, err := g.call(ctx, &GenerateRequest{Model: model}, "/api/generate")
+	if err != nil {
+		return fmt.Errorf("failed to warm up model %s: %w", model, err)
+	}
+	return resp.Body.Close()
+}
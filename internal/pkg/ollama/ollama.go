@@ -5,38 +5,73 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/llm"
 )
 
-type LLM interface {
-	Embedding(ctx context.Context, prompt string) (Embedding, error)
-	Generate(ctx context.Context, prompt string) (string, error)
-	GenerateStream(ctx context.Context, prompt string) (*bufio.Scanner, error)
+func init() {
+	llm.Register("ollama", llm.Provider{
+		DefaultModel:   "llama2",
+		DefaultAddress: "http://localhost:11434",
+		New: func(config llm.Config) (llm.LLM, error) {
+			client := NewOllama(config.Model, config.Address)
+			client.HTTPClient = config.HTTPClient
+			return client, nil
+		},
+	})
 }
 
-type ollama struct {
+// Client is the llm.LLM of a model served by Ollama.
+type Client struct {
 	Model   string
 	Address string
+	// HTTPClient sends the requests; nil uses http.DefaultClient.
+	HTTPClient *http.Client
+
+	meter llm.Meter
 }
 
-func NewOllama(model string, address string) LLM {
-	return &ollama{
+var (
+	_ llm.LLM      = (*Client)(nil)
+	_ llm.Windowed = (*Client)(nil)
+)
+
+func NewOllama(model string, address string) *Client {
+	return &Client{
 		Model:   model,   // "llama2",
 		Address: address, // "http://localhost:11434",
 	}
 }
 
+// Usage returns the tokens used since the client was created.
+func (g *Client) Usage() llm.Usage {
+	return g.meter.Usage()
+}
+
+// ErrNotFound is wrapped by errors for requests Ollama answers with 404 Not
+// Found, such as requests for a model that isn't pulled.
+var ErrNotFound = errors.New("not found")
+
 type request interface {
 	json() ([]byte, error)
 }
 
-func (g *ollama) call(r request, endpoint string) (*http.Response, error) {
+func (g *Client) call(ctx context.Context, r request, endpoint string) (*http.Response, error) {
 	data, err := r.json()
 	if err != nil {
 		return nil, err
 	}
+	return g.do(ctx, http.MethodPost, endpoint, bytes.NewBuffer(data))
+}
+
+// do sends a request to the endpoint. Responses other than 200 OK are
+// returned as errors, wrapping ErrNotFound for 404 Not Found.
+func (g *Client) do(ctx context.Context, method, endpoint string, body io.Reader) (*http.Response, error) {
 	// something seems broken with path.Join (or more likely: I'm using it wrong)
 	// fmt.Println("g.Address", g.Address)
 	// url, err := url.Parse(g.Address)
@@ -57,13 +92,35 @@ func (g *ollama) call(r request, endpoint string) (*http.Response, error) {
 		urlstr = fmt.Sprintf("%s/%s", g.Address, endpoint)
 	}
 
-	resp, err := http.Post(urlstr, "application/json", bytes.NewBuffer(data))
+	req, err := http.NewRequestWithContext(ctx, method, urlstr, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request to %s: %w", urlstr, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := g.httpClient().Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to post request to %s: %w", urlstr, err)
+		return nil, fmt.Errorf("failed to send request to %s: %w", urlstr, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("request to %s failed with status %s: %s: %w", urlstr, resp.Status, bytes.TrimSpace(body), ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("request to %s failed with status %s: %s", urlstr, resp.Status, body)
 	}
 	return resp, nil
 }
 
+func (g *Client) httpClient() *http.Client {
+	if g.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return g.HTTPClient
+}
+
 type EmbeddingRequest struct {
 	Model  string `json:"model"`
 	Prompt string `json:"prompt"`
@@ -74,25 +131,27 @@ type Embedding struct {
 	Embedding []float32 `json:"embedding"`
 }
 
-func (g *ollama) Embedding(ctx context.Context, prompt string) (Embedding, error) {
+func (g *Client) Embedding(ctx context.Context, prompt string) ([]float32, error) {
 	endpoint := "api/embeddings"
 	r := &EmbeddingRequest{
 		Model:  g.Model,
 		Prompt: prompt,
 	}
 
-	resp, err := g.call(r, endpoint)
+	resp, err := g.call(ctx, r, endpoint)
 	if err != nil {
-		return Embedding{}, fmt.Errorf("failed to call LLM: %w", err)
+		return nil, fmt.Errorf("failed to call LLM: %w", err)
 	}
+	defer resp.Body.Close()
 
 	var embedding Embedding
 	err = json.NewDecoder(resp.Body).Decode(&embedding)
 	if err != nil {
-		return Embedding{}, fmt.Errorf("failed to decode embedding response: %w", err)
+		return nil, fmt.Errorf("failed to decode embedding response: %w", err)
 	}
+	g.meter.Add(nil)
 
-	return embedding, nil
+	return embedding.Embedding, nil
 }
 
 func (r *EmbeddingRequest) json() ([]byte, error) {
@@ -107,6 +166,7 @@ type GenerateRequest struct {
 	Model  string `json:"model"`
 	Prompt string `json:"prompt"`
 	Stream bool   `json:"stream"`
+	Format string `json:"format,omitempty"`
 }
 
 type GenerateResponse struct {
@@ -123,28 +183,52 @@ type GenerateResponse struct {
 	EvalDuration       int64     `json:"eval_duration"`
 }
 
-func (g *ollama) Generate(ctx context.Context, prompt string) (string, error) {
+func (g *Client) Generate(ctx context.Context, prompt string) (string, error) {
+	return g.generate(ctx, prompt, "")
+}
+
+// GenerateJSON generates a response in Ollama's JSON mode, which constrains
+// the model to answer with valid JSON. The prompt should still describe the
+// expected shape of the JSON.
+func (g *Client) GenerateJSON(ctx context.Context, prompt string) (string, error) {
+	return g.generate(ctx, prompt, "json")
+}
+
+func (g *Client) generate(ctx context.Context, prompt, format string) (string, error) {
 	endpoint := "/api/generate"
 	r := &GenerateRequest{
 		Model:  g.Model,
 		Prompt: prompt,
 		Stream: false,
+		Format: format,
 	}
 
-	resp, err := g.call(r, endpoint)
+	resp, err := g.call(ctx, r, endpoint)
 	if err != nil {
 		return "", fmt.Errorf("failed to call LLM: %w", err)
 	}
+	defer resp.Body.Close()
 
 	var gen GenerateResponse
 	err = json.NewDecoder(resp.Body).Decode(&gen)
 	if err != nil {
 		return "", fmt.Errorf("failed to decode generate response: %w", err)
 	}
+	g.meter.Add(gen.usage())
 	return gen.Response, nil
 }
 
-func (g *ollama) GenerateStream(ctx context.Context, prompt string) (*bufio.Scanner, error) {
+// usage returns the token counts of the last response of a generation.
+func (r GenerateResponse) usage() *llm.Usage {
+	return &llm.Usage{
+		PromptTokens:     r.PromptEvalCount,
+		CompletionTokens: r.EvalCount,
+		TotalTokens:      r.PromptEvalCount + r.EvalCount,
+	}
+}
+
+// GenerateStream streams the NDJSON responses of /api/generate as chunks.
+func (g *Client) GenerateStream(ctx context.Context, prompt string) (*llm.Stream, error) {
 	endpoint := "/api/generate"
 	r := &GenerateRequest{
 		Model:  g.Model,
@@ -152,12 +236,130 @@ func (g *ollama) GenerateStream(ctx context.Context, prompt string) (*bufio.Scan
 		Stream: true,
 	}
 
-	resp, err := g.call(r, endpoint)
+	resp, err := g.call(ctx, r, endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("failed to call LLM: %w", err)
 	}
 
-	return bufio.NewScanner(resp.Body), nil
+	scanner := bufio.NewScanner(resp.Body)
+	recv := func() (llm.Chunk, error) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return llm.Chunk{}, fmt.Errorf("failed to read stream: %w", err)
+			}
+			return llm.Chunk{}, io.EOF
+		}
+		var gen struct {
+			GenerateResponse
+			Error string `json:"error"`
+		}
+		err := json.Unmarshal(scanner.Bytes(), &gen)
+		if err != nil {
+			return llm.Chunk{}, fmt.Errorf("failed to decode stream response: %w", err)
+		}
+		if gen.Error != "" {
+			return llm.Chunk{}, fmt.Errorf("stream failed: %s", gen.Error)
+		}
+		chunk := llm.Chunk{Text: gen.Response, Done: gen.Done}
+		if gen.Done {
+			chunk.Usage = gen.usage()
+			g.meter.Add(chunk.Usage)
+		}
+		return chunk, nil
+	}
+	return llm.NewStream(recv, resp.Body.Close), nil
+}
+
+type ChatRequest struct {
+	Model    string        `json:"model"`
+	Messages []llm.Message `json:"messages"`
+	Stream   bool          `json:"stream"`
+}
+
+type ChatResponse struct {
+	Model           string      `json:"model"`
+	Message         llm.Message `json:"message"`
+	Done            bool        `json:"done"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+	EvalCount       int         `json:"eval_count"`
+}
+
+func (g *Client) Chat(ctx context.Context, messages []llm.Message) (string, error) {
+	endpoint := "/api/chat"
+	r := &ChatRequest{
+		Model:    g.Model,
+		Messages: messages,
+		Stream:   false,
+	}
+
+	resp, err := g.call(ctx, r, endpoint)
+	if err != nil {
+		return "", fmt.Errorf("failed to call LLM: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var chat ChatResponse
+	err = json.NewDecoder(resp.Body).Decode(&chat)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode chat response: %w", err)
+	}
+	g.meter.Add(&llm.Usage{
+		PromptTokens:     chat.PromptEvalCount,
+		CompletionTokens: chat.EvalCount,
+		TotalTokens:      chat.PromptEvalCount + chat.EvalCount,
+	})
+	return chat.Message.Content, nil
+}
+
+func (r *ChatRequest) json() ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// defaultNumCtx is the context window Ollama runs models with when their
+// Modelfile doesn't set num_ctx.
+const defaultNumCtx = 2048
+
+// ContextWindow asks /api/show for the num_ctx of the model, which defaults to
+// 2048 tokens. Ollama cuts longer prompts at the start.
+func (g *Client) ContextWindow(ctx context.Context) (int, error) {
+	info, err := g.Show(ctx, g.Model)
+	if err != nil {
+		return 0, err
+	}
+	if info.NumCtx() > 0 {
+		return info.NumCtx(), nil
+	}
+	return defaultNumCtx, nil
+}
+
+// Capabilities asks /api/show what the model supports. Ollama versions that
+// don't list the capabilities of a model are assumed to generate and embed
+// with it, without tools.
+func (g *Client) Capabilities(ctx context.Context) (llm.Capabilities, error) {
+	info, err := g.Show(ctx, g.Model)
+	if err != nil {
+		return llm.Capabilities{}, err
+	}
+	if info.Capabilities == nil {
+		return llm.Capabilities{Streaming: true, JSONMode: true, Embeddings: true}, nil
+	}
+	var capabilities llm.Capabilities
+	for _, capability := range info.Capabilities {
+		switch capability {
+		case "completion":
+			capabilities.Streaming = true
+			capabilities.JSONMode = true
+		case "tools":
+			capabilities.Tools = true
+		case "embedding":
+			capabilities.Embeddings = true
+		}
+	}
+	return capabilities, nil
 }
 
 func (r *GenerateRequest) json() ([]byte, error) {
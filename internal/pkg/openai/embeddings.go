@@ -0,0 +1,37 @@
+package openai
+
+import (
+	"context"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/embeddings"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/llm"
+)
+
+// Embeddings embeds texts with the /embeddings endpoint of an OpenAI
+// compatible server.
+type Embeddings struct {
+	Client *Client
+}
+
+var _ embeddings.Batcher = (*Embeddings)(nil)
+
+func NewEmbeddings(model, baseURL, apiKey string) *Embeddings {
+	return &Embeddings{Client: NewOpenAI(model, baseURL, apiKey)}
+}
+
+func (e *Embeddings) Embedding(ctx context.Context, prompt string) (embeddings.Embedding, error) {
+	vectors, err := e.Client.EmbeddingBatch(ctx, []string{prompt})
+	if err != nil {
+		return embeddings.Embedding{}, err
+	}
+	return embeddings.Embedding{Embedding: vectors[0]}, nil
+}
+
+func (e *Embeddings) EmbeddingBatch(ctx context.Context, prompts []string) ([][]float32, error) {
+	return e.Client.EmbeddingBatch(ctx, prompts)
+}
+
+// Usage returns the usage of the embeddings since they were created.
+func (e *Embeddings) Usage() llm.Usage {
+	return e.Client.Usage()
+}
@@ -0,0 +1,331 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/llm"
+)
+
+func init() {
+	llm.Register("openai", llm.Provider{
+		DefaultModel:   "gpt-3.5-turbo",
+		DefaultAddress: "http://localhost:8080/v1",
+		New: func(config llm.Config) (llm.LLM, error) {
+			client := NewOpenAI(config.Model, config.Address, config.APIKey)
+			client.HTTPClient = config.HTTPClient
+			client.JSONMode = config.JSONMode
+			return client, nil
+		},
+	})
+}
+
+// Client talks to servers with an OpenAI compatible API, such as vLLM, the
+// llama.cpp server, LM Studio and LocalAI. Prompts are sent as a single user
+// message to /chat/completions.
+type Client struct {
+	Model   string
+	BaseURL string
+	APIKey  string
+	// JSONMode sends response_format with GenerateJSON. Not every OpenAI
+	// compatible server supports it, so it is off by default.
+	JSONMode bool
+	// HTTPClient sends the requests; nil uses http.DefaultClient.
+	HTTPClient *http.Client
+
+	meter llm.Meter
+}
+
+var _ llm.LLM = (*Client)(nil)
+
+// NewOpenAI returns a client for the model served at baseURL, which includes
+// the version, like "http://localhost:8080/v1". apiKey may be empty for
+// servers that don't check it.
+func NewOpenAI(model, baseURL, apiKey string) *Client {
+	return &Client{
+		Model:   model,
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		APIKey:  apiKey,
+	}
+}
+
+// Usage returns the tokens used since the client was created.
+func (c *Client) Usage() llm.Usage {
+	return c.meter.Usage()
+}
+
+// usage is the usage reported by the server.
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (u *usage) llm() *llm.Usage {
+	if u == nil {
+		return nil
+	}
+	return &llm.Usage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+}
+
+type responseFormat struct {
+	Type string `json:"type"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type chatRequest struct {
+	Model          string          `json:"model"`
+	Messages       []llm.Message   `json:"messages"`
+	Stream         bool            `json:"stream"`
+	StreamOptions  *streamOptions  `json:"stream_options,omitempty"`
+	ResponseFormat *responseFormat `json:"response_format,omitempty"`
+}
+
+type choice struct {
+	Message      llm.Message `json:"message"`
+	Delta        llm.Message `json:"delta"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+type apiError struct {
+	Message string `json:"message"`
+}
+
+// chatResponse is a response of /chat/completions, or a chunk of a streamed
+// response.
+type chatResponse struct {
+	Model   string    `json:"model"`
+	Choices []choice  `json:"choices"`
+	Usage   *usage    `json:"usage"`
+	Error   *apiError `json:"error"`
+}
+
+func (c *Client) Generate(ctx context.Context, prompt string) (string, error) {
+	return c.generate(ctx, prompt, nil)
+}
+
+// GenerateJSON asks for a JSON object with response_format when the server
+// has JSONMode. Otherwise only the prompt asks for JSON, and the answer is cut
+// to its outermost object, without the text or code fence around it. The
+// prompt should describe the expected shape of the JSON either way.
+func (c *Client) GenerateJSON(ctx context.Context, prompt string) (string, error) {
+	if c.JSONMode {
+		return c.generate(ctx, prompt, &responseFormat{Type: "json_object"})
+	}
+	answer, err := c.generate(ctx, prompt, nil)
+	if err != nil {
+		return "", err
+	}
+	return jsonObject(answer), nil
+}
+
+// jsonObject returns the text from its first { to its last }, or all of it
+// when it has no object.
+func jsonObject(text string) string {
+	start, end := strings.Index(text, "{"), strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return text
+	}
+	return text[start : end+1]
+}
+
+func (c *Client) generate(ctx context.Context, prompt string, format *responseFormat) (string, error) {
+	return c.chat(ctx, []llm.Message{{Role: llm.RoleUser, Content: prompt}}, format)
+}
+
+func (c *Client) Chat(ctx context.Context, messages []llm.Message) (string, error) {
+	return c.chat(ctx, messages, nil)
+}
+
+func (c *Client) chat(ctx context.Context, messages []llm.Message, format *responseFormat) (string, error) {
+	r := chatRequest{
+		Model:          c.Model,
+		Messages:       messages,
+		ResponseFormat: format,
+	}
+	resp, err := c.post(ctx, "chat/completions", r)
+	if err != nil {
+		return "", fmt.Errorf("failed to call LLM: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var chat chatResponse
+	err = json.NewDecoder(resp.Body).Decode(&chat)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode chat response: %w", err)
+	}
+	c.meter.Add(chat.Usage.llm())
+	if len(chat.Choices) == 0 {
+		return "", fmt.Errorf("chat response of %s has no choices", c.Model)
+	}
+	return chat.Choices[0].Message.Content, nil
+}
+
+// GenerateStream streams the answer with server-sent events. The usage is
+// asked for in a last event, which not all servers send.
+func (c *Client) GenerateStream(ctx context.Context, prompt string) (*llm.Stream, error) {
+	r := chatRequest{
+		Model:         c.Model,
+		Messages:      []llm.Message{{Role: llm.RoleUser, Content: prompt}},
+		Stream:        true,
+		StreamOptions: &streamOptions{IncludeUsage: true},
+	}
+	resp, err := c.post(ctx, "chat/completions", r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call LLM: %w", err)
+	}
+
+	events := newEventScanner(resp.Body)
+	var usage *llm.Usage
+	finished, done := false, false
+	last := func() (llm.Chunk, error) {
+		done = true
+		c.meter.Add(usage)
+		return llm.Chunk{Done: true, Usage: usage}, nil
+	}
+	recv := func() (llm.Chunk, error) {
+		for !done && events.Scan() {
+			data := events.Data()
+			if data == "[DONE]" {
+				return last()
+			}
+
+			var chunk chatResponse
+			err := json.Unmarshal([]byte(data), &chunk)
+			if err != nil {
+				return llm.Chunk{}, fmt.Errorf("failed to decode stream event: %w", err)
+			}
+			if chunk.Error != nil {
+				return llm.Chunk{}, fmt.Errorf("stream failed: %s", chunk.Error.Message)
+			}
+			if chunk.Usage != nil {
+				usage = chunk.Usage.llm()
+			}
+			var text string
+			for _, choice := range chunk.Choices {
+				if choice.FinishReason != "" {
+					finished = true
+				}
+				text += choice.Delta.Content
+			}
+			if text != "" {
+				return llm.Chunk{Text: text}, nil
+			}
+		}
+		if err := events.Err(); err != nil {
+			return llm.Chunk{}, fmt.Errorf("failed to read stream: %w", err)
+		}
+		// Some servers close the stream without [DONE]; that's fine once the
+		// answer has finished.
+		if finished && !done {
+			return last()
+		}
+		return llm.Chunk{}, io.EOF
+	}
+	return llm.NewStream(recv, resp.Body.Close), nil
+}
+
+// Capabilities can't be asked from OpenAI compatible servers, so only what all
+// of them do is reported: streaming, and JSON mode when the client is told the
+// server has it.
+func (c *Client) Capabilities(ctx context.Context) (llm.Capabilities, error) {
+	return llm.Capabilities{Streaming: true, JSONMode: c.JSONMode}, nil
+}
+
+type embeddingsRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embeddingsResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Usage *usage    `json:"usage"`
+	Error *apiError `json:"error"`
+}
+
+func (c *Client) Embedding(ctx context.Context, prompt string) ([]float32, error) {
+	vectors, err := c.EmbeddingBatch(ctx, []string{prompt})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
+
+// EmbeddingBatch embeds the prompts in one request to /embeddings, in the
+// same order.
+func (c *Client) EmbeddingBatch(ctx context.Context, prompts []string) ([][]float32, error) {
+	if len(prompts) == 0 {
+		return nil, nil
+	}
+	resp, err := c.post(ctx, "embeddings", embeddingsRequest{Model: c.Model, Input: prompts})
+	if err != nil {
+		return nil, fmt.Errorf("failed to call embeddings: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var response embeddingsResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings response: %w", err)
+	}
+	c.meter.Add(response.Usage.llm())
+
+	vectors := make([][]float32, len(prompts))
+	for _, data := range response.Data {
+		if data.Index < 0 || data.Index >= len(prompts) {
+			return nil, fmt.Errorf("embeddings response has index %d for %d texts", data.Index, len(prompts))
+		}
+		vectors[data.Index] = data.Embedding
+	}
+	for i, vector := range vectors {
+		if vector == nil {
+			return nil, fmt.Errorf("embeddings response has no embedding for text %d", i)
+		}
+	}
+	return vectors, nil
+}
+
+func (c *Client) post(ctx context.Context, endpoint string, request any) (*http.Response, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	urlstr := fmt.Sprintf("%s/%s", c.BaseURL, endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, urlstr, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request to %s: %w", urlstr, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to post request to %s: %w", urlstr, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("request to %s failed with status %s: %s", urlstr, resp.Status, body)
+	}
+	return resp, nil
+}
@@ -0,0 +1,59 @@
+package openai
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// maxEventSize bounds a line of the event stream.
+const maxEventSize = 1 << 20
+
+// eventScanner reads the data of server-sent events. Comments, event names and
+// ids are skipped; the data lines of an event are joined with newlines.
+type eventScanner struct {
+	scanner *bufio.Scanner
+	data    string
+}
+
+func newEventScanner(r io.Reader) *eventScanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEventSize)
+	return &eventScanner{scanner: scanner}
+}
+
+// Scan advances to the next event with data. It returns false at the end of
+// the stream or on an error.
+func (s *eventScanner) Scan() bool {
+	var lines []string
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if line == "" {
+			if len(lines) > 0 {
+				s.data = strings.Join(lines, "\n")
+				return true
+			}
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		if field != "data" {
+			continue
+		}
+		lines = append(lines, strings.TrimPrefix(value, " "))
+	}
+	// The last event may not be followed by a blank line.
+	if len(lines) > 0 {
+		s.data = strings.Join(lines, "\n")
+		return true
+	}
+	return false
+}
+
+// Data returns the data of the current event.
+func (s *eventScanner) Data() string {
+	return s.data
+}
+
+func (s *eventScanner) Err() error {
+	return s.scanner.Err()
+}
@@ -0,0 +1,190 @@
+package packing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/llm"
+)
+
+// Overflow is what a Packer does with segments that don't fit.
+type Overflow string
+
+const (
+	// Drop leaves them out.
+	Drop Overflow = "drop"
+	// Truncate cuts the first one to the tokens that are left and drops the
+	// others.
+	Truncate Overflow = "truncate"
+	// Summarize replaces them with a summary by the LLM, when the summary
+	// fits.
+	Summarize Overflow = "summarize"
+)
+
+// minTruncatedTokens is the smallest part of a segment worth keeping, cut
+// short or summarized.
+const minTruncatedTokens = 32
+
+// Segment is a piece of context for a prompt, such as a movie or a passage.
+type Segment struct {
+	// Kind groups segments, like the sections of a prompt.
+	Kind string
+	ID   string
+	// Label goes before the text, and is kept as is when the text is
+	// truncated or summarized.
+	Label string
+	Text  string
+}
+
+// String returns the label and the text.
+func (s Segment) String() string {
+	return s.Label + s.Text
+}
+
+// Packed is the result of Pack.
+type Packed struct {
+	// Segments are the segments that fit, in their original order.
+	Segments []Segment
+	// Tokens is the estimated number of tokens of Segments.
+	Tokens int
+	// Truncated, Summarized and Dropped hold the IDs of the segments that
+	// didn't fit.
+	Truncated  []string
+	Summarized []string
+	Dropped    []string
+}
+
+func (p Packed) String() string {
+	s := fmt.Sprintf("%d segments in %d tokens", len(p.Segments), p.Tokens)
+	if len(p.Truncated) > 0 {
+		s += fmt.Sprintf(", truncated %s", strings.Join(p.Truncated, ", "))
+	}
+	if len(p.Summarized) > 0 {
+		s += fmt.Sprintf(", summarized %s", strings.Join(p.Summarized, ", "))
+	}
+	if len(p.Dropped) > 0 {
+		s += fmt.Sprintf(", dropped %s", strings.Join(p.Dropped, ", "))
+	}
+	return s
+}
+
+// Packer fits the context of a prompt into a budget of tokens.
+type Packer struct {
+	estimator *Estimator
+	model     string
+	budget    int
+	overflow  Overflow
+	llm       llm.Generator
+}
+
+// NewPacker returns a packer for prompts of the model. generator is only used
+// to summarize, and may be nil otherwise.
+func NewPacker(estimator *Estimator, model string, budget int, overflow Overflow, generator llm.Generator) (*Packer, error) {
+	switch overflow {
+	case Drop, Truncate:
+	case Summarize:
+		if generator == nil {
+			return nil, fmt.Errorf("overflow %s needs an LLM", overflow)
+		}
+	default:
+		return nil, fmt.Errorf("unknown overflow %q, use drop, truncate or summarize", overflow)
+	}
+	return &Packer{
+		estimator: estimator,
+		model:     model,
+		budget:    budget,
+		overflow:  overflow,
+		llm:       generator,
+	}, nil
+}
+
+// Pack keeps the segments that fit in the budget, taking them in order, so the
+// best segments should come first. Segments that don't fit are handled as set
+// by the overflow of the packer.
+func (p *Packer) Pack(ctx context.Context, segments []Segment) (Packed, error) {
+	var packed Packed
+	kept := make([]*Segment, len(segments))
+	var overflow []int
+	left := p.budget
+	for i, segment := range segments {
+		tokens := p.estimator.Tokens(p.model, segment.String())
+		if tokens > left {
+			overflow = append(overflow, i)
+			continue
+		}
+		kept[i] = &segments[i]
+		left -= tokens
+	}
+
+	for _, i := range overflow {
+		segment := segments[i]
+		labelTokens := p.estimator.Tokens(p.model, segment.Label)
+		switch {
+		case p.overflow == Truncate && left-labelTokens >= minTruncatedTokens:
+			segment.Text = p.truncate(segment.Text, left-labelTokens)
+			packed.Truncated = append(packed.Truncated, segment.ID)
+		case p.overflow == Summarize && left-labelTokens >= minTruncatedTokens:
+			summary, err := p.summarize(ctx, segment.Text)
+			if err != nil {
+				return Packed{}, err
+			}
+			if p.estimator.Tokens(p.model, segment.Label+summary) > left {
+				packed.Dropped = append(packed.Dropped, segment.ID)
+				continue
+			}
+			segment.Text = summary
+			packed.Summarized = append(packed.Summarized, segment.ID)
+		default:
+			packed.Dropped = append(packed.Dropped, segment.ID)
+			continue
+		}
+		kept[i] = &segment
+		left -= p.estimator.Tokens(p.model, segment.String())
+	}
+
+	for _, segment := range kept {
+		if segment != nil {
+			packed.Segments = append(packed.Segments, *segment)
+		}
+	}
+	packed.Tokens = p.budget - left
+	return packed, nil
+}
+
+// truncate cuts the text to about the tokens at a word boundary.
+func (p *Packer) truncate(text string, tokens int) string {
+	suffix := " …"
+	// Keep a trailing newline, which may separate the segment from the next.
+	if strings.HasSuffix(text, "\n") {
+		suffix += "\n"
+	}
+	n := int(float64(tokens)*p.estimator.BytesPerToken(p.model)) - len(suffix)
+	if n >= len(text) {
+		return text
+	}
+	if n <= 0 {
+		return ""
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	cut := text[:n]
+	if space := strings.LastIndexAny(cut, " \n\t"); space > 0 {
+		cut = cut[:space]
+	}
+	return strings.TrimRight(cut, " \n\t") + suffix
+}
+
+func (p *Packer) summarize(ctx context.Context, text string) (string, error) {
+	prompt := fmt.Sprintf(`Summarize the following text in one or two sentences. Keep titles, names and the
+labels before a colon as they are. Answer with the summary only.
+
+%s`, text)
+	summary, err := p.llm.Generate(ctx, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize: %w", err)
+	}
+	return strings.TrimSpace(summary) + "\n", nil
+}
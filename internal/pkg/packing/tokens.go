@@ -0,0 +1,94 @@
+package packing
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"sync"
+)
+
+// defaultBytesPerToken is the length of an average English token for most
+// tokenizers.
+const defaultBytesPerToken = 4.0
+
+// Estimator estimates the number of tokens of a text from its length, with the
+// average number of bytes per token of the model. The average starts at 4 and
+// is calibrated with the token counts the model reports for prompts.
+type Estimator struct {
+	mu            sync.Mutex
+	bytesPerToken map[string]float64
+}
+
+func NewEstimator() *Estimator {
+	return &Estimator{
+		bytesPerToken: map[string]float64{},
+	}
+}
+
+// LoadEstimator reads the calibration written by Save. A missing file results
+// in an uncalibrated estimator.
+func LoadEstimator(path string) (*Estimator, error) {
+	e := NewEstimator()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return e, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &e.bytesPerToken)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Save writes the calibration to path as JSON.
+func (e *Estimator) Save(path string) error {
+	e.mu.Lock()
+	data, err := json.Marshal(e.bytesPerToken)
+	e.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Tokens estimates the tokens of the text for the model, rounding up.
+func (e *Estimator) Tokens(model, text string) int {
+	return int(math.Ceil(float64(len(text)) / e.BytesPerToken(model)))
+}
+
+// BytesPerToken returns the average bytes per token of the model.
+func (e *Estimator) BytesPerToken(model string) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ratio, ok := e.bytesPerToken[model]; ok {
+		return ratio
+	}
+	return defaultBytesPerToken
+}
+
+// Calibrate updates the average of the model with the number of tokens the
+// model counted for the text, such as the prompt_eval_count of Ollama. Counts
+// that are far off are ignored: Ollama only counts the tokens it didn't have
+// cached from the previous prompt.
+func (e *Estimator) Calibrate(model, text string, tokens int) {
+	if tokens <= 0 || len(text) == 0 {
+		return
+	}
+	observed := float64(len(text)) / float64(tokens)
+	if observed < 1 || observed > 10 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ratio, ok := e.bytesPerToken[model]
+	if !ok {
+		e.bytesPerToken[model] = observed
+		return
+	}
+	// A moving average, so one odd prompt doesn't throw it off.
+	e.bytesPerToken[model] = 0.7*ratio + 0.3*observed
+}
@@ -0,0 +1,137 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/utils"
+)
+
+// maxFavourites is the number of favourite movies mentioned in the summary.
+const maxFavourites = 10
+
+// maxGenres is the number of favourite genres mentioned in the summary.
+const maxGenres = 5
+
+var ErrNoHighRatings = errors.New("user has no high ratings for movies with a plot embedding")
+
+// TasteProfile describes what a user likes, based on their rating history.
+type TasteProfile struct {
+	UserID    string
+	MinRating float64
+	// Centroid is the rating-weighted average of the normalised plot
+	// embeddings of the movies the user rated at least MinRating.
+	Centroid []float32
+	// Favourites are the highly rated movies, best rated first.
+	Favourites []knowledgegraph.Rating
+	// TopGenres are the genres of the favourites, most frequent first.
+	TopGenres []string
+	// Rated holds the IDs of every movie the user rated.
+	Rated map[string]bool
+}
+
+// NewTasteProfile builds a profile from the ratings of a user. Only ratings of
+// at least minRating shape the centroid and favourites, but every rated movie
+// is remembered so it can be excluded from recommendations.
+func NewTasteProfile(userID string, ratings []knowledgegraph.Rating, minRating float64) (*TasteProfile, error) {
+	p := &TasteProfile{
+		UserID:    userID,
+		MinRating: minRating,
+		Rated:     make(map[string]bool, len(ratings)),
+	}
+
+	var totalWeight float64
+	genreWeights := map[string]float64{}
+	for _, rating := range ratings {
+		p.Rated[rating.Movie.MovieID] = true
+		if rating.Rating < minRating || len(rating.Movie.Embedding) == 0 {
+			continue
+		}
+		if p.Centroid == nil {
+			p.Centroid = make([]float32, len(rating.Movie.Embedding))
+		}
+		if len(rating.Movie.Embedding) != len(p.Centroid) {
+			return nil, fmt.Errorf("embedding of movie %s has %d dimensions, expected %d", rating.Movie.MovieID, len(rating.Movie.Embedding), len(p.Centroid))
+		}
+
+		embedding := utils.Normalise(rating.Movie.Embedding)
+		for i, v := range embedding {
+			p.Centroid[i] += v * float32(rating.Rating)
+		}
+		totalWeight += rating.Rating
+
+		p.Favourites = append(p.Favourites, rating)
+		for _, genre := range rating.Movie.Genres {
+			genreWeights[genre] += rating.Rating
+		}
+	}
+	if totalWeight == 0 {
+		return nil, fmt.Errorf("failed to build taste profile for user %s: %w", userID, ErrNoHighRatings)
+	}
+	for i := range p.Centroid {
+		p.Centroid[i] /= float32(totalWeight)
+	}
+
+	sort.SliceStable(p.Favourites, func(i, j int) bool {
+		return p.Favourites[i].Rating > p.Favourites[j].Rating
+	})
+	for genre := range genreWeights {
+		p.TopGenres = append(p.TopGenres, genre)
+	}
+	sort.Slice(p.TopGenres, func(i, j int) bool {
+		wi, wj := genreWeights[p.TopGenres[i]], genreWeights[p.TopGenres[j]]
+		if wi != wj {
+			return wi > wj
+		}
+		return p.TopGenres[i] < p.TopGenres[j]
+	})
+
+	return p, nil
+}
+
+// Bias blends a query embedding with the centroid of the profile. A weight of
+// 0 returns the query unchanged and a weight of 1 ignores the query.
+func (p *TasteProfile) Bias(query []float32, weight float64) []float32 {
+	if len(query) != len(p.Centroid) || weight <= 0 {
+		return query
+	}
+	q := utils.Normalise(query)
+	c := utils.Normalise(p.Centroid)
+	biased := make([]float32, len(q))
+	for i := range q {
+		biased[i] = float32(1-weight)*q[i] + float32(weight)*c[i]
+	}
+	return biased
+}
+
+// Exclude drops the movies the user already rated.
+func (p *TasteProfile) Exclude(movies []knowledgegraph.Movie) []knowledgegraph.Movie {
+	var unseen []knowledgegraph.Movie
+	for _, movie := range movies {
+		if p.Rated[movie.MovieID] {
+			continue
+		}
+		unseen = append(unseen, movie)
+	}
+	return unseen
+}
+
+// Summary describes the profile in a few lines of text for the prompt.
+func (p *TasteProfile) Summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "The user rated %d movies, %d of them %.1f or higher.\n", len(p.Rated), len(p.Favourites), p.MinRating)
+
+	var favourites []string
+	for _, rating := range p.Favourites[:min(maxFavourites, len(p.Favourites))] {
+		favourites = append(favourites, fmt.Sprintf("%s (%.1f)", rating.Movie.Title, rating.Rating))
+	}
+	fmt.Fprintf(&b, "Favourite movies: %s\n", strings.Join(favourites, ", "))
+
+	if len(p.TopGenres) > 0 {
+		fmt.Fprintf(&b, "Favourite genres: %s\n", strings.Join(p.TopGenres[:min(maxGenres, len(p.TopGenres))], ", "))
+	}
+	return b.String()
+}
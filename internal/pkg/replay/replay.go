@@ -0,0 +1,259 @@
+// Package replay records HTTP exchanges with Ollama and the embeddings
+// service to fixture files and replays them, so the clients can be tested
+// without the services.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ErrNoFixture is wrapped by errors for requests without a recorded fixture.
+var ErrNoFixture = errors.New("no fixture recorded")
+
+type Mode string
+
+const (
+	// Record sends requests to the server and saves the exchanges.
+	Record Mode = "record"
+	// Replay answers requests from the fixtures, without a server.
+	Replay Mode = "replay"
+)
+
+// Transport is an http.RoundTripper that records or replays exchanges.
+// Requests match a fixture on their method and path, the model in their body
+// and a hash of their body, which holds the prompt and options. The host is
+// ignored, so fixtures replay against any address.
+type Transport struct {
+	Mode Mode
+	Dir  string
+	// Next sends the requests to record; nil uses http.DefaultTransport.
+	Next http.RoundTripper
+}
+
+var _ http.RoundTripper = (*Transport)(nil)
+
+func NewTransport(mode Mode, dir string) (*Transport, error) {
+	if mode != Record && mode != Replay {
+		return nil, fmt.Errorf("unknown replay mode %q, use record or replay", mode)
+	}
+	return &Transport{Mode: mode, Dir: dir}, nil
+}
+
+// Client returns an http.Client that uses the transport.
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+// Fixture is a recorded exchange. A streamed NDJSON body is kept as a list of
+// chunks, a JSON body as JSON and anything else as text, so fixtures can be
+// read and edited by hand.
+type Fixture struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Model   string            `json:"model,omitempty"`
+	Request json.RawMessage   `json:"request,omitempty"`
+	Status  int               `json:"status"`
+	Header  map[string]string `json:"header,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+	Chunks  []json.RawMessage `json:"chunks,omitempty"`
+	Text    string            `json:"text,omitempty"`
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	model := modelOf(body)
+	path := filepath.Join(t.Dir, fixtureName(req.Method, req.URL.Path, model, body))
+
+	if t.Mode == Replay {
+		fixture, err := load(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w for %s %s (model %q): %s", ErrNoFixture, req.Method, req.URL.Path, model, path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return fixture.response(req), nil
+	}
+
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	fixture := newFixture(req, model, body, resp, respBody)
+	err = fixture.save(path)
+	if err != nil {
+		return nil, err
+	}
+	return fixture.response(req), nil
+}
+
+func newFixture(req *http.Request, model string, body []byte, resp *http.Response, respBody []byte) Fixture {
+	fixture := Fixture{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Model:  model,
+		Status: resp.StatusCode,
+	}
+	if json.Valid(body) {
+		fixture.Request = body
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		fixture.Header = map[string]string{"Content-Type": contentType}
+	}
+
+	trimmed := bytes.TrimSpace(respBody)
+	switch {
+	case json.Valid(trimmed) && len(trimmed) > 0:
+		fixture.Body = trimmed
+	case isNDJSON(trimmed):
+		for _, line := range bytes.Split(trimmed, []byte("\n")) {
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				fixture.Chunks = append(fixture.Chunks, line)
+			}
+		}
+	default:
+		fixture.Text = string(respBody)
+	}
+	return fixture
+}
+
+// isNDJSON reports whether every non-empty line of the body is JSON.
+func isNDJSON(body []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	lines := 0
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			return false
+		}
+		lines++
+	}
+	return scanner.Err() == nil && lines > 0
+}
+
+// response rebuilds the recorded response. JSON is compacted from the indented
+// fixture, and chunks are written one per line, as Ollama streams them.
+func (f Fixture) response(req *http.Request) *http.Response {
+	var body []byte
+	switch {
+	case f.Chunks != nil:
+		var buf bytes.Buffer
+		for _, chunk := range f.Chunks {
+			if json.Compact(&buf, chunk) != nil {
+				buf.Write(chunk)
+			}
+			buf.WriteByte('\n')
+		}
+		body = buf.Bytes()
+	case f.Body != nil:
+		var buf bytes.Buffer
+		if json.Compact(&buf, f.Body) != nil {
+			buf.Write(f.Body)
+		}
+		body = buf.Bytes()
+	default:
+		body = []byte(f.Text)
+	}
+	header := http.Header{}
+	for key, value := range f.Header {
+		header.Set(key, value)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)),
+		StatusCode:    f.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func load(path string) (Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Fixture{}, err
+	}
+	var fixture Fixture
+	err = json.Unmarshal(data, &fixture)
+	if err != nil {
+		return Fixture{}, fmt.Errorf("failed to parse fixture %s: %w", path, err)
+	}
+	return fixture, nil
+}
+
+func (f Fixture) save(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// modelOf returns the model of a JSON request body, or "".
+func modelOf(body []byte) string {
+	var request struct {
+		Model string `json:"model"`
+	}
+	_ = json.Unmarshal(body, &request)
+	return request.Model
+}
+
+var unsafeName = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// fixtureName names the fixture of a request after its path, model and a hash
+// of its method and body. JSON bodies are hashed in a canonical form, so the
+// order of their fields doesn't matter.
+func fixtureName(method, path, model string, body []byte) string {
+	var value any
+	if json.Unmarshal(body, &value) == nil {
+		body, _ = json.Marshal(value)
+	}
+	hash := sha256.Sum256(append([]byte(method+" "), body...))
+	name := strings.Trim(unsafeName.ReplaceAllString(path, "-"), "-")
+	if model != "" {
+		name += "-" + strings.Trim(unsafeName.ReplaceAllString(model, "-"), "-")
+	}
+	return fmt.Sprintf("%s-%s.json", name, hex.EncodeToString(hash[:])[:12])
+}
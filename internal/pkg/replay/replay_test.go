@@ -0,0 +1,170 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/embeddings"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/ollama"
+)
+
+// The fixtures in testdata were recorded from the clients below. The host
+// doesn't exist: replay must not need the server.
+const replayHost = "http://replay.invalid:11434"
+
+func replayClient(t *testing.T) *http.Client {
+	t.Helper()
+	transport, err := NewTransport(Replay, "testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transport.Client()
+}
+
+func TestReplayGenerateStream(t *testing.T) {
+	client := ollama.NewOllama("llama2", replayHost)
+	client.HTTPClient = replayClient(t)
+
+	stream, err := client.GenerateStream(context.Background(), "Which movie has toys?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	var texts []string
+	var done bool
+	for stream.Next() {
+		chunk := stream.Chunk()
+		texts = append(texts, chunk.Text)
+		if chunk.Done {
+			done = true
+			if chunk.Usage == nil || chunk.Usage.PromptTokens != 12 || chunk.Usage.CompletionTokens != 3 {
+				t.Errorf("usage = %+v, want 12 prompt and 3 completion tokens", chunk.Usage)
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Watch ", "Toy Story.", ""}
+	if !reflect.DeepEqual(texts, want) || !done {
+		t.Errorf("chunks = %q (done %v), want %q", texts, done, want)
+	}
+}
+
+func TestReplayGenerate(t *testing.T) {
+	client := ollama.NewOllama("llama2", replayHost)
+	client.HTTPClient = replayClient(t)
+
+	answer, err := client.Generate(context.Background(), "Which movie has toys?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != "Toy Story." {
+		t.Errorf("answer = %q, want %q", answer, "Toy Story.")
+	}
+	if usage := client.Usage(); usage.PromptTokens != 12 || usage.CompletionTokens != 3 {
+		t.Errorf("usage = %s, want 12 prompt and 3 completion tokens", usage)
+	}
+}
+
+func TestReplayEmbedding(t *testing.T) {
+	service := embeddings.NewEmbeddings("all-MiniLM-L6-v2", "http://other.invalid:8000")
+	service.HTTPClient = replayClient(t)
+
+	embedding, err := service.Embedding(context.Background(), "toys come to life")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float32{0.6, 0.8, 0}
+	if !reflect.DeepEqual(embedding.Embedding, want) {
+		t.Errorf("embedding = %v, want %v", embedding.Embedding, want)
+	}
+}
+
+func TestReplayMissingFixture(t *testing.T) {
+	client := ollama.NewOllama("llama2", replayHost)
+	client.HTTPClient = replayClient(t)
+
+	_, err := client.Generate(context.Background(), "a prompt that was never recorded")
+	if !errors.Is(err, ErrNoFixture) {
+		t.Errorf("err = %v, want ErrNoFixture", err)
+	}
+}
+
+func TestRecordReplaysStreamByteForByte(t *testing.T) {
+	const body = "{\"response\":\"Watch \",\"done\":false}\n{\"response\":\"Heat.\",\"done\":false}\n{\"response\":\"\",\"done\":true,\"eval_count\":2}\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	post := func(mode Mode, url string) string {
+		t.Helper()
+		transport, err := NewTransport(mode, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := transport.Client().Post(url+"/api/generate", "application/json", strings.NewReader(`{"model":"llama2","prompt":"heist","stream":true}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.Header.Get("Content-Type"); got != "application/x-ndjson" {
+			t.Errorf("%s: Content-Type = %q", mode, got)
+		}
+		return string(data)
+	}
+
+	if recorded := post(Record, server.URL); recorded != body {
+		t.Errorf("recorded body = %q, want %q", recorded, body)
+	}
+	server.Close()
+	if replayed := post(Replay, "http://elsewhere.invalid"); replayed != body {
+		t.Errorf("replayed body = %q, want %q", replayed, body)
+	}
+}
+
+func TestFixtureName(t *testing.T) {
+	base := fixtureName("POST", "/api/generate", "llama2", []byte(`{"model":"llama2","prompt":"toys","stream":true}`))
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		same   bool
+	}{
+		{"field order", "POST", "/api/generate", `{"stream":true,"prompt":"toys","model":"llama2"}`, true},
+		{"whitespace", "POST", "/api/generate", "{\"model\": \"llama2\",\n\"prompt\": \"toys\", \"stream\": true}", true},
+		{"prompt", "POST", "/api/generate", `{"model":"llama2","prompt":"heist","stream":true}`, false},
+		{"option", "POST", "/api/generate", `{"model":"llama2","prompt":"toys","stream":false}`, false},
+		{"endpoint", "POST", "/api/chat", `{"model":"llama2","prompt":"toys","stream":true}`, false},
+		{"method", "PUT", "/api/generate", `{"model":"llama2","prompt":"toys","stream":true}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := fixtureName(tt.method, tt.path, "llama2", []byte(tt.body))
+			if (name == base) != tt.same {
+				t.Errorf("fixtureName = %s, base %s, want same %v", name, base, tt.same)
+			}
+		})
+	}
+}
+
+func TestNewTransportRejectsUnknownMode(t *testing.T) {
+	_, err := NewTransport("rewind", "testdata")
+	if err == nil {
+		t.Error("NewTransport accepted mode rewind")
+	}
+}
@@ -0,0 +1,70 @@
+package rerank
+
+import (
+	"sort"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+)
+
+// Blend merges vector search candidates with collaborative-filtering
+// candidates. The SimilarityScore of the first and the CollaborativeScore of
+// the second list are min-max normalised, and every movie is ordered by
+//
+//	(1 - ratio) * similarity + ratio * collaborative
+//
+// so a ratio of 0 keeps the vector order and a ratio of 1 the collaborative
+// order. A movie found by both keeps both scores.
+func Blend(vector, collaborative []knowledgegraph.Movie, ratio float64) []knowledgegraph.Movie {
+	similarity := normaliser(vector, func(m knowledgegraph.Movie) float64 { return m.SimilarityScore })
+	cf := normaliser(collaborative, func(m knowledgegraph.Movie) float64 { return m.CollaborativeScore })
+
+	blended := make([]knowledgegraph.Movie, 0, len(vector)+len(collaborative))
+	index := map[string]int{}
+	for _, movie := range vector {
+		index[movie.MovieID] = len(blended)
+		blended = append(blended, movie)
+	}
+	for _, movie := range collaborative {
+		if i, ok := index[movie.MovieID]; ok {
+			blended[i].CollaborativeScore = movie.CollaborativeScore
+			continue
+		}
+		index[movie.MovieID] = len(blended)
+		blended = append(blended, movie)
+	}
+
+	scores := make(map[string]float64, len(blended))
+	for _, movie := range blended {
+		var score float64
+		if movie.SimilarityScore != 0 {
+			score += (1 - ratio) * similarity(movie.SimilarityScore)
+		}
+		if movie.CollaborativeScore != 0 {
+			score += ratio * cf(movie.CollaborativeScore)
+		}
+		scores[movie.MovieID] = score
+	}
+	sort.SliceStable(blended, func(i, j int) bool {
+		return scores[blended[i].MovieID] > scores[blended[j].MovieID]
+	})
+	return blended
+}
+
+// normaliser returns a function that maps a score onto [0, 1] using the lowest
+// and highest score of the movies. When all scores are equal they map to 1.
+func normaliser(movies []knowledgegraph.Movie, score func(knowledgegraph.Movie) float64) func(float64) float64 {
+	if len(movies) == 0 {
+		return func(float64) float64 { return 0 }
+	}
+	lo, hi := score(movies[0]), score(movies[0])
+	for _, movie := range movies[1:] {
+		lo = min(lo, score(movie))
+		hi = max(hi, score(movie))
+	}
+	return func(s float64) float64 {
+		if hi == lo {
+			return 1
+		}
+		return (s - lo) / (hi - lo)
+	}
+}
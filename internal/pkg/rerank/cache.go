@@ -0,0 +1,74 @@
+package rerank
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+// Cache stores relevance scores per (model, mode, query, movie) so repeated
+// queries don't ask the LLM to score the same pairs again.
+type Cache struct {
+	mu     sync.Mutex
+	scores map[string]float64
+}
+
+func NewCache() *Cache {
+	return &Cache{
+		scores: map[string]float64{},
+	}
+}
+
+// LoadCache reads a cache written by Save. A missing file results in an
+// empty cache.
+func LoadCache(path string) (*Cache, error) {
+	c := NewCache()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &c.scores)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Save writes the cache to path as JSON.
+func (c *Cache) Save(path string) error {
+	c.mu.Lock()
+	data, err := json.Marshal(c.scores)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func (c *Cache) get(key string) (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	score, ok := c.scores[key]
+	return score, ok
+}
+
+func (c *Cache) set(key string, score float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scores[key] = score
+}
+
+func cacheKey(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
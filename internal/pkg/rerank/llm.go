@@ -0,0 +1,265 @@
+package rerank
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/llm"
+)
+
+type Mode string
+
+const (
+	// Pointwise asks the LLM to score every (query, plot) pair on its own.
+	Pointwise Mode = "pointwise"
+	// Listwise asks the LLM to score a window of plots in one prompt.
+	Listwise Mode = "listwise"
+)
+
+// listwiseWindow is the number of plots scored in a single listwise prompt.
+const listwiseWindow = 10
+
+// maxScore is the top of the relevance scale the LLM is asked to use.
+const maxScore = 10
+
+// LLMReranker re-orders candidates by relevance scores from an LLM.
+type LLMReranker struct {
+	llm         llm.LLM
+	model       string
+	mode        Mode
+	concurrency int
+	cache       *Cache
+}
+
+// NewLLMReranker creates a reranker that scores candidates with llm. The model
+// name is only used to key the cache. At most concurrency prompts are sent to
+// the LLM at the same time.
+func NewLLMReranker(llm llm.LLM, model string, mode Mode, concurrency int, cache *Cache) (*LLMReranker, error) {
+	if mode != Pointwise && mode != Listwise {
+		return nil, fmt.Errorf("unknown rerank mode %q", mode)
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if cache == nil {
+		cache = NewCache()
+	}
+	return &LLMReranker{
+		llm:         llm,
+		model:       model,
+		mode:        mode,
+		concurrency: concurrency,
+		cache:       cache,
+	}, nil
+}
+
+// Rerank scores the candidates for the query and returns the k best. The
+// score is stored in RerankScore, next to the original SimilarityScore, which
+// also breaks ties. Candidates the LLM fails to score get their similarity
+// scaled to the rerank scale instead, so one bad answer doesn't fail the
+// search.
+func (r *LLMReranker) Rerank(ctx context.Context, query string, candidates []knowledgegraph.Movie, k int) ([]knowledgegraph.Movie, error) {
+	movies := make([]knowledgegraph.Movie, len(candidates))
+	copy(movies, candidates)
+
+	// Only the candidates without a cached score are sent to the LLM.
+	var pending []int
+	for i, movie := range movies {
+		score, ok := r.cache.get(r.key(query, movie))
+		if ok {
+			movies[i].RerankScore = score
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	var batches [][]int
+	if r.mode == Pointwise {
+		for _, i := range pending {
+			batches = append(batches, []int{i})
+		}
+	} else {
+		for start := 0; start < len(pending); start += listwiseWindow {
+			batches = append(batches, pending[start:min(start+listwiseWindow, len(pending))])
+		}
+	}
+
+	var (
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+	)
+	sem := make(chan struct{}, r.concurrency)
+	for _, batch := range batches {
+		wg.Add(1)
+		go func(batch []int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			batchMovies := make([]knowledgegraph.Movie, len(batch))
+			for j, i := range batch {
+				batchMovies[j] = movies[i]
+			}
+			scores, err := r.score(ctx, query, batchMovies)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				return
+			}
+			for j, i := range batch {
+				score, ok := scores[j]
+				if !ok {
+					movies[i].RerankScore = fallbackScore(movies[i])
+					continue
+				}
+				movies[i].RerankScore = score
+				r.cache.set(r.key(query, movies[i]), score)
+			}
+		}(batch)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("failed to rerank candidates: %w", errors.Join(errs...))
+	}
+
+	sort.SliceStable(movies, func(i, j int) bool {
+		if movies[i].RerankScore != movies[j].RerankScore {
+			return movies[i].RerankScore > movies[j].RerankScore
+		}
+		return movies[i].SimilarityScore > movies[j].SimilarityScore
+	})
+	if k < len(movies) {
+		movies = movies[:k]
+	}
+	return movies, nil
+}
+
+func (r *LLMReranker) key(query string, movie knowledgegraph.Movie) string {
+	return cacheKey(r.model, string(r.mode), query, movie.MovieID, movie.Plot)
+}
+
+// score returns the scores of the movies it could score, by index. Listwise
+// scoring falls back to pointwise for the movies the LLM left out, or all of
+// them when its answer fails. Movies that fail pointwise too are left out. It
+// only fails when the context is done.
+func (r *LLMReranker) score(ctx context.Context, query string, movies []knowledgegraph.Movie) (map[int]float64, error) {
+	var listwise map[string]float64
+	if r.mode == Listwise {
+		var err error
+		listwise, err = r.scoreListwise(ctx, query, movies)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			log.Printf("listwise rerank failed, scoring pointwise: %v", err)
+		}
+	}
+
+	scores := make(map[int]float64, len(movies))
+	for i, movie := range movies {
+		if score, ok := listwise[movie.MovieID]; ok {
+			scores[i] = score
+			continue
+		}
+		if r.mode == Listwise && listwise != nil {
+			log.Printf("no listwise score returned for movie %s, scoring pointwise", movie.MovieID)
+		}
+		score, err := r.scorePointwise(ctx, query, movie)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			log.Printf("failed to score movie %s, using its similarity: %v", movie.MovieID, err)
+			continue
+		}
+		scores[i] = score
+	}
+	return scores, nil
+}
+
+func (r *LLMReranker) scorePointwise(ctx context.Context, query string, movie knowledgegraph.Movie) (float64, error) {
+	prompt := fmt.Sprintf(`
+You judge how well a movie matches what a user wants to watch.
+Rate the relevance of the movie to the request on a scale from 0 (unrelated) to %d (perfect match).
+Respond with JSON only, in the form {"score": <number>}.
+
+Request: %s
+
+Title: %s
+Plot: %s
+`, maxScore, query, movie.Title, movie.Plot)
+
+	answer, err := r.llm.GenerateJSON(ctx, prompt)
+	if err != nil {
+		return 0, err
+	}
+
+	var response struct {
+		Score float64 `json:"score"`
+	}
+	err = json.Unmarshal([]byte(answer), &response)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode score for movie %s: %w", movie.MovieID, err)
+	}
+	return clampScore(response.Score), nil
+}
+
+// scoreListwise returns the scores the LLM gave by movie ID.
+func (r *LLMReranker) scoreListwise(ctx context.Context, query string, movies []knowledgegraph.Movie) (map[string]float64, error) {
+	var moviesStr strings.Builder
+	for _, movie := range movies {
+		fmt.Fprintf(&moviesStr, "ID: %s\nTitle: %s\nPlot: %s\n---\n", movie.MovieID, movie.Title, movie.Plot)
+	}
+
+	prompt := fmt.Sprintf(`
+You judge how well movies match what a user wants to watch.
+Rate the relevance of every movie below to the request on a scale from 0 (unrelated) to %d (perfect match).
+Respond with JSON only, in the form {"scores": [{"id": "<movie ID>", "score": <number>}]}, with one entry per movie.
+
+Request: %s
+
+### Movies:
+---
+%s`, maxScore, query, moviesStr.String())
+
+	answer, err := r.llm.GenerateJSON(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Scores []struct {
+			ID    string  `json:"id"`
+			Score float64 `json:"score"`
+		} `json:"scores"`
+	}
+	err = json.Unmarshal([]byte(answer), &response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode listwise scores: %w", err)
+	}
+
+	byID := make(map[string]float64, len(response.Scores))
+	for _, s := range response.Scores {
+		byID[s.ID] = clampScore(s.Score)
+	}
+	return byID, nil
+}
+
+// fallbackScore scales the similarity of a movie the LLM failed to score to
+// the rerank scale.
+func fallbackScore(movie knowledgegraph.Movie) float64 {
+	return clampScore(movie.SimilarityScore * maxScore)
+}
+
+func clampScore(score float64) float64 {
+	return max(0, min(score, maxScore))
+}
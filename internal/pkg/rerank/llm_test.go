@@ -0,0 +1,135 @@
+package rerank
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/llm"
+)
+
+// fakeLLM answers listwise prompts with listwise and pointwise prompts with
+// the answer of the movie title in the prompt, or fails for other titles.
+type fakeLLM struct {
+	llm.LLM
+	listwise  string
+	pointwise map[string]string
+
+	mu      sync.Mutex
+	prompts int
+}
+
+func (f *fakeLLM) GenerateJSON(ctx context.Context, prompt string) (string, error) {
+	f.mu.Lock()
+	f.prompts++
+	f.mu.Unlock()
+	if strings.Contains(prompt, `"scores"`) {
+		return f.listwise, nil
+	}
+	for title, answer := range f.pointwise {
+		if strings.Contains(prompt, "Title: "+title+"\n") {
+			return answer, nil
+		}
+	}
+	return "", errors.New("model not found")
+}
+
+func candidates() []knowledgegraph.Movie {
+	return []knowledgegraph.Movie{
+		{MovieID: "1", Title: "Toy Story", SimilarityScore: 0.9},
+		{MovieID: "2", Title: "Jumanji", SimilarityScore: 0.8},
+		{MovieID: "3", Title: "Heat", SimilarityScore: 0.7},
+	}
+}
+
+func TestRerank(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      Mode
+		listwise  string
+		pointwise map[string]string
+		want      map[string]float64
+	}{
+		{
+			name:     "listwise",
+			mode:     Listwise,
+			listwise: `{"scores": [{"id": "1", "score": 2}, {"id": "2", "score": 12}, {"id": "3", "score": 5}]}`,
+			want:     map[string]float64{"1": 2, "2": 10, "3": 5},
+		},
+		{
+			name:      "listwise leaves out a movie",
+			mode:      Listwise,
+			listwise:  `{"scores": [{"id": "1", "score": 2}, {"id": "3", "score": 5}]}`,
+			pointwise: map[string]string{"Jumanji": `{"score": 8}`},
+			want:      map[string]float64{"1": 2, "2": 8, "3": 5},
+		},
+		{
+			name:      "listwise answers bad JSON",
+			mode:      Listwise,
+			listwise:  `{"scores": [`,
+			pointwise: map[string]string{"Toy Story": `{"score": 1}`, "Jumanji": `{"score": 8}`, "Heat": `{"score": 3}`},
+			want:      map[string]float64{"1": 1, "2": 8, "3": 3},
+		},
+		{
+			name:      "pointwise falls back to similarity",
+			mode:      Pointwise,
+			pointwise: map[string]string{"Toy Story": `{"score": 1}`, "Jumanji": `not json`},
+			want:      map[string]float64{"1": 1, "2": 8, "3": 7},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeLLM{listwise: tt.listwise, pointwise: tt.pointwise}
+			reranker, err := NewLLMReranker(fake, "model", tt.mode, 2, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			movies, err := reranker.Rerank(context.Background(), "toys", candidates(), 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]float64{}
+			for _, movie := range movies {
+				got[movie.MovieID] = movie.RerankScore
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rerank scores = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRerankCachesOnlyLLMScores(t *testing.T) {
+	fake := &fakeLLM{pointwise: map[string]string{"Toy Story": `{"score": 1}`}}
+	reranker, err := NewLLMReranker(fake, "model", Pointwise, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		_, err := reranker.Rerank(context.Background(), "toys", candidates(), 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Toy Story is cached after the first run, the fallbacks are asked again.
+	if fake.prompts != 5 {
+		t.Errorf("prompts = %d, want 5", fake.prompts)
+	}
+}
+
+func TestRerankCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	reranker, err := NewLLMReranker(&fakeLLM{}, "model", Pointwise, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = reranker.Rerank(ctx, "toys", candidates(), 3)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Rerank error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,70 @@
+package rerank
+
+import (
+	"math"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/utils"
+)
+
+// MMR picks k movies from the candidates using Maximal Marginal Relevance.
+// Each step selects the candidate that maximises
+//
+//	lambda * sim(query, candidate) - (1 - lambda) * max sim(candidate, selected)
+//
+// so a lambda of 1 keeps the plain similarity order and a lambda of 0 only
+// cares about diversity. Candidates need their Embedding set; the ones
+// without an embedding fall back to their SimilarityScore and are never
+// penalised for being similar to the selection.
+func MMR(query []float32, candidates []knowledgegraph.Movie, k int, lambda float64) []knowledgegraph.Movie {
+	if k > len(candidates) {
+		k = len(candidates)
+	}
+
+	relevance := make([]float64, len(candidates))
+	for i, candidate := range candidates {
+		if len(candidate.Embedding) == 0 {
+			relevance[i] = candidate.SimilarityScore
+			continue
+		}
+		relevance[i] = utils.CosineSimilarity(query, candidate.Embedding)
+	}
+
+	// redundancy[i] holds the highest similarity of candidate i to any
+	// movie selected so far.
+	redundancy := make([]float64, len(candidates))
+	used := make([]bool, len(candidates))
+	selected := make([]knowledgegraph.Movie, 0, k)
+
+	for len(selected) < k {
+		best := -1
+		bestScore := math.Inf(-1)
+		for i := range candidates {
+			if used[i] {
+				continue
+			}
+			score := lambda*relevance[i] - (1-lambda)*redundancy[i]
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		used[best] = true
+		selected = append(selected, candidates[best])
+
+		for i, candidate := range candidates {
+			if used[i] || len(candidate.Embedding) == 0 || len(candidates[best].Embedding) == 0 {
+				continue
+			}
+			sim := utils.CosineSimilarity(candidate.Embedding, candidates[best].Embedding)
+			if sim > redundancy[i] {
+				redundancy[i] = sim
+			}
+		}
+	}
+
+	return selected
+}
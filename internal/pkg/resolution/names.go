@@ -0,0 +1,131 @@
+package resolution
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// honorifics are dropped from names before comparing them.
+var honorifics = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "miss": true, "dr": true,
+	"sir": true, "dame": true, "jr": true, "sr": true,
+}
+
+// nameTokens lowercases a name, strips punctuation, and drops honorifics and
+// initials, so "Thomas J. Hanks" and "thomas hanks" have the same tokens. Only
+// a single letter followed by a dot is an initial, so the numbers of sequels
+// like "Toy Story 2" and "Rocky V" are kept.
+func nameTokens(name string) []string {
+	var tokens []string
+	var field []rune
+	flush := func(next rune) {
+		token := string(field)
+		field = field[:0]
+		if token == "" || honorifics[token] {
+			return
+		}
+		if next == '.' && len([]rune(token)) == 1 && unicode.IsLetter([]rune(token)[0]) {
+			return
+		}
+		tokens = append(tokens, token)
+	}
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			field = append(field, r)
+			continue
+		}
+		flush(r)
+	}
+	flush(0)
+	return tokens
+}
+
+// romanNumeral matches the roman numerals up to 39, which covers the sequel
+// numbers without mistaking many names for numbers.
+var romanNumeral = regexp.MustCompile(`^x{0,3}(ix|iv|v?i{0,3})$`)
+
+// numeral reports whether a token is a number, like the 2 of "Toy Story 2" or
+// the v of "Rocky V".
+func numeral(token string) bool {
+	if token == "" {
+		return false
+	}
+	if romanNumeral.MatchString(token) {
+		return true
+	}
+	for _, r := range token {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalise returns the normalised form of a name.
+func normalise(name string) string {
+	return strings.Join(nameTokens(name), " ")
+}
+
+// blockKeys returns the keys under which a name is blocked: its normalised
+// form and its last token, so "Hanks", "Tom Hanks" and "Thomas J. Hanks" end
+// up in the same block.
+func blockKeys(name string) []string {
+	tokens := nameTokens(name)
+	if len(tokens) == 0 {
+		return nil
+	}
+	keys := []string{strings.Join(tokens, " ")}
+	if len(tokens) > 1 {
+		keys = append(keys, tokens[len(tokens)-1])
+	}
+	return keys
+}
+
+// compatible reports whether two names could refer to the same entity: the
+// tokens of one contain the tokens of the other, or they have the same last
+// token and first tokens starting with the same letter ("Tom Hanks" and
+// "Thomas Hanks", but not "Colin Hanks"). Names with different numbers, like
+// "Toy Story" and "Toy Story 2", are never compatible.
+func compatible(a, b string) bool {
+	ta, tb := nameTokens(a), nameTokens(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return false
+	}
+	if !sameNumerals(ta, tb) {
+		return false
+	}
+	if subset(ta, tb) || subset(tb, ta) {
+		return true
+	}
+	return ta[len(ta)-1] == tb[len(tb)-1] && []rune(ta[0])[0] == []rune(tb[0])[0]
+}
+
+// sameNumerals reports whether both token lists have the same numerals.
+func sameNumerals(a, b []string) bool {
+	var na, nb []string
+	for _, t := range a {
+		if numeral(t) {
+			na = append(na, t)
+		}
+	}
+	for _, t := range b {
+		if numeral(t) {
+			nb = append(nb, t)
+		}
+	}
+	return subset(na, nb) && subset(nb, na)
+}
+
+func subset(small, large []string) bool {
+	set := make(map[string]bool, len(large))
+	for _, t := range large {
+		set[t] = true
+	}
+	for _, t := range small {
+		if !set[t] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,417 @@
+package resolution
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/embeddings"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/llm"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/utils"
+)
+
+// maxBlockSize skips blocks that are too large to compare pairwise, like a
+// very common surname.
+const maxBlockSize = 50
+
+// Resolver groups extracted entities that refer to the same thing. Candidate
+// pairs come from blocking on normalised names, and are merged when:
+//
+//   - their normalised names are equal, or
+//   - their names are compatible and the embeddings of their descriptions are
+//     at least threshold similar, or
+//   - their names are compatible, the similarity is at least ambiguous, and
+//     the LLM confirms they are the same.
+//
+// Without an embedder only equal normalised names are merged, and without an
+// LLM ambiguous pairs are not merged.
+type Resolver struct {
+	embedder  embeddings.Embeddings
+	llm       llm.LLM
+	threshold float64
+	ambiguous float64
+}
+
+func NewResolver(embedder embeddings.Embeddings, llm llm.LLM, threshold, ambiguous float64) *Resolver {
+	return &Resolver{
+		embedder:  embedder,
+		llm:       llm,
+		threshold: threshold,
+		ambiguous: ambiguous,
+	}
+}
+
+// Decision records why an alias was merged into a canonical entity.
+type Decision struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Canonical string    `json:"canonical"`
+	Alias     string    `json:"alias"`
+	Reason    string    `json:"reason"`
+}
+
+// Group is a canonical entity with the names merged into it.
+type Group struct {
+	Type      string   `json:"type"`
+	Canonical string   `json:"canonical"`
+	Aliases   []string `json:"aliases"`
+}
+
+// Result is the outcome of resolving a set of entities.
+type Result struct {
+	Groups    []Group    `json:"groups"`
+	Decisions []Decision `json:"decisions"`
+	// canonical maps type and name of every merged entity to its canonical
+	// name.
+	canonical map[entityKey]string
+}
+
+type entityKey struct {
+	Type string
+	Name string
+}
+
+// candidate is a distinct entity with the number of times it was extracted.
+type candidate struct {
+	entity    knowledgegraph.Entity
+	mentions  int
+	embedding []float32
+}
+
+// Resolve groups the entities, which may contain the same entity many times.
+func (r *Resolver) Resolve(ctx context.Context, entities []knowledgegraph.Entity) (*Result, error) {
+	var candidates []*candidate
+	index := map[entityKey]int{}
+	for _, entity := range entities {
+		key := entityKey{entity.Type, entity.Name}
+		if i, ok := index[key]; ok {
+			candidates[i].mentions++
+			if len(entity.Description) > len(candidates[i].entity.Description) {
+				candidates[i].entity.Description = entity.Description
+			}
+			continue
+		}
+		index[key] = len(candidates)
+		candidates = append(candidates, &candidate{entity: entity, mentions: 1})
+	}
+
+	blocks := map[string][]int{}
+	for i, c := range candidates {
+		for _, key := range blockKeys(c.entity.Name) {
+			blockKey := c.entity.Type + "\x00" + key
+			blocks[blockKey] = append(blocks[blockKey], i)
+		}
+	}
+	blockKeysSorted := make([]string, 0, len(blocks))
+	for key := range blocks {
+		blockKeysSorted = append(blockKeysSorted, key)
+	}
+	sort.Strings(blockKeysSorted)
+
+	sets := newUnionFind(len(candidates))
+	reasons := map[int]string{}
+	for _, key := range blockKeysSorted {
+		block := blocks[key]
+		if len(block) < 2 || len(block) > maxBlockSize {
+			continue
+		}
+		for x := 0; x < len(block); x++ {
+			for y := x + 1; y < len(block); y++ {
+				i, j := block[x], block[y]
+				if sets.find(i) == sets.find(j) || !r.joinable(candidates, sets, i, j) {
+					continue
+				}
+				same, reason, err := r.same(ctx, candidates[i], candidates[j])
+				if err != nil {
+					return nil, err
+				}
+				if !same {
+					continue
+				}
+				sets.union(i, j)
+				// remember the reason on the less mentioned entity, which
+				// is the likelier alias
+				if candidates[i].mentions >= candidates[j].mentions {
+					reasons[j] = reason
+				} else {
+					reasons[i] = reason
+				}
+			}
+		}
+	}
+
+	return r.result(candidates, sets, reasons), nil
+}
+
+// joinable reports whether every name in the group of i is compatible with
+// every name in the group of j, so "Hanks" can't chain "Tom Hanks" and "Colin
+// Hanks" together.
+func (r *Resolver) joinable(candidates []*candidate, sets *unionFind, i, j int) bool {
+	for _, x := range sets.members(i) {
+		for _, y := range sets.members(j) {
+			a, b := candidates[x].entity.Name, candidates[y].entity.Name
+			if normalise(a) != normalise(b) && !compatible(a, b) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// same decides whether two candidates of the same type are the same entity.
+func (r *Resolver) same(ctx context.Context, a, b *candidate) (bool, string, error) {
+	if normalise(a.entity.Name) == normalise(b.entity.Name) {
+		return true, "same normalised name", nil
+	}
+	if !compatible(a.entity.Name, b.entity.Name) || r.embedder == nil {
+		return false, "", nil
+	}
+
+	for _, c := range []*candidate{a, b} {
+		if c.embedding != nil {
+			continue
+		}
+		embedding, err := r.embedder.Embedding(ctx, describe(c.entity))
+		if err != nil {
+			return false, "", err
+		}
+		c.embedding = embedding.Embedding
+	}
+	similarity := utils.CosineSimilarity(a.embedding, b.embedding)
+	if similarity >= r.threshold {
+		return true, fmt.Sprintf("description similarity %.2f", similarity), nil
+	}
+	if similarity < r.ambiguous || r.llm == nil {
+		return false, "", nil
+	}
+
+	same, err := r.adjudicate(ctx, a.entity, b.entity)
+	if err != nil {
+		return false, "", err
+	}
+	return same, fmt.Sprintf("description similarity %.2f, confirmed by LLM", similarity), nil
+}
+
+// adjudicate asks the LLM whether two entities are the same.
+func (r *Resolver) adjudicate(ctx context.Context, a, b knowledgegraph.Entity) (bool, error) {
+	prompt := fmt.Sprintf(`
+Do these two descriptions refer to the same real-world %s?
+
+A: %s
+B: %s
+
+Respond with JSON only, in the form {"same": true} or {"same": false}.
+`, a.Type, describe(a), describe(b))
+
+	answer, err := r.llm.GenerateJSON(ctx, prompt)
+	if err != nil {
+		return false, err
+	}
+	var response struct {
+		Same bool `json:"same"`
+	}
+	err = json.Unmarshal([]byte(answer), &response)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode adjudication: %w", err)
+	}
+	return response.Same, nil
+}
+
+func describe(entity knowledgegraph.Entity) string {
+	if entity.Description == "" {
+		return entity.Name
+	}
+	return fmt.Sprintf("%s: %s", entity.Name, entity.Description)
+}
+
+// result picks the canonical name of every group: the most mentioned name,
+// and the longest one on a tie.
+func (r *Resolver) result(candidates []*candidate, sets *unionFind, reasons map[int]string) *Result {
+	members := map[int][]int{}
+	for i := range candidates {
+		root := sets.find(i)
+		members[root] = append(members[root], i)
+	}
+	roots := make([]int, 0, len(members))
+	for root, group := range members {
+		if len(group) > 1 {
+			roots = append(roots, root)
+		}
+	}
+	sort.Ints(roots)
+
+	res := &Result{
+		canonical: map[entityKey]string{},
+	}
+	now := time.Now()
+	for _, root := range roots {
+		group := members[root]
+		sort.SliceStable(group, func(x, y int) bool {
+			a, b := candidates[group[x]], candidates[group[y]]
+			if a.mentions != b.mentions {
+				return a.mentions > b.mentions
+			}
+			return len(a.entity.Name) > len(b.entity.Name)
+		})
+
+		canonical := candidates[group[0]].entity
+		g := Group{
+			Type:      canonical.Type,
+			Canonical: canonical.Name,
+		}
+		for _, i := range group[1:] {
+			alias := candidates[i].entity.Name
+			g.Aliases = append(g.Aliases, alias)
+			res.canonical[entityKey{canonical.Type, alias}] = canonical.Name
+			reason := reasons[i]
+			if reason == "" {
+				reason = "transitively merged"
+			}
+			res.Decisions = append(res.Decisions, Decision{
+				Time:      now,
+				Type:      canonical.Type,
+				Canonical: canonical.Name,
+				Alias:     alias,
+				Reason:    reason,
+			})
+		}
+		res.Groups = append(res.Groups, g)
+	}
+	return res
+}
+
+// Canonical returns the canonical name of an entity.
+func (res *Result) Canonical(entityType, name string) string {
+	if canonical, ok := res.canonical[entityKey{entityType, name}]; ok {
+		return canonical
+	}
+	return name
+}
+
+// Aliases returns the names merged into the canonical entity.
+func (res *Result) Aliases(entityType, canonical string) []string {
+	for _, g := range res.Groups {
+		if g.Type == entityType && g.Canonical == canonical {
+			return g.Aliases
+		}
+	}
+	return nil
+}
+
+// Apply rewrites entities and relations to their canonical names, sets the
+// aliases of canonical entities and drops the duplicates that result.
+func (res *Result) Apply(entities []knowledgegraph.Entity, relations []knowledgegraph.Relation) ([]knowledgegraph.Entity, []knowledgegraph.Relation) {
+	var resolved []knowledgegraph.Entity
+	index := map[entityKey]int{}
+	for _, entity := range entities {
+		entity.Name = res.Canonical(entity.Type, entity.Name)
+		key := entityKey{entity.Type, entity.Name}
+		if i, ok := index[key]; ok {
+			if len(entity.Description) > len(resolved[i].Description) {
+				resolved[i].Description = entity.Description
+			}
+			continue
+		}
+		entity.Aliases = res.Aliases(entity.Type, entity.Name)
+		index[key] = len(resolved)
+		resolved = append(resolved, entity)
+	}
+
+	var resolvedRelations []knowledgegraph.Relation
+	seen := map[knowledgegraph.Relation]bool{}
+	for _, relation := range relations {
+		relation.Source = res.Canonical(relation.SourceType, relation.Source)
+		relation.Target = res.Canonical(relation.TargetType, relation.Target)
+		if seen[relation] {
+			continue
+		}
+		seen[relation] = true
+		resolvedRelations = append(resolvedRelations, relation)
+	}
+	return resolved, resolvedRelations
+}
+
+// AppendAuditLog appends every merge decision to the file as a JSON line.
+func (res *Result) AppendAuditLog(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, decision := range res.Decisions {
+		err := encoder.Encode(decision)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Report writes a human-readable summary of the groups and why they were
+// merged.
+func (res *Result) Report(w io.Writer) error {
+	if len(res.Groups) == 0 {
+		_, err := fmt.Fprintln(w, "no entities to merge")
+		return err
+	}
+	reasons := map[entityKey]string{}
+	for _, decision := range res.Decisions {
+		reasons[entityKey{decision.Type, decision.Alias}] = decision.Reason
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d groups of entities to merge:\n", len(res.Groups))
+	for _, g := range res.Groups {
+		fmt.Fprintf(&b, "%s %q\n", g.Type, g.Canonical)
+		for _, alias := range g.Aliases {
+			fmt.Fprintf(&b, "  <- %q (%s)\n", alias, reasons[entityKey{g.Type, alias}])
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type unionFind struct {
+	parent []int
+	// groups holds the members of every root.
+	groups map[int][]int
+}
+
+func newUnionFind(n int) *unionFind {
+	parent := make([]int, n)
+	groups := make(map[int][]int, n)
+	for i := range parent {
+		parent[i] = i
+		groups[i] = []int{i}
+	}
+	return &unionFind{parent: parent, groups: groups}
+}
+
+func (u *unionFind) find(i int) int {
+	for u.parent[i] != i {
+		u.parent[i] = u.parent[u.parent[i]]
+		i = u.parent[i]
+	}
+	return i
+}
+
+func (u *unionFind) members(i int) []int {
+	return u.groups[u.find(i)]
+}
+
+func (u *unionFind) union(i, j int) {
+	ri, rj := u.find(i), u.find(j)
+	if ri == rj {
+		return
+	}
+	u.parent[ri] = rj
+	u.groups[rj] = append(u.groups[rj], u.groups[ri]...)
+	delete(u.groups, ri)
+}
@@ -0,0 +1,142 @@
+package resolution
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/embeddings"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+)
+
+// fakeEmbedder embeds the texts it knows; others fail.
+type fakeEmbedder map[string][]float32
+
+func (e fakeEmbedder) Embedding(ctx context.Context, prompt string) (embeddings.Embedding, error) {
+	vector, ok := e[prompt]
+	if !ok {
+		return embeddings.Embedding{}, errors.New("unknown prompt")
+	}
+	return embeddings.Embedding{Embedding: vector}, nil
+}
+
+func TestNormalise(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Tom Hanks", "tom hanks"},
+		{"Thomas J. Hanks", "thomas hanks"},
+		{"Dr. Thomas Hanks Jr.", "thomas hanks"},
+		{"Louis C.K.", "louis"},
+		{"Toy Story 2", "toy story 2"},
+		{"Alien 3", "alien 3"},
+		{"Rocky V", "rocky v"},
+		{"Thomas J Hanks", "thomas j hanks"},
+		{"...", ""},
+	}
+	for _, tt := range tests {
+		got := normalise(tt.name)
+		if got != tt.want {
+			t.Errorf("normalise(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"Tom Hanks", "Hanks", true},
+		{"Tom Hanks", "Thomas J. Hanks", true},
+		{"Tom Hanks", "Colin Hanks", false},
+		{"Toy Story", "Toy Story 2", false},
+		{"Toy Story 2", "Toy Story 3", false},
+		{"Alien", "Alien 3", false},
+		{"Rocky", "Rocky V", false},
+		{"Rocky IV", "Rocky V", false},
+		{"Toy Story 2", "Disney's Toy Story 2", true},
+		{"Tom Hanks", "", false},
+	}
+	for _, tt := range tests {
+		got := compatible(tt.a, tt.b)
+		if got != tt.want {
+			t.Errorf("compatible(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	entity := func(typ, name string) knowledgegraph.Entity {
+		return knowledgegraph.Entity{Type: typ, Name: name}
+	}
+	entities := []knowledgegraph.Entity{
+		entity("Person", "Tom Hanks"),
+		entity("Person", "Tom Hanks"),
+		entity("Person", "tom hanks"),
+		entity("Person", "Thomas Hanks"),
+		entity("Person", "Colin Hanks"),
+		entity("Movie", "Toy Story"),
+		entity("Movie", "Toy Story"),
+		entity("Movie", "Toy Story 2"),
+		entity("Movie", "Alien"),
+		entity("Movie", "Alien 3"),
+		entity("Movie", "Rocky"),
+		entity("Movie", "Rocky V"),
+		entity("Genre", "Comedy"),
+	}
+	// Every description is alike, so only the names keep the entities apart.
+	embedder := fakeEmbedder{}
+	for _, e := range entities {
+		embedder[e.Name] = []float32{1, 0}
+	}
+
+	res, err := NewResolver(embedder, nil, 0.9, 0.8).Resolve(context.Background(), entities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Group{
+		{Type: "Person", Canonical: "Tom Hanks", Aliases: []string{"Thomas Hanks", "tom hanks"}},
+	}
+	if !reflect.DeepEqual(res.Groups, want) {
+		t.Errorf("Resolve groups = %+v, want %+v", res.Groups, want)
+	}
+	reasons := map[string]string{}
+	for _, d := range res.Decisions {
+		reasons[d.Alias] = d.Reason
+	}
+	wantReasons := map[string]string{
+		"tom hanks":    "same normalised name",
+		"Thomas Hanks": "description similarity 1.00",
+	}
+	if !reflect.DeepEqual(reasons, wantReasons) {
+		t.Errorf("Resolve reasons = %v, want %v", reasons, wantReasons)
+	}
+	for _, name := range []string{"Toy Story 2", "Alien 3", "Rocky V"} {
+		if got := res.Canonical("Movie", name); got != name {
+			t.Errorf("Canonical(%q) = %q, want the sequel kept apart", name, got)
+		}
+	}
+}
+
+func TestResolveWithoutEmbedder(t *testing.T) {
+	entities := []knowledgegraph.Entity{
+		{Type: "Person", Name: "Tom Hanks"},
+		{Type: "Person", Name: "Thomas Hanks"},
+		{Type: "Person", Name: "Mr. Tom Hanks"},
+		{Type: "Movie", Name: "Toy Story"},
+		{Type: "Movie", Name: "Toy Story 2"},
+	}
+	res, err := NewResolver(nil, nil, 0.9, 0.8).Resolve(context.Background(), entities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Group{
+		{Type: "Person", Canonical: "Mr. Tom Hanks", Aliases: []string{"Tom Hanks"}},
+	}
+	if !reflect.DeepEqual(res.Groups, want) {
+		t.Errorf("Resolve groups = %+v, want %+v", res.Groups, want)
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"math"
 	"strings"
 )
 
@@ -12,3 +13,60 @@ func Float32SliceToString(slice []float32) string {
 	}
 	return strings.Join(strs, ",")
 }
+
+// AnySliceToFloat32 converts a list property as returned by the neo4j driver
+// (a []any of float64) into a []float32.
+func AnySliceToFloat32(slice []any) []float32 {
+	floats := make([]float32, 0, len(slice))
+	for _, v := range slice {
+		switch f := v.(type) {
+		case float64:
+			floats = append(floats, float32(f))
+		case float32:
+			floats = append(floats, f)
+		case int64:
+			floats = append(floats, float32(f))
+		}
+	}
+	return floats
+}
+
+// CosineSimilarity returns the cosine similarity of two vectors. It returns 0
+// when the vectors differ in length or one of them is all zeros.
+func CosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	normA, normB := Norm(a), Norm(b)
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot / (normA * normB)
+}
+
+// Norm returns the Euclidean length of a vector.
+func Norm(vector []float32) float64 {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+	return math.Sqrt(sum)
+}
+
+// Normalise returns a copy of the vector scaled to length 1, or all zeros when
+// the vector is all zeros.
+func Normalise(vector []float32) []float32 {
+	normalised := make([]float32, len(vector))
+	norm := Norm(vector)
+	if norm == 0 {
+		return normalised
+	}
+	for i, v := range vector {
+		normalised[i] = float32(float64(v) / norm)
+	}
+	return normalised
+}
@@ -1,67 +1,189 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
+	"github.com/blogem/knowledge-graph-rag/cmd"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/chunking"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/citations"
 	"github.com/blogem/knowledge-graph-rag/internal/pkg/embeddings"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/eval"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/extraction"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/hnsw"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/ingest"
 	"github.com/blogem/knowledge-graph-rag/internal/pkg/knowledgegraph"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/llm"
 	"github.com/blogem/knowledge-graph-rag/internal/pkg/ollama"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/openai"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/packing"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/profile"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/replay"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/rerank"
+	"github.com/blogem/knowledge-graph-rag/internal/pkg/resolution"
 	"github.com/blogem/knowledge-graph-rag/internal/pkg/utils"
 )
 
-type LLMResponse struct {
-	Model              string    `json:"model"`
-	CreatedAt          time.Time `json:"created_at"`
-	Response           string    `json:"response"`
-	Done               bool      `json:"done"`
-	Context            []int     `json:"context"`
-	TotalDuration      int64     `json:"total_duration"`
-	LoadDuration       int       `json:"load_duration"`
-	PromptEvalCount    int       `json:"prompt_eval_count"`
-	PromptEvalDuration int64     `json:"prompt_eval_duration"`
-	EvalCount          int       `json:"eval_count"`
-	EvalDuration       int64     `json:"eval_duration"`
+// setupLLM returns the LLM of LLM_PROVIDER: ollama (default) or openai, for
+// servers with an OpenAI compatible API. LLM_HOST of openai is the base URL
+// including the version, and LLM_API_KEY is sent as bearer token. With
+// LLM_FALLBACK, LLM_SHORT_MODEL or LLM_TIMEOUT set, it returns a router over
+// the LLMs; see setupRouter.
+func setupLLM() (llm.LLM, string) {
+	config := llmConfig()
+	client, err := llm.New(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	routed := os.Getenv("LLM_FALLBACK") != "" || os.Getenv("LLM_SHORT_MODEL") != "" || os.Getenv("LLM_TIMEOUT") != ""
+	if ollamaClient, ok := client.(*ollama.Client); ok {
+		err := checkOllamaModel(ollamaClient, config.Model, true)
+		if err != nil && !routed {
+			log.Fatal(err)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	if !routed {
+		return client, config.Model
+	}
+	return setupRouter(config, client), config.Model
 }
 
-type Chunk struct {
-	Model     string    `json:"model"`
-	CreatedAt time.Time `json:"created_at"`
-	Response  string    `json:"response"`
-	Done      bool      `json:"done"`
+// llmConfig returns the LLM of LLM_PROVIDER, LLM_MODEL, LLM_HOST and
+// LLM_API_KEY, with the defaults of the provider for what is not set.
+// LLM_JSON_MODE=true tells openai that the server supports response_format.
+func llmConfig() llm.Config {
+	config := llm.Config{
+		Provider:   os.Getenv("LLM_PROVIDER"),
+		Model:      os.Getenv("LLM_MODEL"),
+		Address:    os.Getenv("LLM_HOST"),
+		APIKey:     os.Getenv("LLM_API_KEY"),
+		HTTPClient: httpClient(),
+	}
+	if config.Provider == "" {
+		config.Provider = "ollama"
+	}
+	if config.Model == "" {
+		fmt.Println("LLM_MODEL not set, using default model")
+	}
+	if config.Address == "" {
+		fmt.Println("LLM_HOST not set, using default host")
+	}
+	if jsonModeEnv := os.Getenv("LLM_JSON_MODE"); jsonModeEnv != "" {
+		var err error
+		config.JSONMode, err = strconv.ParseBool(jsonModeEnv)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	config, err := llm.WithDefaults(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return config
 }
 
-type LLMRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	Stream bool   `json:"stream"`
+// checkOllamaModel checks that the model is pulled and logs its context length
+// and parameters. With warmUp the model is loaded in the background, while
+// the app retrieves the context for the prompt.
+func checkOllamaModel(client *ollama.Client, model string, warmUp bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	info, err := cmd.CheckModel(ctx, client, model)
+	if err != nil {
+		return err
+	}
+	log.Printf("model %s: %s", model, info)
+	if warmUp {
+		go func() {
+			err := client.WarmUp(context.Background(), model)
+			if err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+	return nil
 }
 
-func setupLLM() ollama.LLM {
-	model := os.Getenv("LLM_MODEL")
-	if model == "" {
-		fmt.Println("LLM_MODEL not set, using default model")
-		model = "llama2"
+// setupRouter returns a router that tries the configured LLM first and then
+// the comma-separated backends of LLM_FALLBACK, each as
+// [provider:]model[@host]. Prompts of at most LLM_SHORT_PROMPT_LENGTH bytes
+// (default 2000) go to LLM_SHORT_MODEL first. LLM_TIMEOUT, like 30s, bounds
+// every request to a backend.
+func setupRouter(config llm.Config, client llm.LLM) *llm.Router {
+	var timeout time.Duration
+	if timeoutEnv := os.Getenv("LLM_TIMEOUT"); timeoutEnv != "" {
+		var err error
+		timeout, err = time.ParseDuration(timeoutEnv)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
-	host := os.Getenv("LLM_HOST")
-	if host == "" {
-		fmt.Println("LLM_HOST not set, using default host")
-		host = "http://localhost:11434"
+
+	backends := []llm.Backend{{Name: config.String(), LLM: client, Timeout: timeout}}
+	if fallbackEnv := os.Getenv("LLM_FALLBACK"); fallbackEnv != "" {
+		for _, spec := range strings.Split(fallbackEnv, ",") {
+			backends = append(backends, setupBackend(spec, config, timeout))
+		}
+	}
+
+	var short *llm.Backend
+	shortLength := 2000
+	if shortEnv := os.Getenv("LLM_SHORT_MODEL"); shortEnv != "" {
+		backend := setupBackend(shortEnv, config, timeout)
+		short = &backend
+		if lengthEnv := os.Getenv("LLM_SHORT_PROMPT_LENGTH"); lengthEnv != "" {
+			var err error
+			shortLength, err = strconv.Atoi(lengthEnv)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	router, err := llm.NewRouter(backends, short, shortLength)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return router
+}
+
+func setupBackend(spec string, base llm.Config, timeout time.Duration) llm.Backend {
+	config, err := llm.ParseConfig(spec, base)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := llm.New(config)
+	if err != nil {
+		log.Fatal(err)
 	}
-	return ollama.NewOllama(model, host)
+	return llm.Backend{Name: config.String(), LLM: client, Timeout: timeout}
 }
 
-func setupKG(ctx context.Context, embedder *embeddings.Service) knowledgegraph.KnowledgeGraph {
+func setupKG(ctx context.Context, embedder embeddings.Embeddings) knowledgegraph.KnowledgeGraph {
+	fixture := os.Getenv("KG_FIXTURE")
+	if fixture != "" {
+		fmt.Println("KG_FIXTURE set, using in-memory knowledge graph")
+		kg, err := knowledgegraph.LoadMemoryGraph(fixture, embedder, "neo4j/import")
+		if err != nil {
+			log.Fatal(err)
+		}
+		return kg
+	}
+
 	neo4jUri := os.Getenv("NEO4J_URI")
 	if neo4jUri == "" {
 		fmt.Println("NEO4J_URI not set, using default uri")
@@ -82,32 +204,131 @@ func setupKG(ctx context.Context, embedder *embeddings.Service) knowledgegraph.K
 	return kg
 }
 
-func setupEmbedder() *embeddings.Service {
+// httpClient returns the HTTP client of the LLM and embeddings providers. With
+// HTTP_FIXTURES set to a directory, it answers from the fixture files there
+// without the services, or with HTTP_FIXTURES_MODE=record records the exchanges
+// with the services to them. Otherwise it returns nil, for http.DefaultClient.
+var httpClient = sync.OnceValue(func() *http.Client {
+	dir := os.Getenv("HTTP_FIXTURES")
+	if dir == "" {
+		return nil
+	}
+	mode := os.Getenv("HTTP_FIXTURES_MODE")
+	if mode == "" {
+		fmt.Println("HTTP_FIXTURES_MODE not set, using default mode replay")
+		mode = string(replay.Replay)
+	}
+	transport, err := replay.NewTransport(replay.Mode(mode), dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("HTTP_FIXTURES set, %s mode with fixtures in %s\n", mode, dir)
+	return transport.Client()
+})
+
+// usageReporter is implemented by providers that count the tokens they used.
+type usageReporter interface {
+	Usage() llm.Usage
+}
+
+// setupEmbedder returns the embeddings provider of EMBEDDINGS_PROVIDER: the
+// Python embeddings service (default), ollama or openai. It is wrapped in an
+// on-disk cache when EMBEDDINGS_CACHE is set. The returned function closes the
+// cache and logs its statistics and the token usage.
+func setupEmbedder() (embeddings.Embeddings, func()) {
+	provider := os.Getenv("EMBEDDINGS_PROVIDER")
+	if provider == "" {
+		provider = "service"
+	}
+	var defaultModel, defaultHost string
+	switch provider {
+	case "service":
+		defaultModel, defaultHost = "sentence-transformers/all-MiniLM-L6-v2", "http://localhost:8000"
+	case "ollama":
+		defaultModel, defaultHost = "nomic-embed-text", "http://localhost:11434"
+	case "openai":
+		defaultModel, defaultHost = "text-embedding-3-small", "http://localhost:8080/v1"
+	default:
+		log.Fatalf("unknown EMBEDDINGS_PROVIDER %q, use service, ollama or openai", provider)
+	}
+
 	embeddingsModel := os.Getenv("EMBEDDINGS_MODEL")
 	if embeddingsModel == "" {
 		fmt.Println("EMBEDDINGS_MODEL not set, using default model")
-		embeddingsModel = "sentence-transformers/all-MiniLM-L6-v2"
+		embeddingsModel = defaultModel
 	}
 	embeddingsHost := os.Getenv("EMBEDDINGS_HOST")
 	if embeddingsHost == "" {
 		fmt.Println("EMBEDDINGS_HOST not set, using default host")
-		embeddingsHost = "http://localhost:8000"
+		embeddingsHost = defaultHost
 	}
-	embeddingsWorkersEnv := os.Getenv("EMBEDDINGS_WORKERS")
-	var embeddingsWorkers int
-	if embeddingsWorkersEnv == "" {
-		if embeddingsWorkers < 1 {
-			fmt.Println("EMBEDDINGS_WORKERS not set, using default workers")
-			embeddingsWorkers = 4
+	var service embeddings.Embeddings
+	switch provider {
+	case "service":
+		client := embeddings.NewEmbeddings(embeddingsModel, embeddingsHost)
+		client.HTTPClient = httpClient()
+		service = client
+	case "ollama":
+		ollamaClient := ollama.NewOllama(embeddingsModel, embeddingsHost)
+		ollamaClient.HTTPClient = httpClient()
+		err := checkOllamaModel(ollamaClient, embeddingsModel, false)
+		if err != nil {
+			log.Fatal(err)
 		}
-	} else {
+		client := embeddings.NewOllamaEmbeddings(embeddingsModel, embeddingsHost)
+		client.HTTPClient = httpClient()
+		service = client
+	case "openai":
+		client := openai.NewEmbeddings(embeddingsModel, embeddingsHost, os.Getenv("EMBEDDINGS_API_KEY"))
+		client.Client.HTTPClient = httpClient()
+		service = client
+	}
+
+	logUsage := func() {
+		if reporter, ok := service.(usageReporter); ok {
+			log.Printf("embeddings usage: %s", reporter.Usage())
+		}
+	}
+
+	cachePath := os.Getenv("EMBEDDINGS_CACHE")
+	if cachePath == "" {
+		return service, logUsage
+	}
+	cacheSize := 100000
+	if cacheSizeEnv := os.Getenv("EMBEDDINGS_CACHE_SIZE"); cacheSizeEnv != "" {
 		var err error
-		embeddingsWorkers, err = strconv.Atoi(embeddingsWorkersEnv)
+		cacheSize, err = strconv.Atoi(cacheSizeEnv)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
-	return embeddings.NewEmbeddings(embeddingsModel, embeddingsHost, embeddingsWorkers)
+	cache, err := embeddings.NewCache(service, embeddingsModel, cachePath, cacheSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cache, func() {
+		logUsage()
+		log.Printf("embeddings cache: %s", cache.Stats())
+		err := cache.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// embeddingsWorkers returns the number of concurrent requests used to
+// generate the embeddings of all movies.
+func embeddingsWorkers() int {
+	embeddingsWorkersEnv := os.Getenv("EMBEDDINGS_WORKERS")
+	if embeddingsWorkersEnv == "" {
+		fmt.Println("EMBEDDINGS_WORKERS not set, using default workers")
+		return 4
+	}
+	embeddingsWorkers, err := strconv.Atoi(embeddingsWorkersEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return embeddingsWorkers
 }
 
 type ErrEmbedding struct {
@@ -122,7 +343,7 @@ func (e ErrEmbedding) Error() string {
 	return fmt.Sprintf("error fetching embeddings: %s", strings.Join(msgs, ", "))
 }
 
-func fetchEmbeddingsForMovies(ctx context.Context, embedder *embeddings.Service, movies []knowledgegraph.Movie, filename string) error {
+func fetchEmbeddingsForMovies(ctx context.Context, embedder embeddings.Embeddings, workers int, movies []knowledgegraph.Movie, filename string) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return err
@@ -134,7 +355,7 @@ func fetchEmbeddingsForMovies(ctx context.Context, embedder *embeddings.Service,
 		return err
 	}
 
-	resChan, errChan := createEmbeddingsWorkers(ctx, embedder, movies)
+	resChan, errChan := createEmbeddingsWorkers(ctx, embedder, workers, movies)
 
 	var errors []error
 	for {
@@ -163,13 +384,22 @@ func fetchEmbeddingsForMovies(ctx context.Context, embedder *embeddings.Service,
 	}
 }
 
-func createEmbeddingsWorkers(ctx context.Context, embedder *embeddings.Service, movies []knowledgegraph.Movie) (chan embeddings.Embedding, chan error) {
-	jobChan := make(chan knowledgegraph.Movie)
+// embeddingsBatchSize is the number of plots sent in one request to embedders
+// that embed batches.
+const embeddingsBatchSize = 16
+
+func createEmbeddingsWorkers(ctx context.Context, embedder embeddings.Embeddings, workers int, movies []knowledgegraph.Movie) (chan embeddings.Embedding, chan error) {
+	jobChan := make(chan []knowledgegraph.Movie)
 	resChan := make(chan embeddings.Embedding)
 	errChan := make(chan error)
 
+	batchSize := 1
+	if _, ok := embedder.(embeddings.Batcher); ok {
+		batchSize = embeddingsBatchSize
+	}
+
 	wg := &sync.WaitGroup{}
-	for i := 0; i < embedder.Workers; i++ {
+	for i := 0; i < workers; i++ {
 		log.Println("creating embeddings worker")
 		wg.Add(1)
 		go createEmbeddingsWorker(ctx, wg, embedder, jobChan, resChan, errChan)
@@ -182,15 +412,18 @@ func createEmbeddingsWorkers(ctx context.Context, embedder *embeddings.Service,
 	}()
 
 	go func(ctx context.Context) {
-		for _, movie := range movies {
-			log.Printf("creating embedding for movie %s", movie.MovieID)
+		for start := 0; start < len(movies); start += batchSize {
+			batch := movies[start:min(start+batchSize, len(movies))]
+			for _, movie := range batch {
+				log.Printf("creating embedding for movie %s", movie.MovieID)
+			}
 			select {
 			case <-ctx.Done():
 				log.Println("request canceled by client")
 				close(jobChan)
 				return
 			default:
-				jobChan <- movie
+				jobChan <- batch
 			}
 		}
 		close(jobChan)
@@ -199,126 +432,1487 @@ func createEmbeddingsWorkers(ctx context.Context, embedder *embeddings.Service,
 	return resChan, errChan
 }
 
-func createEmbeddingsWorker(ctx context.Context, wg *sync.WaitGroup, embedder *embeddings.Service, jobChan chan knowledgegraph.Movie, resChan chan embeddings.Embedding, errChan chan error) {
+func createEmbeddingsWorker(ctx context.Context, wg *sync.WaitGroup, embedder embeddings.Embeddings, jobChan chan []knowledgegraph.Movie, resChan chan embeddings.Embedding, errChan chan error) {
 	defer wg.Done()
 	for {
-		movie, ok := <-jobChan
+		batch, ok := <-jobChan
 		if !ok {
 			return
 		}
-		embedding, err := embedder.Embedding(ctx, movie.Plot)
+		plots := make([]string, len(batch))
+		for i, movie := range batch {
+			plots[i] = movie.Plot
+		}
+		vectors, err := embeddings.EmbedAll(ctx, embedder, plots)
 		if err != nil {
 			errChan <- err
 			continue
 		}
-		embedding.ID = movie.MovieID
-		resChan <- embedding
+		for i, movie := range batch {
+			resChan <- embeddings.Embedding{ID: movie.MovieID, Embedding: vectors[i]}
+		}
+	}
+}
+
+type options struct {
+	generateEmbeddings bool
+	prompt             string
+	k                  int
+	mmr                bool
+	mmrLambda          float64
+	candidates         int
+	rerank             bool
+	rerankMode         string
+	rerankConcurrency  int
+	rerankCache        string
+	user               string
+	minRating          float64
+	userWeight         float64
+	cf                 string
+	cfRatio            float64
+	movie              string
+	boost              bool
+	explain            bool
+	explainPaths       int
+	passages           int
+	hnsw               string
+	hnswEf             int
+	contextWindow      int
+	answerTokens       int
+	overflow           string
+	tokenCalibration   string
+	template           string
+}
+
+// retrievalFlags are the flags that configure the retriever, shared by the app
+// and eval retrieval.
+type retrievalFlags struct {
+	mmr               *bool
+	mmrLambda         *float64
+	candidates        *int
+	rerank            *bool
+	rerankMode        *string
+	rerankConcurrency *int
+	rerankCache       *string
+	cf                *string
+	cfRatio           *float64
+	hnsw              *string
+	hnswEf            *int
+}
+
+func addRetrievalFlags(flags *flag.FlagSet) retrievalFlags {
+	return retrievalFlags{
+		mmr:               flags.Bool("mmr", false, "re-rank similar movies with maximal marginal relevance for more diverse results"),
+		mmrLambda:         flags.Float64("mmr-lambda", 0.7, "trade-off between relevance (1) and diversity (0) for -mmr"),
+		candidates:        flags.Int("candidates", 30, "number of candidates to fetch before re-ranking"),
+		rerank:            flags.Bool("rerank", false, "re-rank candidates with relevance scores from the language model"),
+		rerankMode:        flags.String("rerank-mode", string(rerank.Pointwise), "how -rerank scores candidates: pointwise or listwise"),
+		rerankConcurrency: flags.Int("rerank-concurrency", 4, "maximum number of concurrent scoring requests for -rerank"),
+		rerankCache:       flags.String("rerank-cache", "", "file to cache -rerank scores in between runs"),
+		cf:                flags.String("cf", "", "blend in collaborative-filtering candidates from the ratings graph: user (needs -user) or item"),
+		cfRatio:           flags.Float64("cf-ratio", 0.3, "weight of the collaborative-filtering score against the plot similarity for -cf, between 0 and 1"),
+		hnsw:              flags.String("hnsw", "", "search plots in this HNSW index file (see the index command) instead of the Neo4j vector index"),
+		hnswEf:            flags.Int("hnsw-ef", 0, "candidate list size of -hnsw searches; higher is more accurate and slower (0 uses the index default)"),
+	}
+}
+
+// check exits when the retrieval flags are invalid for a top-k of k. extra
+// tells whether other flags need candidates to be fetched too.
+func (f retrievalFlags) check(k int, extra bool) {
+	if *f.mmrLambda < 0 || *f.mmrLambda > 1 {
+		log.Fatal("mmr-lambda flag must be between 0 and 1")
+	}
+	if *f.cf != "" && *f.cf != "user" && *f.cf != "item" {
+		log.Fatal("cf flag must be user or item")
+	}
+	if *f.cfRatio < 0 || *f.cfRatio > 1 {
+		log.Fatal("cf-ratio flag must be between 0 and 1")
 	}
+	if *f.mmr && *f.rerank {
+		log.Fatal("mmr and rerank flags are mutually exclusive")
+	}
+	if *f.mmr || *f.rerank || *f.cf != "" || extra {
+		checkCandidates(*f.candidates, k)
+	}
+}
+
+// apply sets the retrieval options from the flags.
+func (f retrievalFlags) apply(opts *options) {
+	opts.mmr = *f.mmr
+	opts.mmrLambda = *f.mmrLambda
+	opts.candidates = *f.candidates
+	opts.rerank = *f.rerank
+	opts.rerankMode = *f.rerankMode
+	opts.rerankConcurrency = *f.rerankConcurrency
+	opts.rerankCache = *f.rerankCache
+	opts.cf = *f.cf
+	opts.cfRatio = *f.cfRatio
+	opts.hnsw = *f.hnsw
+	opts.hnswEf = *f.hnswEf
 }
 
-func parseFlags() (bool, string) {
+func parseFlags() options {
 	embeddingsFlag := flag.Bool("embeddings", false, "generate embeddings for movie plots in knowledge graph")
 	promptFlag := flag.String("prompt", "", "prompt for language model")
+	kFlag := flag.Int("k", knowledgegraph.DefaultSearchLimit, "number of movies to put in the prompt")
+	retrieval := addRetrievalFlags(flag.CommandLine)
+	userFlag := flag.String("user", "", "personalise recommendations for the user with this userId")
+	minRatingFlag := flag.Float64("min-rating", 4.0, "minimum rating for a movie to count towards the taste profile of -user")
+	userWeightFlag := flag.Float64("user-weight", 0.5, "how much the taste profile of -user biases retrieval, between 0 and 1")
+	movieFlag := flag.String("movie", "", "recommend movies like the movie with this movieId, and like -prompt when it is set too")
+	boostFlag := flag.Bool("boost", false, "boost movies that share genres, directors and actors with -movie")
+	explainFlag := flag.Bool("explain", false, "ground the recommendations in graph paths from -movie or -user to each movie")
+	explainPathsFlag := flag.Int("explain-paths", 3, "maximum number of paths per movie for -explain")
+	passagesFlag := flag.Int("passages", 0, "number of document chunks similar to the prompt to add, with the entities they mention")
+	contextWindowFlag := flag.Int("context-window", 0, "context window of the model in tokens (0 asks Ollama for num_ctx; other providers need it to pack the context)")
+	answerTokensFlag := flag.Int("answer-tokens", 512, "tokens of the context window kept free for the answer")
+	overflowFlag := flag.String("overflow", string(packing.Truncate), "what to do with movies and passages that don't fit the context window: truncate, summarize or drop")
+	tokenCalibrationFlag := flag.String("token-calibration", "", "file to keep the token estimate calibration in between runs")
+	templateFlag := flag.String("template", "default", "file with a text/template for the prompt, or default")
 	flag.Parse()
-	if *promptFlag == "" && !*embeddingsFlag {
-		log.Fatal("prompt flag or embeddings flag is required")
+	if *promptFlag == "" && *movieFlag == "" && !*embeddingsFlag {
+		log.Fatal("prompt flag, movie flag or embeddings flag is required")
 	}
-	if *promptFlag != "" && *embeddingsFlag {
-		log.Fatal("prompt and embeddings flags are mutually exclusive")
+	if (*promptFlag != "" || *movieFlag != "") && *embeddingsFlag {
+		log.Fatal("prompt and movie flags are mutually exclusive with the embeddings flag")
 	}
-	return *embeddingsFlag, *promptFlag
+	if *boostFlag && *movieFlag == "" {
+		log.Fatal("boost flag requires the movie flag")
+	}
+	if *kFlag < 1 {
+		log.Fatal("k flag must be at least 1")
+	}
+	if *explainFlag && *movieFlag == "" && *userFlag == "" {
+		log.Fatal("explain flag requires the movie or user flag")
+	}
+	if *userWeightFlag < 0 || *userWeightFlag > 1 {
+		log.Fatal("user-weight flag must be between 0 and 1")
+	}
+	if *retrieval.cf == "user" && *userFlag == "" {
+		log.Fatal("cf flag user requires the user flag")
+	}
+	if *answerTokensFlag < 0 {
+		log.Fatal("answer-tokens flag must not be negative")
+	}
+	retrieval.check(*kFlag, *boostFlag)
+	opts := options{
+		generateEmbeddings: *embeddingsFlag,
+		prompt:             *promptFlag,
+		k:                  *kFlag,
+		user:               *userFlag,
+		minRating:          *minRatingFlag,
+		userWeight:         *userWeightFlag,
+		movie:              *movieFlag,
+		boost:              *boostFlag,
+		explain:            *explainFlag,
+		explainPaths:       *explainPathsFlag,
+		passages:           *passagesFlag,
+		contextWindow:      *contextWindowFlag,
+		answerTokens:       *answerTokensFlag,
+		overflow:           *overflowFlag,
+		tokenCalibration:   *tokenCalibrationFlag,
+		template:           *templateFlag,
+	}
+	retrieval.apply(&opts)
+	return opts
 }
 
-func main() {
-	ctx := context.Background()
-	generateEmbeddings, prompt := parseFlags()
+// minCandidates and maxCandidates bound -candidates: fewer leave the
+// re-ranking little to choose from, more make LLM re-ranking slow.
+const (
+	minCandidates = 30
+	maxCandidates = 50
+)
 
-	llm := setupLLM()
-	embedder := setupEmbedder()
-	kg := setupKG(ctx, embedder)
+// checkCandidates exits when the number of candidates to re-rank is out of
+// range or less than k.
+func checkCandidates(candidates, k int) {
+	if candidates < minCandidates || candidates > maxCandidates {
+		log.Fatalf("candidates flag must be between %d and %d", minCandidates, maxCandidates)
+	}
+	if candidates < k {
+		log.Fatal("candidates flag must be at least k")
+	}
+}
 
-	movies, err := kg.GetMovies(ctx)
-	if err != nil {
-		log.Fatal(err)
+// retriever finds the movies to put in the prompt.
+type retriever struct {
+	kg       knowledgegraph.KnowledgeGraph
+	embedder embeddings.Embeddings
+	reranker *rerank.LLMReranker
+	profile  *profile.TasteProfile
+	seed     *knowledgegraph.Movie
+	opts     options
+}
+
+// maxRatedOverfetch caps how many extra candidates are fetched at first to
+// make up for the rated movies that are left out, so a long rating history
+// doesn't turn every search into a scan of the whole graph. When too many
+// rated movies come back, searchPlots fetches again with a larger k.
+const maxRatedOverfetch = 50
+
+// search retrieves the movies for the question. With a seed movie its plot
+// embedding is used instead of the question embedding, or averaged with it
+// when there is a question too. With MMR or an LLM
+// reranker enabled it over-fetches candidates and picks the top-k from them.
+// With a taste profile the search embedding is biased towards the profile and
+// movies the user already rated are left out. With collaborative filtering
+// enabled its candidates are blended with the vector search candidates.
+func (r *retriever) search(ctx context.Context, question string) ([]knowledgegraph.Movie, error) {
+	query := r.query(question)
+	fetch := r.opts.k
+	if r.opts.mmr || r.reranker != nil || r.opts.cf != "" || r.opts.boost {
+		fetch = max(r.opts.candidates, r.opts.k)
 	}
 
-	if generateEmbeddings {
-		err := fetchEmbeddingsForMovies(ctx, embedder, movies, "neo4j/import/embeddings.csv")
+	var (
+		vector     []float32
+		candidates []knowledgegraph.Movie
+		err        error
+	)
+	if r.seed != nil && r.profile == nil && question == "" {
+		vector = r.seed.Embedding
+		candidates, err = r.kg.SimilarToMovie(ctx, *r.seed, fetch)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		err = kg.StoreEmbeddings(ctx, "embeddings.csv")
+	} else {
+		if question != "" {
+			embedding, err := r.embedder.Embedding(ctx, question)
+			if err != nil {
+				return nil, err
+			}
+			vector = embedding.Embedding
+		}
+		if r.seed != nil {
+			vector, err = combine(r.seed.Embedding, vector)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if r.profile != nil {
+			vector = r.profile.Bias(vector, r.opts.userWeight)
+		}
+		candidates, err = r.searchPlots(ctx, vector, fetch)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		fmt.Println("embeddings generated and stored in knowledge graph")
-		return
 	}
 
-	similarMovies, err := kg.SearchSimilarPlots(ctx, prompt)
-	if err != nil {
-		log.Fatal(err)
+	if r.opts.boost {
+		candidates, err = r.kg.BoostSharedMetadata(ctx, r.seed.MovieID, candidates, knowledgegraph.DefaultBoost)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	var moviesStr string
-	for _, movie := range similarMovies {
-		log.Println("movie:", movie.Title)
-		moviesStr += fmt.Sprintf("Title: %s\nPlot: %s\n---\n", movie.Title, movie.Plot)
+	if r.opts.cf != "" {
+		collaborative, err := r.collaborativeCandidates(ctx, candidates, max(r.opts.candidates, r.opts.k))
+		if err != nil {
+			return nil, err
+		}
+		if r.profile != nil {
+			collaborative = r.profile.Exclude(collaborative)
+		}
+		if r.seed != nil {
+			collaborative = excludeMovie(collaborative, r.seed.MovieID)
+		}
+		candidates = rerank.Blend(candidates, collaborative, r.opts.cfRatio)
 	}
 
-	prompt = fmt.Sprintf(`
-You are a movie expert. You decide which movie to watch based on the plot. Below are some movies with
-plots based on the query of the user. You can only suggest movies from the list provided.
+	switch {
+	case r.reranker != nil:
+		return r.reranker.Rerank(ctx, query, candidates, r.opts.k)
+	case r.opts.mmr:
+		return rerank.MMR(vector, candidates, r.opts.k, r.opts.mmrLambda), nil
+	}
+	return candidates[:min(r.opts.k, len(candidates))], nil
+}
 
-### Movies:
----
-%s
-Question: I want to watch a movie about %s. What movie from the list provided above should I watch?
-You can only suggest movies from the list provided.
-	`, moviesStr, prompt)
+// query returns the text the reranker and passage search match against: the
+// question, or a request for movies like the seed movie without one.
+func (r *retriever) query(question string) string {
+	if question == "" && r.seed != nil {
+		return "movies like " + r.seed.Title
+	}
+	return question
+}
 
-	log.Println("prompt created:\n", prompt)
+// searchPlots returns the want movies whose plots are closest to the vector,
+// leaving out the seed movie and the movies the user rated. It over-fetches
+// to make up for them, and fetches again with twice the k while too many were
+// left out, until the index runs out of movies.
+func (r *retriever) searchPlots(ctx context.Context, vector []float32, want int) ([]knowledgegraph.Movie, error) {
+	fetch := want
+	if r.seed != nil {
+		fetch++
+	}
+	if r.profile != nil {
+		fetch += min(len(r.profile.Rated), maxRatedOverfetch)
+	}
+	for {
+		candidates, err := r.kg.SearchSimilarPlotsByEmbedding(ctx, vector, fetch)
+		if err != nil {
+			return nil, err
+		}
+		found := len(candidates)
+		if r.profile != nil {
+			candidates = r.profile.Exclude(candidates)
+		}
+		if r.seed != nil {
+			candidates = excludeMovie(candidates, r.seed.MovieID)
+		}
+		if len(candidates) >= want {
+			return candidates, nil
+		}
+		if found < fetch {
+			log.Printf("only %d of %d movies left after leaving out the seed and rated movies", len(candidates), want)
+			return candidates, nil
+		}
+		fetch *= 2
+	}
+}
 
-	scanner, err := llm.GenerateStream(ctx, prompt)
+// searchPassages finds the document chunks most similar to the query, with
+// the entities they mention. It returns nil when -passages is not set.
+func (r *retriever) searchPassages(ctx context.Context, query string) ([]knowledgegraph.ChunkMatch, error) {
+	if r.opts.passages < 1 {
+		return nil, nil
+	}
+	embedding, err := r.embedder.Embedding(ctx, query)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	_, err = readStream(scanner, os.Stdout)
+	passages, err := r.kg.SearchSimilarChunks(ctx, embedding.Embedding, r.opts.passages)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	for _, passage := range passages {
+		log.Printf("passage: %s (similarity %.3f, %d entities)", passage.Chunk.ChunkID, passage.Score, len(passage.Entities))
 	}
+	return passages, nil
 }
 
-func readStream(scanner *bufio.Scanner, writer io.Writer) (*LLMResponse, error) {
-	var response *LLMResponse
-	var answer string
-	for scanner.Scan() {
-		var chunk Chunk
-		err := json.Unmarshal(scanner.Bytes(), &chunk)
+// explain finds graph paths from the seed movie and the user to every movie,
+// keyed by movieId. It returns nil when -explain is not set.
+func (r *retriever) explain(ctx context.Context, movies []knowledgegraph.Movie) (map[string][]string, error) {
+	if !r.opts.explain {
+		return nil, nil
+	}
+	ids := make([]string, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.MovieID
+	}
+
+	connections := map[string][]string{}
+	if r.seed != nil {
+		fromMovie, err := r.kg.ExplainFromMovie(ctx, r.seed.MovieID, ids, r.opts.explainPaths)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
+		}
+		for id, paths := range fromMovie {
+			connections[id] = append(connections[id], paths...)
 		}
-		_, err = writer.Write([]byte(chunk.Response))
+	}
+	if r.opts.user != "" {
+		fromUser, err := r.kg.ExplainFromUser(ctx, r.opts.user, ids, r.opts.minRating, r.opts.explainPaths)
 		if err != nil {
 			return nil, err
 		}
+		for id, paths := range fromUser {
+			connections[id] = append(connections[id], paths...)
+		}
+	}
+	for _, movie := range movies {
+		for _, path := range connections[movie.MovieID] {
+			log.Printf("connection: %s %s", movie.Title, path)
+		}
+	}
+	return connections, nil
+}
 
-		if chunk.Done {
-			err := json.Unmarshal(scanner.Bytes(), &response)
-			if err != nil {
-				return nil, err
-			}
-			response.Response = answer
-			break
+// combine averages the seed embedding with the question embedding, after
+// normalising both, so neither outweighs the other. Without a question it
+// returns the seed embedding.
+func combine(seed, question []float32) ([]float32, error) {
+	if question == nil {
+		return seed, nil
+	}
+	if len(seed) != len(question) {
+		return nil, fmt.Errorf("embedding of the seed movie has %d dimensions and of the prompt %d, embed them with the same model", len(seed), len(question))
+	}
+	if utils.Norm(seed) == 0 || utils.Norm(question) == 0 {
+		return seed, nil
+	}
+	seed, question = utils.Normalise(seed), utils.Normalise(question)
+	combined := make([]float32, len(seed))
+	for i := range seed {
+		combined[i] = (seed[i] + question[i]) / 2
+	}
+	return combined, nil
+}
+
+func excludeMovie(movies []knowledgegraph.Movie, movieID string) []knowledgegraph.Movie {
+	var kept []knowledgegraph.Movie
+	for _, movie := range movies {
+		if movie.MovieID != movieID {
+			kept = append(kept, movie)
 		}
-		answer += chunk.Response
 	}
-	_, err := writer.Write([]byte("\n"))
+	return kept
+}
+
+// collaborativeCandidates generates n collaborative-filtering candidates.
+// Item-based candidates start from the seed movie, the favourites of the user,
+// or from the top-k vector search results.
+func (r *retriever) collaborativeCandidates(ctx context.Context, vectorCandidates []knowledgegraph.Movie, n int) ([]knowledgegraph.Movie, error) {
+	if r.opts.cf == "user" {
+		return r.kg.UserBasedCandidates(ctx, r.opts.user, n)
+	}
+
+	var seeds []string
+	if r.seed != nil {
+		seeds = append(seeds, r.seed.MovieID)
+	}
+	if r.profile != nil {
+		for _, rating := range r.profile.Favourites {
+			seeds = append(seeds, rating.Movie.MovieID)
+		}
+	}
+	if len(seeds) == 0 {
+		for _, movie := range vectorCandidates[:min(r.opts.k, len(vectorCandidates))] {
+			seeds = append(seeds, movie.MovieID)
+		}
+	}
+	return r.kg.ItemBasedCandidates(ctx, seeds, n)
+}
+
+// setupVectorIndex wraps the knowledge graph to search plots in the HNSW
+// index of -hnsw, or returns it as is when -hnsw is not set.
+func setupVectorIndex(kg knowledgegraph.KnowledgeGraph, embedder embeddings.Embeddings, opts options) knowledgegraph.KnowledgeGraph {
+	if opts.hnsw == "" {
+		return kg
+	}
+	index, err := hnsw.Load(opts.hnsw)
 	if err != nil {
-		return nil, err
+		log.Fatal(err)
+	}
+	log.Printf("searching plots in HNSW index %s with %d embeddings", opts.hnsw, index.Len())
+	return knowledgegraph.WithVectorIndex(kg, index, opts.hnswEf, embedder)
+}
+
+// setupSeed fetches the movie for -movie, or returns nil when it is not set.
+func setupSeed(ctx context.Context, kg knowledgegraph.KnowledgeGraph, opts options) *knowledgegraph.Movie {
+	if opts.movie == "" {
+		return nil
+	}
+	seed, err := kg.GetMovie(ctx, opts.movie)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(seed.Embedding) == 0 {
+		log.Fatalf("%s: %s", knowledgegraph.ErrNoEmbedding, opts.movie)
 	}
+	log.Printf("seed movie: %s", seed.Title)
+	return &seed
+}
+
+// setupProfile builds the taste profile for -user, or returns nil when it is
+// not set.
+func setupProfile(ctx context.Context, kg knowledgegraph.KnowledgeGraph, opts options) *profile.TasteProfile {
+	if opts.user == "" {
+		return nil
+	}
+	ratings, err := kg.GetUserRatings(ctx, opts.user)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p, err := profile.NewTasteProfile(opts.user, ratings, opts.minRating)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("taste profile for user %s:\n%s", opts.user, p.Summary())
+	return p
+}
 
-	return response, scanner.Err()
+// setupReranker creates the LLM reranker, or returns nil when -rerank is not
+// set. The returned function saves the score cache.
+func setupReranker(client llm.LLM, model string, opts options) (*rerank.LLMReranker, func() error) {
+	if !opts.rerank {
+		return nil, func() error { return nil }
+	}
+	cache := rerank.NewCache()
+	if opts.rerankCache != "" {
+		var err error
+		cache, err = rerank.LoadCache(opts.rerankCache)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	reranker, err := rerank.NewLLMReranker(client, model, rerank.Mode(opts.rerankMode), opts.rerankConcurrency, cache)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return reranker, func() error {
+		if opts.rerankCache == "" {
+			return nil
+		}
+		return cache.Save(opts.rerankCache)
+	}
+}
+
+// runExtract builds a knowledge graph from the text files in args, as in
+//
+//	knowledge-graph-rag extract -ontology ontology.json docs/*.txt
+func runExtract(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("extract", flag.ExitOnError)
+	ontologyFlag := flags.String("ontology", "", "JSON file with the entity and relationship types to extract (defaults to a movie ontology)")
+	chunkerFlag := flags.String("chunker", "sentence", "how documents are split into chunks: fixed, sentence or markdown")
+	chunkSizeFlag := flags.Int("chunk-size", 2000, "maximum size of a chunk in bytes")
+	chunkOverlapFlag := flags.Int("chunk-overlap", 200, "overlap between consecutive chunks in bytes")
+	resolveFlag := flags.Bool("resolve", true, "merge entities that refer to the same thing, like \"Tom Hanks\" and \"Hanks\"")
+	resolveThresholdFlag := flags.Float64("resolve-threshold", 0.85, "description similarity from which compatible names are merged")
+	resolveAmbiguousFlag := flags.Float64("resolve-ambiguous", 0.7, "description similarity from which the LLM decides whether compatible names are merged")
+	resolveLLMFlag := flags.Bool("resolve-llm", false, "ask the LLM about ambiguous entity pairs instead of keeping them apart")
+	auditLogFlag := flags.String("audit-log", "merges.log", "file the entity merges are appended to; -audit-log= keeps no audit log")
+	dryRunFlag := flags.Bool("dry-run", false, "report the entity merges without writing to the knowledge graph, which needs no Neo4j")
+	embedChunksFlag := flags.Bool("embed-chunks", true, "store an embedding with every chunk, so chunks can be retrieved by similarity")
+	factsFlag := flags.Bool("facts", true, "extract entities and relationships from the chunks; without it only the chunks are stored")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		log.Fatal("extract needs at least one text file")
+	}
+	if *chunkSizeFlag < 1 {
+		log.Fatal("-chunk-size must be at least 1")
+	}
+	if *chunkOverlapFlag < 0 || *chunkOverlapFlag >= *chunkSizeFlag {
+		log.Fatal("-chunk-overlap must be at least 0 and less than -chunk-size")
+	}
+
+	chunker, err := chunking.Strategy(*chunkerFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ontology := extraction.DefaultOntology
+	if *ontologyFlag != "" {
+		var err error
+		ontology, err = extraction.LoadOntology(*ontologyFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	client, _ := setupLLM()
+	embedder, closeEmbedder := setupEmbedder()
+	defer closeEmbedder()
+	// A dry run doesn't write, so it doesn't need the knowledge graph.
+	var kg knowledgegraph.KnowledgeGraph
+	if !*dryRunFlag {
+		kg = setupKG(ctx, embedder)
+	}
+
+	opts := cmd.GraphOptions{
+		Chunker:      chunker,
+		ChunkSize:    *chunkSizeFlag,
+		ChunkOverlap: *chunkOverlapFlag,
+		SkipFacts:    !*factsFlag,
+		AuditLog:     *auditLogFlag,
+		DryRun:       *dryRunFlag,
+	}
+	if *embedChunksFlag {
+		opts.Embedder = embedder
+	}
+	if *resolveFlag {
+		var adjudicator llm.LLM
+		if *resolveLLMFlag {
+			adjudicator = client
+		}
+		opts.Resolver = resolution.NewResolver(embedder, adjudicator, *resolveThresholdFlag, *resolveAmbiguousFlag)
+	}
+
+	err = cmd.GenerateKnowledgeGraph(ctx, kg, extraction.NewExtractor(client, ontology), flags.Args(), opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *dryRunFlag {
+		fmt.Println("dry run, knowledge graph not changed")
+		return
+	}
+	fmt.Println("knowledge graph generated")
+}
+
+// runIngest loads CSV or JSONL files into the knowledge graph as described by
+// a mapping, as in
+//
+//	knowledge-graph-rag ingest -mapping catalog.json
+func runIngest(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("ingest", flag.ExitOnError)
+	mappingFlag := flags.String("mapping", "", "JSON file mapping the columns of the source files to nodes and relationships")
+	batchSizeFlag := flags.Int("batch-size", 1000, "number of rows upserted in one transaction")
+	flags.Parse(args)
+	if *mappingFlag == "" {
+		log.Fatal("ingest needs a -mapping file")
+	}
+	if *batchSizeFlag < 1 {
+		log.Fatal("-batch-size must be at least 1")
+	}
+
+	mapping, err := ingest.LoadMapping(*mappingFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	embedder, closeEmbedder := setupEmbedder()
+	defer closeEmbedder()
+	kg := setupKG(ctx, embedder)
+	err = cmd.Ingest(ctx, kg, mapping, *batchSizeFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("data ingested into knowledge graph")
+}
+
+// runModels manages the models of the Ollama server at LLM_HOST:
+// `models list`, `models pull <model>` and `models show <model>`.
+func runModels(ctx context.Context, args []string) {
+	host := os.Getenv("LLM_HOST")
+	if host == "" {
+		fmt.Println("LLM_HOST not set, using default host")
+		host = "http://localhost:11434"
+	}
+	client := ollama.NewOllama("", host)
+	client.HTTPClient = httpClient()
+
+	if len(args) == 0 {
+		log.Fatal("usage: models list | pull <model> | show <model>")
+	}
+	var err error
+	switch {
+	case args[0] == "list":
+		err = cmd.ListModels(ctx, client, os.Stdout)
+	case args[0] == "pull" && len(args) == 2:
+		err = cmd.PullModel(ctx, client, args[1], os.Stdout)
+	case args[0] == "show" && len(args) == 2:
+		err = cmd.ShowModel(ctx, client, args[1], os.Stdout)
+	default:
+		log.Fatal("usage: models list | pull <model> | show <model>")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runIndex builds or updates an HNSW index of the plot embeddings in the
+// knowledge graph, as in
+//
+//	knowledge-graph-rag index -path movies.hnsw
+func runIndex(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("index", flag.ExitOnError)
+	pathFlag := flags.String("path", "movies.hnsw", "file of the index")
+	mFlag := flags.Int("m", 16, "number of neighbours per vector in a new index")
+	efConstructionFlag := flags.Int("ef-construction", 200, "candidate list size while building a new index")
+	efSearchFlag := flags.Int("ef-search", 64, "default candidate list size while searching a new index")
+	rebuildFlag := flags.Bool("rebuild", false, "build the index from scratch instead of updating it")
+	flags.Parse(args)
+
+	embedder, closeEmbedder := setupEmbedder()
+	defer closeEmbedder()
+	kg := setupKG(ctx, embedder)
+	err := cmd.BuildVectorIndex(ctx, kg, *pathFlag, cmd.IndexOptions{
+		M:              *mFlag,
+		EfConstruction: *efConstructionFlag,
+		EfSearch:       *efSearchFlag,
+		Rebuild:        *rebuildFlag,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("vector index saved to", *pathFlag)
+}
+
+// runEval evaluates the quality of the recommendations: `eval retrieval`,
+// `eval answers` and `eval dataset` to generate a golden set.
+func runEval(ctx context.Context, args []string) {
+	if len(args) == 0 {
+		log.Fatal("usage: eval retrieval | answers | dataset [flags]")
+	}
+	switch args[0] {
+	case "retrieval":
+		runEvalRetrieval(ctx, args[1:])
+	case "answers":
+		runEvalAnswers(ctx, args[1:])
+	case "dataset":
+		runEvalDataset(ctx, args[1:])
+	default:
+		log.Fatal("usage: eval retrieval | answers | dataset [flags]")
+	}
+}
+
+// runEvalDataset has the LLM write a golden set of queries for movies sampled
+// from the knowledge graph, as in
+//
+//	knowledge-graph-rag eval dataset -movies 100 -out golden.jsonl
+func runEvalDataset(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("eval dataset", flag.ExitOnError)
+	outFlag := flags.String("out", "golden.jsonl", "JSONL file to write the queries to")
+	moviesFlag := flags.Int("movies", 50, "number of movies to sample")
+	perMovieFlag := flags.Int("per-movie", 3, "number of queries to ask for per movie")
+	metadataFlag := flags.Bool("metadata", false, "give the LLM the genres, directors and actors of the movies too")
+	maxOverlapFlag := flags.Float64("max-overlap", 0.6, "share of the words of a query that may come from the plot before it is dropped as too literal")
+	maxSimilarityFlag := flags.Float64("max-similarity", 0.6, "word overlap with an earlier query from which a query is dropped as a near-duplicate")
+	seedFlag := flags.Int64("seed", 1, "seed of the movie sample, to generate the same sample again")
+	flags.Parse(args)
+	if *moviesFlag < 1 {
+		log.Fatal("-movies must be at least 1")
+	}
+
+	client, _ := setupLLM()
+	embedder, closeEmbedder := setupEmbedder()
+	defer closeEmbedder()
+	kg := setupKG(ctx, embedder)
+
+	synthesizer := eval.NewSynthesizer(client, eval.SyntheticOptions{
+		PerMovie:      *perMovieFlag,
+		Metadata:      *metadataFlag,
+		MaxOverlap:    *maxOverlapFlag,
+		MaxSimilarity: *maxSimilarityFlag,
+	})
+	err := cmd.GenerateDataset(ctx, kg, synthesizer, *moviesFlag, rand.New(rand.NewSource(*seedFlag)), *outFlag, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runEvalRetrieval scores the retriever on a golden set of queries, as in
+//
+//	knowledge-graph-rag eval retrieval -dataset golden.jsonl -baseline baseline.json
+//
+// It exits with an error when the metrics regressed against the baseline.
+func runEvalRetrieval(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("eval retrieval", flag.ExitOnError)
+	datasetFlag := flags.String("dataset", "", "JSONL file with the queries and their relevant movieIds")
+	kFlag := flags.Int("k", knowledgegraph.DefaultSearchLimit, "number of movies to retrieve per query")
+	baselineFlag := flags.String("baseline", "", "report of an earlier run to compare to")
+	toleranceFlag := flags.Float64("tolerance", 0.01, "how much a mean metric may drop below -baseline before it counts as a regression")
+	saveFlag := flags.String("save", "", "file to save the report to unless it regressed, to use as a later -baseline")
+	retrieval := addRetrievalFlags(flags)
+	flags.Parse(args)
+	if *datasetFlag == "" {
+		log.Fatal("eval retrieval needs a -dataset file")
+	}
+	if *kFlag < 1 {
+		log.Fatal("-k must be at least 1")
+	}
+	if *retrieval.cf == "user" {
+		log.Fatal("eval retrieval has no user, so -cf must be item")
+	}
+	retrieval.check(*kFlag, false)
+
+	queries, err := eval.LoadQueries(*datasetFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := options{k: *kFlag}
+	retrieval.apply(&opts)
+
+	embedder, closeEmbedder := setupEmbedder()
+	defer closeEmbedder()
+	kg := setupVectorIndex(setupKG(ctx, embedder), embedder, opts)
+	r := &retriever{kg: kg, embedder: embedder, opts: opts}
+	saveRerankCache := func() error { return nil }
+	if opts.rerank {
+		client, llmModel := setupLLM()
+		r.reranker, saveRerankCache = setupReranker(client, llmModel, opts)
+	}
+
+	retrieve := func(ctx context.Context, query string) ([]string, error) {
+		movies, err := r.search(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]string, len(movies))
+		for i, movie := range movies {
+			ids[i] = movie.MovieID
+		}
+		return ids, nil
+	}
+	err = cmd.EvalRetrieval(ctx, retrieve, queries, cmd.RetrievalEvalOptions{
+		K:         *kFlag,
+		Baseline:  *baselineFlag,
+		Tolerance: *toleranceFlag,
+		Save:      *saveFlag,
+	}, os.Stdout)
+	if cacheErr := saveRerankCache(); cacheErr != nil {
+		log.Print(cacheErr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runEvalAnswers answers a set of questions with every combination of
+// -models and -templates and has a judge model grade the answers, as in
+//
+//	knowledge-graph-rag eval answers -dataset questions.jsonl -templates default,terse.tmpl -markdown report.md
+//
+// The models are used as configured, without fallbacks, so every answer of a
+// variant comes from its model.
+func runEvalAnswers(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("eval answers", flag.ExitOnError)
+	datasetFlag := flags.String("dataset", "", "JSONL file with the questions, as for eval retrieval")
+	modelsFlag := flags.String("models", "", "comma-separated LLMs to compare, each [provider:]model[@host] (defaults to the configured LLM)")
+	templatesFlag := flags.String("templates", "default", "comma-separated prompt templates to compare, files or default")
+	judgeFlag := flags.String("judge", "", "LLM that grades the answers, as [provider:]model[@host] (defaults to the configured LLM)")
+	jsonFlag := flags.String("json", "", "file to write the report with every answer and judgement to")
+	markdownFlag := flags.String("markdown", "", "file to write the report as markdown tables to")
+	kFlag := flags.Int("k", knowledgegraph.DefaultSearchLimit, "number of movies to put in the prompt")
+	passagesFlag := flags.Int("passages", 0, "number of document chunks similar to the question to add")
+	contextWindowFlag := flags.Int("context-window", 0, "context window of the models in tokens (0 asks Ollama for num_ctx; other providers need it to pack the context)")
+	answerTokensFlag := flags.Int("answer-tokens", 512, "tokens of the context window kept free for the answer")
+	overflowFlag := flags.String("overflow", string(packing.Truncate), "what to do with context that doesn't fit the context window: truncate, summarize or drop")
+	flags.Parse(args)
+	if *datasetFlag == "" {
+		log.Fatal("eval answers needs a -dataset file")
+	}
+	if *kFlag < 1 {
+		log.Fatal("-k must be at least 1")
+	}
+
+	queries, err := eval.LoadQueries(*datasetFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var templates []*template.Template
+	templateNames := strings.Split(*templatesFlag, ",")
+	for _, name := range templateNames {
+		tmpl, err := loadTemplate(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		templates = append(templates, tmpl)
+	}
+
+	base := llmConfig()
+	models := []string{base.String()}
+	if *modelsFlag != "" {
+		models = strings.Split(*modelsFlag, ",")
+	}
+	judgeSpec := *judgeFlag
+	if judgeSpec == "" {
+		judgeSpec = base.String()
+	}
+	_, judgeClient := setupEvalLLM(judgeSpec, base)
+
+	opts := options{
+		k:             *kFlag,
+		passages:      *passagesFlag,
+		contextWindow: *contextWindowFlag,
+		answerTokens:  *answerTokensFlag,
+		overflow:      *overflowFlag,
+	}
+	embedder, closeEmbedder := setupEmbedder()
+	defer closeEmbedder()
+	r := &retriever{kg: setupKG(ctx, embedder), embedder: embedder, opts: opts}
+
+	// The variants answer a question in turn, so its context is retrieved
+	// once.
+	var retrievedID string
+	var retrieved promptContext
+	retrieve := func(ctx context.Context, query eval.Query) (promptContext, error) {
+		if query.ID == retrievedID {
+			return retrieved, nil
+		}
+		pc, err := r.retrieveContext(ctx, query.Query)
+		if err != nil {
+			return promptContext{}, err
+		}
+		retrievedID, retrieved = query.ID, pc
+		return pc, nil
+	}
+
+	estimator := packing.NewEstimator()
+	var variants []cmd.AnswerVariant
+	for _, spec := range models {
+		config, client := setupEvalLLM(spec, base)
+		for i, tmpl := range templates {
+			a := newAnswerer(ctx, client, config.Model, tmpl, estimator, opts)
+			variants = append(variants, cmd.AnswerVariant{
+				Name: config.String() + " " + templateNames[i],
+				Answer: func(ctx context.Context, query eval.Query) (eval.Answer, error) {
+					pc, err := retrieve(ctx, query)
+					if err != nil {
+						return eval.Answer{}, err
+					}
+					result, err := a.answer(ctx, pc, io.Discard)
+					if err != nil {
+						return eval.Answer{}, err
+					}
+					return evalAnswer(query, pc, result), nil
+				},
+			})
+		}
+	}
+
+	err = cmd.EvalAnswers(ctx, queries, variants, eval.NewJudge(judgeClient), cmd.AnswerEvalOptions{
+		JSON:     *jsonFlag,
+		Markdown: *markdownFlag,
+	}, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// setupEvalLLM creates the LLM of the spec, [provider:]model[@host], with the
+// rest taken from base.
+func setupEvalLLM(spec string, base llm.Config) (llm.Config, llm.LLM) {
+	config, err := llm.ParseConfig(spec, base)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := llm.New(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ollamaClient, ok := client.(*ollama.Client); ok {
+		err := checkOllamaModel(ollamaClient, config.Model, false)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	return config, client
+}
+
+// evalAnswer is the answer to the query with the context that made it into
+// the prompt, for the judge.
+func evalAnswer(query eval.Query, pc promptContext, result answered) eval.Answer {
+	titles := map[string]string{}
+	for _, movie := range pc.movies {
+		titles[movie.MovieID] = movie.Title
+	}
+	answer := eval.Answer{QuestionID: query.ID, Question: query.Query, Answer: result.answer}
+	var text strings.Builder
+	for _, segment := range result.segments {
+		text.WriteString(segment.String() + "---\n")
+		if segment.Kind == "movie" {
+			answer.Movies = append(answer.Movies, titles[segment.ID])
+		}
+	}
+	answer.Context = text.String()
+	return answer
+}
+
+func main() {
+	ctx := context.Background()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "extract":
+			runExtract(ctx, os.Args[2:])
+			return
+		case "ingest":
+			runIngest(ctx, os.Args[2:])
+			return
+		case "index":
+			runIndex(ctx, os.Args[2:])
+			return
+		case "models":
+			runModels(ctx, os.Args[2:])
+			return
+		case "eval":
+			runEval(ctx, os.Args[2:])
+			return
+		}
+	}
+
+	opts := parseFlags()
+	prompt := opts.prompt
+	tmpl, err := loadTemplate(opts.template)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client, llmModel := setupLLM()
+	embedder, closeEmbedder := setupEmbedder()
+	defer closeEmbedder()
+	kg := setupKG(ctx, embedder)
+
+	movies, err := kg.GetMovies(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if opts.generateEmbeddings {
+		err := fetchEmbeddingsForMovies(ctx, embedder, embeddingsWorkers(), movies, "neo4j/import/embeddings.csv")
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = kg.StoreEmbeddings(ctx, "embeddings.csv")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("embeddings generated and stored in knowledge graph")
+		return
+	}
+
+	kg = setupVectorIndex(kg, embedder, opts)
+	reranker, saveRerankCache := setupReranker(client, llmModel, opts)
+	r := &retriever{
+		kg:       kg,
+		embedder: embedder,
+		reranker: reranker,
+		profile:  setupProfile(ctx, kg, opts),
+		seed:     setupSeed(ctx, kg, opts),
+		opts:     opts,
+	}
+	pc, err := r.retrieveContext(ctx, prompt)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = saveRerankCache()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	a := newAnswerer(ctx, client, llmModel, tmpl, setupEstimator(opts), opts)
+	result, err := a.answer(ctx, pc, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(citations.Render(citedSources(result.answer, pc, result.segments)))
+	if result.usage != nil && opts.tokenCalibration != "" {
+		err = a.estimator.Save(opts.tokenCalibration)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if result.served != nil {
+		log.Printf("answered by %s after %d failed backends", result.served.Backend, len(result.served.Failed))
+	}
+	if reporter, ok := client.(usageReporter); ok {
+		log.Printf("LLM usage: %s", reporter.Usage())
+	}
+}
+
+// retrieveContext retrieves everything that goes into the prompt for the
+// question: the movies, their connections and the passages. Without a
+// question it asks for movies like the seed movie.
+func (r *retriever) retrieveContext(ctx context.Context, question string) (promptContext, error) {
+	movies, err := r.search(ctx, question)
+	if err != nil {
+		return promptContext{}, err
+	}
+	for _, movie := range movies {
+		scores := fmt.Sprintf("similarity %.3f", movie.SimilarityScore)
+		if r.reranker != nil {
+			scores += fmt.Sprintf(", rerank %.1f", movie.RerankScore)
+		}
+		if r.opts.cf != "" {
+			scores += fmt.Sprintf(", collaborative %.3f", movie.CollaborativeScore)
+		}
+		if r.opts.boost {
+			scores += fmt.Sprintf(", boost %.2f", movie.BoostScore)
+		}
+		log.Printf("movie: %s (%s)", movie.Title, scores)
+	}
+
+	connections, err := r.explain(ctx, movies)
+	if err != nil {
+		return promptContext{}, err
+	}
+	passages, err := r.searchPassages(ctx, r.query(question))
+	if err != nil {
+		return promptContext{}, err
+	}
+	return promptContext{
+		question:    question,
+		movies:      movies,
+		profile:     r.profile,
+		seed:        r.seed,
+		connections: connections,
+		passages:    passages,
+	}, nil
+}
+
+// answerer answers questions with an LLM, from the context packed into a
+// prompt template.
+type answerer struct {
+	client llm.LLM
+	// streaming is whether the client streams, asked once by newAnswerer.
+	streaming bool
+	model     string
+	template  *template.Template
+	estimator *packing.Estimator
+	opts      options
+}
+
+// newAnswerer returns an answerer with the client, asking the client once
+// whether it streams. When it can't tell, streaming is assumed.
+func newAnswerer(ctx context.Context, client llm.LLM, model string, tmpl *template.Template, estimator *packing.Estimator, opts options) *answerer {
+	streaming := true
+	capabilities, err := client.Capabilities(ctx)
+	if err != nil {
+		log.Printf("failed to get capabilities, assuming streaming: %v", err)
+	} else {
+		streaming = capabilities.Streaming
+	}
+	return &answerer{
+		client:    client,
+		streaming: streaming,
+		model:     model,
+		template:  tmpl,
+		estimator: estimator,
+		opts:      opts,
+	}
+}
+
+// answered is an answer with the prompt it was generated from.
+type answered struct {
+	segments []packing.Segment
+	prompt   string
+	answer   string
+	usage    *llm.Usage
+	// served is set when a router answered.
+	served *llm.Served
+}
+
+// answer packs the context into the prompt and streams the answer to writer.
+// The token estimate is calibrated with the prompt tokens the LLM reports.
+func (a *answerer) answer(ctx context.Context, pc promptContext, writer io.Writer) (answered, error) {
+	segments, err := packContext(ctx, a.client, a.model, a.estimator, a.template, pc, a.opts)
+	if err != nil {
+		return answered{}, err
+	}
+	prompt, err := buildPrompt(a.template, pc, segments)
+	if err != nil {
+		return answered{}, err
+	}
+	log.Println("prompt created:\n", prompt)
+
+	answer, last, err := a.generate(ctx, prompt, writer)
+	if err != nil {
+		return answered{}, err
+	}
+	if last.Usage != nil {
+		a.estimator.Calibrate(a.model, prompt, last.Usage.PromptTokens)
+	}
+	return answered{segments: segments, prompt: prompt, answer: answer, usage: last.Usage, served: last.Served}, nil
+}
+
+// generate streams the answer to the prompt to writer, or writes it at once
+// when the model can't stream.
+func (a *answerer) generate(ctx context.Context, prompt string, writer io.Writer) (string, llm.Chunk, error) {
+	if !a.streaming {
+		answer, err := a.client.Generate(ctx, prompt)
+		if err != nil {
+			return "", llm.Chunk{}, err
+		}
+		last := llm.Chunk{Done: true}
+		if router, ok := a.client.(*llm.Router); ok {
+			last.Served = router.LastServed()
+		}
+		_, err = fmt.Fprintln(writer, answer)
+		return answer, last, err
+	}
+	stream, err := a.client.GenerateStream(ctx, prompt)
+	if err != nil {
+		return "", llm.Chunk{}, err
+	}
+	return readStream(stream, writer)
+}
+
+// citedSources returns the sources of the movies and passages that made it
+// into the prompt and are cited in the answer. Made up IDs are logged.
+func citedSources(answer string, pc promptContext, segments []packing.Segment) []citations.Source {
+	movies := map[string]knowledgegraph.Movie{}
+	for _, movie := range pc.movies {
+		movies[movie.MovieID] = movie
+	}
+	passages := map[string]knowledgegraph.ChunkMatch{}
+	for _, passage := range pc.passages {
+		passages[passage.Chunk.ChunkID] = passage
+	}
+	var sources []citations.Source
+	for _, segment := range segments {
+		switch segment.Kind {
+		case "movie":
+			sources = append(sources, citations.MovieSource(movies[segment.ID]))
+		case "passage":
+			sources = append(sources, citations.PassageSource(passages[segment.ID]))
+		}
+	}
+	cited, unknown := citations.Parse(answer, sources)
+	if len(unknown) > 0 {
+		log.Printf("answer cites unknown sources: %s", strings.Join(unknown, ", "))
+	}
+	return cited
+}
+
+// setupEstimator loads the token calibration of -token-calibration.
+func setupEstimator(opts options) *packing.Estimator {
+	if opts.tokenCalibration == "" {
+		return packing.NewEstimator()
+	}
+	estimator, err := packing.LoadEstimator(opts.tokenCalibration)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return estimator
+}
+
+// packContext fits the movies and passages into the context window of the
+// model, leaving room for the rest of the prompt and -answer-tokens for the
+// answer, and logs what didn't fit.
+func packContext(ctx context.Context, client llm.LLM, model string, estimator *packing.Estimator, tmpl *template.Template, pc promptContext, opts options) ([]packing.Segment, error) {
+	window, err := contextWindow(ctx, client, opts)
+	if err != nil {
+		return nil, err
+	}
+	if window == 0 {
+		log.Println("context window of the model is unknown, not packing the context; set -context-window to pack it")
+		return contextSegments(pc), nil
+	}
+	rest, err := buildPrompt(tmpl, pc, nil)
+	if err != nil {
+		return nil, err
+	}
+	budget := window - opts.answerTokens - estimator.Tokens(model, rest)
+	if budget <= 0 {
+		return nil, fmt.Errorf("context window of %d tokens leaves no room for movies, lower -answer-tokens or raise -context-window", window)
+	}
+	packer, err := packing.NewPacker(estimator, model, budget, packing.Overflow(opts.overflow), client)
+	if err != nil {
+		return nil, err
+	}
+	packed, err := packer.Pack(ctx, contextSegments(pc))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("context: %s, budget %d of a %d token window", packed, budget, window)
+	return packed.Segments, nil
+}
+
+// contextWindow returns -context-window, or the context window the provider
+// reports, like the num_ctx of an Ollama model. It is 0 when neither is known.
+func contextWindow(ctx context.Context, client llm.LLM, opts options) (int, error) {
+	if opts.contextWindow > 0 {
+		return opts.contextWindow, nil
+	}
+	windowed, ok := client.(llm.Windowed)
+	if !ok {
+		return 0, nil
+	}
+	window, err := windowed.ContextWindow(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get context window: %w", err)
+	}
+	return window, nil
+}
+
+// promptContext is everything that goes into the prompt.
+type promptContext struct {
+	question string
+	movies   []knowledgegraph.Movie
+	profile  *profile.TasteProfile
+	seed     *knowledgegraph.Movie
+	// connections holds the rendered graph paths per movieId.
+	connections map[string][]string
+	passages    []knowledgegraph.ChunkMatch
+}
+
+// contextSegments renders the movies and then the passages of the prompt as
+// segments, best first, so they can be packed into the context window.
+func contextSegments(pc promptContext) []packing.Segment {
+	var segments []packing.Segment
+	for _, movie := range pc.movies {
+		text := fmt.Sprintf("Title: %s\nPlot: %s\n", movie.Title, movie.Plot)
+		if paths := pc.connections[movie.MovieID]; len(paths) > 0 {
+			text += "Connections:\n"
+			for _, path := range paths {
+				text += fmt.Sprintf("- %s %s\n", movie.Title, path)
+			}
+		}
+		segments = append(segments, packing.Segment{
+			Kind:  "movie",
+			ID:    movie.MovieID,
+			Label: citations.Label(citations.MovieSource(movie)),
+			Text:  text,
+		})
+	}
+	for _, passage := range pc.passages {
+		text := fmt.Sprintf("Source: %s\n", passage.Chunk.ChunkID)
+		if passage.Chunk.Heading != "" {
+			text += fmt.Sprintf("Section: %s\n", passage.Chunk.Heading)
+		}
+		if len(passage.Entities) > 0 {
+			var entities []string
+			for _, entity := range passage.Entities {
+				entities = append(entities, fmt.Sprintf("%s (%s)", entity.Name, entity.Type))
+			}
+			text += fmt.Sprintf("Mentions: %s\n", strings.Join(entities, ", "))
+		}
+		text += passage.Chunk.Text + "\n"
+		segments = append(segments, packing.Segment{
+			Kind:  "passage",
+			ID:    passage.Chunk.ChunkID,
+			Label: citations.Label(citations.PassageSource(passage)),
+			Text:  text,
+		})
+	}
+	return segments
+}
+
+// defaultPromptTemplate is the prompt of -template default. See promptData for
+// the fields of a template.
+const defaultPromptTemplate = `
+You are a movie expert. You decide which movie to watch based on the plot. Below are some movies with
+plots based on the query of the user. You can only suggest movies from the list provided.
+
+### Movies:
+---
+{{.Movies}}{{.Passages}}{{.Seed}}{{.User}}{{.Connections}}{{.Citations}}
+Question: {{.Question}} What movie from the list provided above should I watch?
+You can only suggest movies from the list provided.
+	`
+
+// promptData is what a prompt template is executed with. Every field is
+// rendered text; fields that don't apply are empty.
+type promptData struct {
+	// Movies are the labelled movies, separated by ---.
+	Movies string
+	// Passages are the labelled passages from documents under a heading.
+	Passages string
+	// Seed describes the movie of -movie.
+	Seed string
+	// User describes the taste profile of -user and asks to explain why each
+	// movie fits it.
+	User string
+	// Connections asks to justify suggestions with the graph paths of
+	// -explain.
+	Connections string
+	// Citations asks to cite the labels of the movies and passages.
+	Citations string
+	// Question is the question of the user as a sentence.
+	Question string
+}
+
+// loadTemplate parses the prompt template in the file, or the default prompt
+// for "default" or an empty path.
+func loadTemplate(path string) (*template.Template, error) {
+	if path == "" || path == "default" {
+		return template.New("default").Parse(defaultPromptTemplate)
+	}
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse prompt template: %w", err)
+	}
+	// Catch fields that don't exist now rather than at the first question.
+	err = tmpl.Execute(io.Discard, promptData{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute prompt template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// buildPrompt creates the prompt for the question of the user, with the
+// segments of contextSegments as context. With a taste profile the model is
+// also asked to explain why each movie fits the user. With a seed movie the
+// question is about movies like the seed, optionally combined with the
+// question. With connections the model is asked to justify its picks with
+// them. Passages from documents are added after the movies. The model is asked
+// to cite the IDs the segments are labelled with.
+func buildPrompt(tmpl *template.Template, pc promptContext, segments []packing.Segment) (string, error) {
+	question, p, seed := pc.question, pc.profile, pc.seed
+
+	var moviesStr, passagesStr string
+	for _, segment := range segments {
+		switch segment.Kind {
+		case "movie":
+			moviesStr += segment.String() + "---\n"
+		case "passage":
+			passagesStr += segment.String() + "---\n"
+		}
+	}
+	if passagesStr != "" {
+		passagesStr = "\n### Passages:\n---\n" + passagesStr
+	}
+
+	var connectionsStr string
+	if len(pc.connections) > 0 {
+		connectionsStr = `
+The connections listed with a movie are facts from the knowledge graph. Use them to justify why you suggest
+a movie, and don't make up connections that are not listed.
+`
+	}
+
+	var userStr string
+	if p != nil {
+		userStr = fmt.Sprintf(`
+### About the user:
+%s
+The user has not seen any of the movies in the list. For every movie you suggest, explain why it fits
+the taste of the user, based on their favourite movies and genres.
+`, p.Summary())
+	}
+
+	questionStr := fmt.Sprintf("I want to watch a movie about %s.", question)
+	var seedStr string
+	if seed != nil {
+		seedStr = fmt.Sprintf(`
+### Movie the user loved:
+Title: %s
+Plot: %s
+Genres: %s
+Directors: %s
+Actors: %s
+`, seed.Title, seed.Plot, strings.Join(seed.Genres, ", "), strings.Join(seed.Directors, ", "), strings.Join(seed.Actors, ", "))
+		if question == "" {
+			questionStr = fmt.Sprintf("I loved %s and want to watch a movie like it.", seed.Title)
+		} else {
+			questionStr = fmt.Sprintf("I loved %s and want to watch a movie like it, about %s.", seed.Title, question)
+		}
+	}
+
+	var prompt strings.Builder
+	err := tmpl.Execute(&prompt, promptData{
+		Movies:      moviesStr,
+		Passages:    passagesStr,
+		Seed:        seedStr,
+		User:        userStr,
+		Connections: connectionsStr,
+		Citations:   citations.Instructions,
+		Question:    questionStr,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to execute prompt template: %w", err)
+	}
+	return prompt.String(), nil
+}
+
+// readStream writes the streamed answer to writer as it comes in and returns
+// the whole answer, with the last chunk for its usage and backend.
+func readStream(stream *llm.Stream, writer io.Writer) (string, llm.Chunk, error) {
+	defer stream.Close()
+	var answer strings.Builder
+	var last llm.Chunk
+	for stream.Next() {
+		chunk := stream.Chunk()
+		_, err := writer.Write([]byte(chunk.Text))
+		if err != nil {
+			return "", llm.Chunk{}, err
+		}
+		answer.WriteString(chunk.Text)
+		if chunk.Done {
+			last = chunk
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return "", llm.Chunk{}, err
+	}
+	_, err := writer.Write([]byte("\n"))
+	if err != nil {
+		return "", llm.Chunk{}, err
+	}
+	return answer.String(), last, nil
 }
 
 func retrieveAdditionalContext(ctx context.Context, kg knowledgegraph.KnowledgeGraph) ([]knowledgegraph.Movie, error) {